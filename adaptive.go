@@ -0,0 +1,372 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"unsafe"
+)
+
+// AdaptiveBloomFilter starts out storing the positions of its set bits in a
+// sorted slice and switches to the dense cache line array of a
+// CacheOptimizedBloomFilter once that would take less memory. Positions are
+// computed exactly as in CacheOptimizedBloomFilter, so Contains answers are
+// identical in either representation and both serialize to the same format.
+// It suits large numbers of filters sized for a peak most of them never reach.
+type AdaptiveBloomFilter struct {
+	bitCount       uint64
+	hashCount      uint32
+	cacheLineCount uint64
+
+	// Set bit positions in ascending order while sparse
+	sparse []uint64
+	// Recently set positions in ascending order, disjoint from sparse and
+	// merged into it once they outgrow its square root, so inserts do not
+	// move the whole of sparse each time
+	pending []uint64
+	// Dense representation; nil while sparse
+	dense *CacheOptimizedBloomFilter
+
+	// Sparse positions allowed before converting
+	threshold int
+	// Options used to allocate the dense representation
+	opts filterOptions
+}
+
+// NewAdaptiveBloomFilter creates a sparse filter sized like
+// NewCacheOptimizedBloomFilter. The options apply to the dense
+// representation once the filter converts to it.
+func NewAdaptiveBloomFilter(expectedElements uint64, falsePositiveRate float64, opts ...Option) *AdaptiveBloomFilter {
+	cacheLineCount, hashCount := optimalParameters(expectedElements, falsePositiveRate)
+	return newAdaptiveFilter(cacheLineCount, hashCount, applyOptions(opts))
+}
+
+// newAdaptiveFilter creates an empty sparse filter with the given parameters
+func newAdaptiveFilter(cacheLineCount uint64, hashCount uint32, opts filterOptions) *AdaptiveBloomFilter {
+	return &AdaptiveBloomFilter{
+		bitCount:       cacheLineCount * BitsPerCacheLine,
+		hashCount:      hashCount,
+		cacheLineCount: cacheLineCount,
+		// Convert once the positions would take a quarter of the dense array
+		threshold: int(cacheLineCount * CacheLineSize / 8 / 4),
+		opts:      opts,
+	}
+}
+
+// Add adds an element
+func (af *AdaptiveBloomFilter) Add(data []byte) {
+	af.addHashPair(hashOptimized1(data), hashOptimized2(data))
+}
+
+// Contains checks membership
+func (af *AdaptiveBloomFilter) Contains(data []byte) bool {
+	return af.containsHashPair(hashOptimized1(data), hashOptimized2(data))
+}
+
+// AddString adds a string element
+func (af *AdaptiveBloomFilter) AddString(s string) {
+	af.Add(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// ContainsString checks if a string element exists
+func (af *AdaptiveBloomFilter) ContainsString(s string) bool {
+	return af.Contains(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// AddUint64 adds a uint64 element
+func (af *AdaptiveBloomFilter) AddUint64(n uint64) {
+	af.Add((*[8]byte)(unsafe.Pointer(&n))[:])
+}
+
+// ContainsUint64 checks if a uint64 element exists
+func (af *AdaptiveBloomFilter) ContainsUint64(n uint64) bool {
+	return af.Contains((*[8]byte)(unsafe.Pointer(&n))[:])
+}
+
+// addHashPair adds an element given its double hashing pair
+func (af *AdaptiveBloomFilter) addHashPair(h1, h2 uint64) {
+	if af.dense != nil {
+		af.dense.addHashPair(h1, h2)
+		return
+	}
+
+	for i := uint32(0); i < af.hashCount; i++ {
+		af.insertPosition(reduceRange(h1+uint64(i)*h2, af.bitCount))
+	}
+	if len(af.sparse)+len(af.pending) > af.threshold {
+		af.convertToDense()
+	}
+}
+
+// containsHashPair checks membership given a double hashing pair
+func (af *AdaptiveBloomFilter) containsHashPair(h1, h2 uint64) bool {
+	if af.dense != nil {
+		return af.dense.containsHashPair(h1, h2)
+	}
+
+	for i := uint32(0); i < af.hashCount; i++ {
+		if !af.hasPosition(reduceRange(h1+uint64(i)*h2, af.bitCount)) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPosition reports whether a bit position is set while sparse
+func (af *AdaptiveBloomFilter) hasPosition(pos uint64) bool {
+	if _, found := slices.BinarySearch(af.sparse, pos); found {
+		return true
+	}
+	_, found := slices.BinarySearch(af.pending, pos)
+	return found
+}
+
+// Fewest pending positions merged into sparse at once
+const minPendingPositions = 16
+
+// insertPosition adds a bit position to the sparse set
+func (af *AdaptiveBloomFilter) insertPosition(pos uint64) {
+	if _, found := slices.BinarySearch(af.sparse, pos); found {
+		return
+	}
+	if i, found := slices.BinarySearch(af.pending, pos); !found {
+		af.pending = slices.Insert(af.pending, i, pos)
+	}
+	if len(af.pending) > max(minPendingPositions, int(math.Sqrt(float64(len(af.sparse))))) {
+		af.mergePending()
+	}
+}
+
+// mergePending merges the pending positions into sparse in place, from the
+// back so nothing is overwritten before it has been moved
+func (af *AdaptiveBloomFilter) mergePending() {
+	if len(af.pending) == 0 {
+		return
+	}
+	i, j := len(af.sparse)-1, len(af.pending)-1
+	af.sparse = slices.Grow(af.sparse, len(af.pending))[:len(af.sparse)+len(af.pending)]
+	for k := len(af.sparse) - 1; j >= 0; k-- {
+		if i >= 0 && af.sparse[i] > af.pending[j] {
+			af.sparse[k] = af.sparse[i]
+			i--
+		} else {
+			af.sparse[k] = af.pending[j]
+			j--
+		}
+	}
+	af.pending = af.pending[:0]
+}
+
+// forEachPosition calls fn with each set position in ascending order while sparse
+func (af *AdaptiveBloomFilter) forEachPosition(fn func(pos uint64)) {
+	i, j := 0, 0
+	for i < len(af.sparse) || j < len(af.pending) {
+		if j == len(af.pending) || (i < len(af.sparse) && af.sparse[i] < af.pending[j]) {
+			fn(af.sparse[i])
+			i++
+		} else {
+			fn(af.pending[j])
+			j++
+		}
+	}
+}
+
+// convertToDense moves the set bits into a dense cache line array
+func (af *AdaptiveBloomFilter) convertToDense() {
+	af.dense = af.ToDense()
+	af.sparse = nil
+	af.pending = nil
+}
+
+// IsSparse reports whether the filter still uses the sparse representation
+func (af *AdaptiveBloomFilter) IsSparse() bool {
+	return af.dense == nil
+}
+
+// PopCount counts set bits
+func (af *AdaptiveBloomFilter) PopCount() uint64 {
+	if af.dense != nil {
+		return af.dense.PopCount()
+	}
+	return uint64(len(af.sparse) + len(af.pending))
+}
+
+// EstimatedFPP calculates the estimated false positive probability
+func (af *AdaptiveBloomFilter) EstimatedFPP() float64 {
+	ratio := float64(af.PopCount()) / float64(af.bitCount)
+	return math.Pow(ratio, float64(af.hashCount))
+}
+
+// MemoryUsage returns the bytes held by the current representation
+func (af *AdaptiveBloomFilter) MemoryUsage() uint64 {
+	if af.dense != nil {
+		return af.dense.memory.size
+	}
+	return uint64(cap(af.sparse)+cap(af.pending)) * 8
+}
+
+// Clear empties the filter and returns it to the sparse representation
+func (af *AdaptiveBloomFilter) Clear() {
+	af.sparse = nil
+	af.pending = nil
+	af.dense = nil
+}
+
+// Union adds every element of other, which must have the same parameters.
+// The result is dense if either input is or if the merged positions cross
+// the threshold.
+func (af *AdaptiveBloomFilter) Union(other *AdaptiveBloomFilter) error {
+	if af.cacheLineCount != other.cacheLineCount || af.hashCount != other.hashCount {
+		return fmt.Errorf("bloom filters must have same size for union")
+	}
+
+	switch {
+	case other.dense != nil:
+		if af.dense == nil {
+			af.convertToDense()
+		}
+		return af.dense.Union(other.dense)
+	case af.dense != nil:
+		af.dense.setBitCacheOptimized(other.sparse)
+		af.dense.setBitCacheOptimized(other.pending)
+	default:
+		af.mergePending()
+		merged := make([]uint64, 0, len(af.sparse)+len(other.sparse)+len(other.pending))
+		i := 0
+		other.forEachPosition(func(pos uint64) {
+			for ; i < len(af.sparse) && af.sparse[i] < pos; i++ {
+				merged = append(merged, af.sparse[i])
+			}
+			if i < len(af.sparse) && af.sparse[i] == pos {
+				i++
+			}
+			merged = append(merged, pos)
+		})
+		af.sparse = append(merged, af.sparse[i:]...)
+		if len(af.sparse) > af.threshold {
+			af.convertToDense()
+		}
+	}
+	return nil
+}
+
+// ToDense returns a CacheOptimizedBloomFilter holding the same bits, for
+// use with the rest of the package. It never shares storage with af.
+func (af *AdaptiveBloomFilter) ToDense() *CacheOptimizedBloomFilter {
+	dense := newFilterWithMemory(af.cacheLineCount, af.hashCount, allocateCacheLines(af.cacheLineCount, af.opts))
+	if af.dense != nil {
+		copy(dense.cacheLines, af.dense.cacheLines)
+		return dense
+	}
+	dense.setBitCacheOptimized(af.sparse)
+	dense.setBitCacheOptimized(af.pending)
+	return dense
+}
+
+// header returns the serialized header describing this filter
+func (af *AdaptiveBloomFilter) header() filterHeader {
+	return filterHeader{
+		version:        formatVersion,
+		hashCount:      af.hashCount,
+		bitCount:       af.bitCount,
+		cacheLineCount: af.cacheLineCount,
+		payloadSize:    af.cacheLineCount * CacheLineSize,
+	}
+}
+
+// forEachGap calls fn with the gap before each sparse position
+func (af *AdaptiveBloomFilter) forEachGap(fn func(gap uint64)) {
+	next := uint64(0)
+	af.forEachPosition(func(pos uint64) {
+		fn(pos - next)
+		next = pos + 1
+	})
+}
+
+// WriteTo writes the filter in the package's binary format, implementing
+// io.WriterTo. Sparse filters are written compressed without building the
+// dense array; dense ones are written raw like CacheOptimizedBloomFilter.
+func (af *AdaptiveBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	if af.dense != nil {
+		return af.dense.WriteTo(w)
+	}
+	if h, ok := compressedHeaderFor(af.header(), af.PopCount(), af.forEachGap); ok {
+		return writeCompressed(w, h, af.forEachGap)
+	}
+	return af.ToDense().WriteTo(w)
+}
+
+// MarshalBinary encodes the filter like WriteTo
+func (af *AdaptiveBloomFilter) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := af.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadFrom replaces the filter's contents with one filter read from r in the
+// package's binary format, written by either filter type, implementing
+// io.ReaderFrom. The filter is sparse again if few enough bits are set.
+func (af *AdaptiveBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	var headerBuf [headerSize]byte
+	n, err := io.ReadFull(r, headerBuf[:])
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+	h, err := decodeHeader(headerBuf[:])
+	if err != nil {
+		return total, err
+	}
+
+	filter := newAdaptiveFilter(h.cacheLineCount, h.hashCount, af.opts)
+	if h.compressed() && h.setBits <= uint64(filter.threshold) {
+		// Positions are only kept as they are decoded, so the header's count
+		// is not trusted for the allocation
+		n, err := readCompressed(h, r, func(pos uint64) {
+			filter.sparse = append(filter.sparse, pos)
+		})
+		total += n
+		if err != nil {
+			return total, err
+		}
+		*af = *filter
+		return total, nil
+	}
+
+	// Everything else is decoded densely
+	dense := &CacheOptimizedBloomFilter{}
+	n64, err := dense.readPayloadFrom(h, r)
+	total += n64
+	if err != nil {
+		return total, err
+	}
+	if dense.PopCount() <= uint64(filter.threshold) {
+		next := uint64(0)
+		dense.forEachGap(func(gap uint64) {
+			filter.sparse = append(filter.sparse, next+gap)
+			next += gap + 1
+		})
+	} else {
+		// Move into storage allocated with the filter's options
+		filter.dense = newFilterWithMemory(h.cacheLineCount, h.hashCount, allocateCacheLines(h.cacheLineCount, af.opts))
+		copy(filter.dense.cacheLines, dense.cacheLines)
+	}
+	*af = *filter
+	return total, nil
+}
+
+// UnmarshalBinary replaces the filter's contents with data in the package's binary format
+func (af *AdaptiveBloomFilter) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := af.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidFormat, r.Len())
+	}
+	return nil
+}
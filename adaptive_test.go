@@ -0,0 +1,138 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// TestAdaptiveMatchesDense tests that Contains agrees with a dense filter before and after conversion
+func TestAdaptiveMatchesDense(t *testing.T) {
+	af := NewAdaptiveBloomFilter(10000, 0.01)
+	bf := NewCacheOptimizedBloomFilter(10000, 0.01)
+
+	check := func(stage string) {
+		t.Helper()
+		for i := 0; i < 20000; i++ {
+			key := fmt.Sprintf("key_%d", i)
+			if af.ContainsString(key) != bf.ContainsString(key) {
+				t.Fatalf("%s: Contains(%q) differs from the dense filter", stage, key)
+			}
+		}
+		if af.PopCount() != bf.PopCount() {
+			t.Fatalf("%s: PopCount %d, dense filter has %d", stage, af.PopCount(), bf.PopCount())
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		af.AddString(fmt.Sprintf("key_%d", i))
+		bf.AddString(fmt.Sprintf("key_%d", i))
+	}
+	if !af.IsSparse() {
+		t.Fatal("Expected a lightly loaded filter to be sparse")
+	}
+	if af.MemoryUsage() >= bf.GetCacheStats().MemoryUsage/4 {
+		t.Errorf("Sparse filter uses %d bytes, dense uses %d", af.MemoryUsage(), bf.GetCacheStats().MemoryUsage)
+	}
+	check("sparse")
+
+	for i := 50; i < 5000; i++ {
+		af.AddString(fmt.Sprintf("key_%d", i))
+		bf.AddString(fmt.Sprintf("key_%d", i))
+	}
+	if af.IsSparse() {
+		t.Fatal("Expected the filter to convert to dense")
+	}
+	check("dense")
+	assertSameFilter(t, bf, af.ToDense())
+}
+
+// TestAdaptiveUnion tests union across every combination of representations
+func TestAdaptiveUnion(t *testing.T) {
+	build := func(prefix string, count int) *AdaptiveBloomFilter {
+		af := NewAdaptiveBloomFilter(10000, 0.01)
+		for i := 0; i < count; i++ {
+			af.AddString(fmt.Sprintf("%s_%d", prefix, i))
+		}
+		return af
+	}
+
+	for _, tc := range []struct {
+		name         string
+		left, right  int
+		expectSparse bool
+	}{
+		{"SparseSparse", 20, 30, true},
+		{"SparseDense", 20, 3000, false},
+		{"DenseSparse", 3000, 20, false},
+		{"DenseDense", 3000, 3000, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			left, right := build("left", tc.left), build("right", tc.right)
+			want := left.ToDense()
+			want.Union(right.ToDense())
+
+			if err := left.Union(right); err != nil {
+				t.Fatal(err)
+			}
+			if left.IsSparse() != tc.expectSparse {
+				t.Errorf("IsSparse = %v after union", left.IsSparse())
+			}
+			assertSameFilter(t, want, left.ToDense())
+		})
+	}
+
+	if err := build("a", 1).Union(NewAdaptiveBloomFilter(100, 0.01)); err == nil {
+		t.Error("Expected error for union of different sizes")
+	}
+}
+
+// TestAdaptiveSerialization tests that both representations share the package's binary format
+func TestAdaptiveSerialization(t *testing.T) {
+	for _, count := range []int{0, 40, 5000} {
+		t.Run(fmt.Sprintf("Elements%d", count), func(t *testing.T) {
+			af := NewAdaptiveBloomFilter(10000, 0.01)
+			for i := 0; i < count; i++ {
+				af.AddString(fmt.Sprintf("item_%d", i))
+			}
+
+			data, err := af.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if af.IsSparse() && len(data) >= headerSize+int(af.cacheLineCount*CacheLineSize) {
+				t.Errorf("Sparse filter serialized at full size (%d bytes)", len(data))
+			}
+
+			// Readable as a dense filter
+			bf := &CacheOptimizedBloomFilter{}
+			if err := bf.UnmarshalBinary(data); err != nil {
+				t.Fatalf("CacheOptimizedBloomFilter cannot read adaptive data: %v", err)
+			}
+			assertSameFilter(t, af.ToDense(), bf)
+
+			// And back, from the raw format
+			raw, _ := bf.MarshalBinary()
+			restored := &AdaptiveBloomFilter{}
+			if err := restored.UnmarshalBinary(raw); err != nil {
+				t.Fatal(err)
+			}
+			if restored.IsSparse() != af.IsSparse() {
+				t.Errorf("Representation changed on reload: sparse %v, was %v", restored.IsSparse(), af.IsSparse())
+			}
+			assertSameFilter(t, bf, restored.ToDense())
+
+			var buf bytes.Buffer
+			af.WriteTo(&buf)
+			buf.WriteString("tail")
+			streamed := &AdaptiveBloomFilter{}
+			if _, err := streamed.ReadFrom(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != "tail" {
+				t.Error("ReadFrom consumed data past the filter")
+			}
+			assertSameFilter(t, bf, streamed.ToDense())
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package bloomfilter
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// Size of a transparent huge page on amd64 and arm64 Linux
+const hugePageSize = 2 << 20
+
+// AllocationStrategy describes where a filter's cache lines were allocated
+type AllocationStrategy int
+
+const (
+	// AllocationHeap is a regular Go heap allocation
+	AllocationHeap AllocationStrategy = iota
+	// AllocationMmap is an anonymous mapping with regular pages
+	AllocationMmap
+	// AllocationTransparentHugePages is an anonymous mapping advised with MADV_HUGEPAGE
+	AllocationTransparentHugePages
+	// AllocationHugeTLB is an anonymous mapping from the reserved huge page pool (MAP_HUGETLB)
+	AllocationHugeTLB
+	// AllocationFileMapping is a shared mapping of a file opened with OpenMapped
+	AllocationFileMapping
+)
+
+// String returns a short name for the allocation strategy
+func (s AllocationStrategy) String() string {
+	switch s {
+	case AllocationHeap:
+		return "heap"
+	case AllocationMmap:
+		return "mmap"
+	case AllocationTransparentHugePages:
+		return "thp"
+	case AllocationHugeTLB:
+		return "hugetlb"
+	case AllocationFileMapping:
+		return "file"
+	}
+	return "unknown"
+}
+
+// cacheLineMemory owns the storage behind a filter's cache lines.
+//
+// The cacheLines slice of a filter may point into the middle of a heap buffer
+// (to reach 64-byte alignment) or into an mmap region the GC knows nothing
+// about, so the owning buffer is kept here and the filter keeps a reference
+// to this struct for as long as it lives. Mapped storage is released by a
+// finalizer, so code handing raw cache line pointers to SIMD routines must
+// keep the filter reachable (runtime.KeepAlive) until those routines return.
+type cacheLineMemory struct {
+	lines    []CacheLine // 64-byte aligned view used by the filter
+	backing  []uint64    // heap buffer retained so the GC cannot free it
+	mapped   []byte      // anonymous mapping, nil for heap allocations
+	size     uint64      // bytes actually allocated, including alignment slack
+	strategy AllocationStrategy
+	locked   bool // mapped is mlocked
+}
+
+// allocateCacheLines returns zeroed, 64-byte aligned storage for count cache lines
+func allocateCacheLines(count uint64, opts filterOptions) *cacheLineMemory {
+	if count == 0 {
+		return &cacheLineMemory{}
+	}
+
+	if opts.lockMemory || opts.wantsHugePages(count) {
+		if mem := mapCacheLines(count, opts); mem != nil {
+			runtime.SetFinalizer(mem, (*cacheLineMemory).release)
+			return mem
+		}
+	}
+
+	return allocateHeapCacheLines(count)
+}
+
+// wantsHugePages reports whether huge pages were requested and the filter is big enough for them
+func (o filterOptions) wantsHugePages(count uint64) bool {
+	return (o.hugePages || o.hugeTLB) && count*CacheLineSize >= hugePageSize
+}
+
+// allocateHeapCacheLines allocates aligned cache lines on the Go heap.
+// Large allocations are page aligned already; otherwise the buffer is
+// over-allocated by one cache line and the view starts at the aligned offset.
+func allocateHeapCacheLines(count uint64) *cacheLineMemory {
+	words := count * WordsPerCacheLine
+
+	backing := make([]uint64, words)
+	if uintptr(unsafe.Pointer(&backing[0]))%CacheLineSize != 0 {
+		backing = make([]uint64, words+WordsPerCacheLine-1)
+	}
+
+	offset := (-uintptr(unsafe.Pointer(&backing[0])) & (CacheLineSize - 1)) / 8
+	lines := unsafe.Slice((*CacheLine)(unsafe.Pointer(&backing[offset])), count)
+
+	return &cacheLineMemory{
+		lines:    lines,
+		backing:  backing,
+		size:     uint64(len(backing)) * 8,
+		strategy: AllocationHeap,
+	}
+}
+
+// release unmaps mmap-backed storage; heap storage is left to the GC
+func (m *cacheLineMemory) release() {
+	if m.mapped != nil {
+		// munmap also drops any mlock on the range
+		unmapCacheLines(m.mapped)
+		m.mapped = nil
+		m.lines = nil
+		m.locked = false
+	}
+}
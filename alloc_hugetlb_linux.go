@@ -0,0 +1,8 @@
+//go:build linux && !arm
+
+package bloomfilter
+
+import "syscall"
+
+// mapHugeTLB is the mmap flag requesting huge TLB pages
+const mapHugeTLB = syscall.MAP_HUGETLB
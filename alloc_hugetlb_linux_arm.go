@@ -0,0 +1,6 @@
+package bloomfilter
+
+// mapHugeTLB is zero because syscall does not define MAP_HUGETLB on
+// linux/arm; the huge TLB attempt is skipped and huge pages are requested
+// with MADV_HUGEPAGE instead
+const mapHugeTLB = 0
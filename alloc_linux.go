@@ -0,0 +1,61 @@
+//go:build linux
+
+package bloomfilter
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mapCacheLines allocates cache lines from an anonymous mapping, trying
+// MAP_HUGETLB first and then MADV_HUGEPAGE when huge pages were requested,
+// and mlocks the mapping when asked to. It returns nil if no mapping can be
+// created, in which case the caller falls back to the heap.
+func mapCacheLines(count uint64, opts filterOptions) *cacheLineMemory {
+	huge := opts.wantsHugePages(count)
+
+	pageSize := uint64(os.Getpagesize())
+	if huge {
+		pageSize = hugePageSize
+	}
+	size := (count*CacheLineSize + pageSize - 1) &^ (pageSize - 1)
+
+	const prot = syscall.PROT_READ | syscall.PROT_WRITE
+	const flags = syscall.MAP_ANONYMOUS | syscall.MAP_PRIVATE
+
+	var mapped []byte
+	strategy := AllocationMmap
+
+	if huge && opts.hugeTLB && mapHugeTLB != 0 {
+		if m, err := syscall.Mmap(-1, 0, int(size), prot, flags|mapHugeTLB); err == nil {
+			mapped, strategy = m, AllocationHugeTLB
+		}
+	}
+
+	if mapped == nil {
+		m, err := syscall.Mmap(-1, 0, int(size), prot, flags)
+		if err != nil {
+			return nil
+		}
+		mapped = m
+
+		// The advice is only a hint; without THP support the mapping still works
+		if huge && syscall.Madvise(mapped, syscall.MADV_HUGEPAGE) == nil {
+			strategy = AllocationTransparentHugePages
+		}
+	}
+
+	mem := &cacheLineMemory{
+		lines:    unsafe.Slice((*CacheLine)(unsafe.Pointer(&mapped[0])), count),
+		mapped:   mapped,
+		size:     size,
+		strategy: strategy,
+	}
+
+	if opts.lockMemory {
+		mem.locked = syscall.Mlock(mapped) == nil
+	}
+
+	return mem
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package bloomfilter
+
+// mapCacheLines is unsupported off Linux; callers fall back to the heap
+func mapCacheLines(count uint64, opts filterOptions) *cacheLineMemory {
+	return nil
+}
@@ -0,0 +1,160 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+// TestAlignedAllocation tests that cache line storage is aligned and fully usable
+func TestAlignedAllocation(t *testing.T) {
+	for _, count := range []uint64{1, 2, 3, 7, 64, 1000, 70000} {
+		mem := allocateCacheLines(count, filterOptions{})
+
+		if uint64(len(mem.lines)) != count {
+			t.Fatalf("Count %d: expected %d lines, got %d", count, count, len(mem.lines))
+		}
+		if offset := uintptr(unsafe.Pointer(&mem.lines[0])) % CacheLineSize; offset != 0 {
+			t.Errorf("Count %d: storage misaligned by %d bytes", count, offset)
+		}
+		if mem.size < count*CacheLineSize {
+			t.Errorf("Count %d: reported size %d is smaller than the lines", count, mem.size)
+		}
+
+		// The whole aligned view must lie inside the retained backing buffer
+		start := uintptr(unsafe.Pointer(&mem.backing[0]))
+		end := start + uintptr(len(mem.backing))*8
+		last := uintptr(unsafe.Pointer(&mem.lines[count-1])) + CacheLineSize
+		if uintptr(unsafe.Pointer(&mem.lines[0])) < start || last > end {
+			t.Errorf("Count %d: aligned view escapes the backing buffer", count)
+		}
+	}
+}
+
+// TestAllocationSurvivesGC tests that filter storage stays valid across garbage collections
+func TestAllocationSurvivesGC(t *testing.T) {
+	filters := make([]*CacheOptimizedBloomFilter, 16)
+	for i := range filters {
+		filters[i] = NewCacheOptimizedBloomFilter(uint64(100+i*37), 0.01)
+		for j := 0; j < 50; j++ {
+			filters[i].AddString(fmt.Sprintf("gc_%d_%d", i, j))
+		}
+	}
+
+	// Churn the heap so freed memory would be reused and overwritten
+	for round := 0; round < 5; round++ {
+		garbage := make([][]byte, 1000)
+		for i := range garbage {
+			garbage[i] = make([]byte, 1024)
+			for j := range garbage[i] {
+				garbage[i][j] = 0xFF
+			}
+		}
+		runtime.GC()
+	}
+
+	for i, bf := range filters {
+		for j := 0; j < 50; j++ {
+			if !bf.ContainsString(fmt.Sprintf("gc_%d_%d", i, j)) {
+				t.Fatalf("Filter %d lost element %d after GC", i, j)
+			}
+		}
+		if stats := bf.GetCacheStats(); stats.Alignment != 0 {
+			t.Errorf("Filter %d misaligned by %d bytes", i, stats.Alignment)
+		}
+	}
+}
+
+// TestHugePageAllocation tests that huge page backed filters behave like heap backed ones
+func TestHugePageAllocation(t *testing.T) {
+	// Large enough to exceed one huge page
+	bf := NewCacheOptimizedBloomFilter(5000000, 0.01, WithHugePages())
+	stats := bf.GetCacheStats()
+
+	if stats.Alignment != 0 {
+		t.Errorf("Huge page filter misaligned by %d bytes", stats.Alignment)
+	}
+	if stats.MemoryUsage < stats.CacheLineCount*CacheLineSize {
+		t.Errorf("MemoryUsage %d smaller than cache line storage", stats.MemoryUsage)
+	}
+	t.Logf("Huge page filter: %d cache lines, %d bytes allocated, strategy=%s",
+		stats.CacheLineCount, stats.MemoryUsage, stats.AllocationStrategy)
+	if runtime.GOOS == "linux" && stats.AllocationStrategy == AllocationHeap {
+		t.Error("Expected a mapped allocation on Linux")
+	}
+
+	for i := 0; i < 1000; i++ {
+		bf.AddUint64(uint64(i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !bf.ContainsUint64(uint64(i)) {
+			t.Fatalf("Huge page filter lost element %d", i)
+		}
+	}
+
+	bf.Clear()
+	if bf.PopCount() != 0 {
+		t.Error("Clear did not reset huge page filter")
+	}
+
+	// Small filters stay on the heap even when huge pages are requested
+	small := NewCacheOptimizedBloomFilter(1000, 0.01, WithHugePages())
+	if strategy := small.GetCacheStats().AllocationStrategy; strategy != AllocationHeap {
+		t.Errorf("Expected small filter to use the heap, got %s", strategy)
+	}
+}
+
+// TestAllocationStrategies tests the fallback chain of the allocation options
+func TestAllocationStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		elements uint64
+		opts     []Option
+		allowed  []AllocationStrategy
+	}{
+		{"Default", 5000000, nil, []AllocationStrategy{AllocationHeap}},
+		{"HugeTLB", 5000000, []Option{WithHugeTLB()},
+			[]AllocationStrategy{AllocationHugeTLB, AllocationTransparentHugePages, AllocationMmap, AllocationHeap}},
+		{"LockedSmall", 1000, []Option{WithLockedMemory()},
+			[]AllocationStrategy{AllocationMmap, AllocationHeap}},
+		{"LockedHuge", 5000000, []Option{WithHugePages(), WithLockedMemory()},
+			[]AllocationStrategy{AllocationTransparentHugePages, AllocationMmap, AllocationHeap}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bf := NewCacheOptimizedBloomFilter(tt.elements, 0.01, tt.opts...)
+			stats := bf.GetCacheStats()
+			t.Logf("strategy=%s locked=%t memory=%d", stats.AllocationStrategy, stats.MemoryLocked, stats.MemoryUsage)
+
+			allowed := false
+			for _, strategy := range tt.allowed {
+				allowed = allowed || strategy == stats.AllocationStrategy
+			}
+			if !allowed {
+				t.Errorf("Unexpected allocation strategy %s", stats.AllocationStrategy)
+			}
+			if stats.MemoryLocked && stats.AllocationStrategy == AllocationHeap {
+				t.Error("Heap allocations must never report locked memory")
+			}
+			if stats.Alignment != 0 {
+				t.Errorf("Storage misaligned by %d bytes", stats.Alignment)
+			}
+
+			bf.AddString("strategy")
+			if !bf.ContainsString("strategy") {
+				t.Error("Filter lost an element")
+			}
+		})
+	}
+
+	for strategy, name := range map[AllocationStrategy]string{
+		AllocationHeap: "heap", AllocationMmap: "mmap",
+		AllocationTransparentHugePages: "thp", AllocationHugeTLB: "hugetlb",
+	} {
+		if strategy.String() != name {
+			t.Errorf("Expected %q, got %q", name, strategy.String())
+		}
+	}
+}
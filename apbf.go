@@ -0,0 +1,229 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"runtime"
+	"unsafe"
+)
+
+// AgePartitionedBloomFilter is an age-partitioned bloom filter (Shtul,
+// Baquero and Almeida, 2020) for membership that decays smoothly over time.
+// It has k+l slices, each a bit array with its own hash function. An element
+// sets one bit in each of the k newest slices; after every generation of g
+// insertions the slices shift by one, the oldest being cleared and reused as
+// the newest. An element is reported present when k consecutive slices
+// contain it, so it stays visible for at least l generations after the one
+// it was added in and fades out over the next one, instead of disappearing
+// at a fixed rotation boundary.
+//
+// Slices are stored back to back in the same 64-byte aligned cache lines the
+// bloom filter uses.
+type AgePartitionedBloomFilter struct {
+	cacheLines    []CacheLine
+	memory        *cacheLineMemory
+	k, l          int
+	linesPerSlice uint64
+	sliceBits     uint64
+
+	generationSize uint64
+	// Insertions into the current generation
+	generationCount uint64
+	// Physical index of the newest slice
+	base int
+
+	simdOps SIMDOperations
+}
+
+// NewAgePartitionedBloomFilter creates a filter with k+l slices that shifts
+// after every generationSize insertions. Each slice is sized so that it is
+// about half full once it leaves the k newest, so a false positive run can
+// start at any of l+1 slices with probability about 2^-k each: a longer
+// window needs a larger k for the same rate. EstimatedFPP reports the exact
+// figure for the current fill. Options control the allocation as for
+// NewCacheOptimizedBloomFilter.
+func NewAgePartitionedBloomFilter(k, l int, generationSize uint64, opts ...Option) (*AgePartitionedBloomFilter, error) {
+	if k < 1 || l < 1 {
+		return nil, fmt.Errorf("age-partitioned filter needs k and l of at least 1, got k=%d l=%d", k, l)
+	}
+	if generationSize == 0 {
+		return nil, fmt.Errorf("generation size must be positive")
+	}
+
+	// A slice takes generationSize elements in each of the k generations it
+	// is among the newest; half full after k*g insertions needs k*g/ln2 bits
+	sliceBits := uint64(math.Ceil(float64(k) * float64(generationSize) / math.Ln2))
+	linesPerSlice := (sliceBits + BitsPerCacheLine - 1) / BitsPerCacheLine
+	memory := allocateCacheLines(linesPerSlice*uint64(k+l), applyOptions(opts))
+	simdOps, _ := selectSIMDOperations()
+
+	return &AgePartitionedBloomFilter{
+		cacheLines:     memory.lines,
+		memory:         memory,
+		k:              k,
+		l:              l,
+		linesPerSlice:  linesPerSlice,
+		sliceBits:      linesPerSlice * BitsPerCacheLine,
+		generationSize: generationSize,
+		simdOps:        simdOps,
+	}, nil
+}
+
+// physical maps a slice's age (0 is the newest) to its index in storage
+func (af *AgePartitionedBloomFilter) physical(age int) int {
+	return (af.base + age) % (af.k + af.l)
+}
+
+// position returns the bit an element uses in a physical slice, relative to
+// the start of the whole array. Each slice hashes with its own index, which
+// moves with it as it ages.
+func (af *AgePartitionedBloomFilter) position(h1, h2 uint64, slice int) uint64 {
+	return uint64(slice)*af.sliceBits + reduceRange(h1+uint64(slice)*h2, af.sliceBits)
+}
+
+// hashPair returns the mixed double hashing pair of an element
+func (af *AgePartitionedBloomFilter) hashPair(data []byte) (uint64, uint64) {
+	return fuseMix(hashOptimized1(data), 0), fuseMix(hashOptimized2(data), 0)
+}
+
+// isSet reports whether a bit of the whole array is set
+func (af *AgePartitionedBloomFilter) isSet(pos uint64) bool {
+	return af.cacheLines[pos/BitsPerCacheLine].words[(pos%BitsPerCacheLine)/64]&(1<<(pos%64)) != 0
+}
+
+// Add inserts an element into the k newest slices, shifting first if the
+// current generation is full
+func (af *AgePartitionedBloomFilter) Add(data []byte) {
+	if af.generationCount == af.generationSize {
+		af.Shift()
+	}
+	h1, h2 := af.hashPair(data)
+	for age := 0; age < af.k; age++ {
+		pos := af.position(h1, h2, af.physical(age))
+		af.cacheLines[pos/BitsPerCacheLine].words[(pos%BitsPerCacheLine)/64] |= 1 << (pos % 64)
+	}
+	af.generationCount++
+}
+
+// Contains reports whether an element is present in k consecutive slices.
+// Every run of k slices contains exactly one of the slices l, l-k, l-2k, ..,
+// so only runs through a hit at one of those need to be examined.
+func (af *AgePartitionedBloomFilter) Contains(data []byte) bool {
+	h1, h2 := af.hashPair(data)
+	hit := func(age int) bool {
+		return af.isSet(af.position(h1, h2, af.physical(age)))
+	}
+
+	for anchor := af.l; anchor >= 0; anchor -= af.k {
+		if !hit(anchor) {
+			continue
+		}
+		run := 1
+		for age := anchor + 1; run < af.k && age < af.k+af.l && hit(age); age++ {
+			run++
+		}
+		for age := anchor - 1; run < af.k && age >= 0 && hit(age); age-- {
+			run++
+		}
+		if run == af.k {
+			return true
+		}
+	}
+	return false
+}
+
+// AddString adds a string element
+func (af *AgePartitionedBloomFilter) AddString(s string) {
+	af.Add(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// ContainsString checks if a string element is present
+func (af *AgePartitionedBloomFilter) ContainsString(s string) bool {
+	return af.Contains(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// Shift ages every slice by one generation, clearing the oldest and making
+// it the newest. Add shifts automatically every generationSize insertions;
+// call Shift directly to age the filter by time instead.
+func (af *AgePartitionedBloomFilter) Shift() {
+	slices := af.k + af.l
+	af.base = (af.base + slices - 1) % slices
+	first := uint64(af.base) * af.linesPerSlice
+	af.simdOps.VectorClear(unsafe.Pointer(&af.cacheLines[first]), int(af.linesPerSlice*CacheLineSize))
+	runtime.KeepAlive(af)
+	af.generationCount = 0
+}
+
+// K returns the number of consecutive slices an element must be found in
+func (af *AgePartitionedBloomFilter) K() int {
+	return af.k
+}
+
+// L returns the number of extra slices that set the window length
+func (af *AgePartitionedBloomFilter) L() int {
+	return af.l
+}
+
+// GenerationSize returns the insertions per generation
+func (af *AgePartitionedBloomFilter) GenerationSize() uint64 {
+	return af.generationSize
+}
+
+// Window returns the number of most recent insertions that are always
+// reported present: the current generation and the l before it
+func (af *AgePartitionedBloomFilter) Window() uint64 {
+	return uint64(af.l)*af.generationSize + af.generationCount
+}
+
+// fillRatios returns the fraction of set bits in each slice, newest first
+func (af *AgePartitionedBloomFilter) fillRatios() []float64 {
+	ratios := make([]float64, af.k+af.l)
+	for age := range ratios {
+		first := uint64(af.physical(age)) * af.linesPerSlice
+		var set int
+		for i := first; i < first+af.linesPerSlice; i++ {
+			for _, word := range af.cacheLines[i].words {
+				set += bits.OnesCount64(word)
+			}
+		}
+		ratios[age] = float64(set) / float64(af.sliceBits)
+	}
+	return ratios
+}
+
+// EstimatedFPP returns the probability that an element never added is
+// reported present, given the current fill of every slice: the chance that
+// some k consecutive slices all have its bit set. It is computed exactly by
+// tracking the distribution of the current run length across the slices.
+func (af *AgePartitionedBloomFilter) EstimatedFPP() float64 {
+	// run[j] is the probability of a current run of j hits with no run of k yet
+	run := make([]float64, af.k)
+	run[0] = 1
+	found := 0.0
+	for _, fill := range af.fillRatios() {
+		missed := 0.0
+		for _, p := range run {
+			missed += p
+		}
+		found += run[af.k-1] * fill
+		copy(run[1:], run[:af.k-1])
+		for j := 1; j < af.k; j++ {
+			run[j] *= fill
+		}
+		run[0] = missed * (1 - fill)
+	}
+	return found
+}
+
+// MemoryUsage returns the bytes allocated for all slices
+func (af *AgePartitionedBloomFilter) MemoryUsage() uint64 {
+	return af.memory.size
+}
+
+// Clear removes every element
+func (af *AgePartitionedBloomFilter) Clear() {
+	af.simdOps.VectorClear(unsafe.Pointer(&af.cacheLines[0]), len(af.cacheLines)*CacheLineSize)
+	runtime.KeepAlive(af)
+	af.generationCount = 0
+}
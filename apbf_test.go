@@ -0,0 +1,143 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// TestAgePartitionedBloomFilterWindow tests that recent elements are always
+// present and that elements fall out after l+1 generations
+func TestAgePartitionedBloomFilterWindow(t *testing.T) {
+	af, err := NewAgePartitionedBloomFilter(4, 6, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const total = 20500
+	for i := 0; i < total; i++ {
+		af.AddString(fmt.Sprintf("apbf_%d", i))
+	}
+	if af.Window() != 6500 {
+		t.Errorf("Expected a window of 6500 insertions, got %d", af.Window())
+	}
+	for i := total - int(af.Window()); i < total; i++ {
+		if !af.ContainsString(fmt.Sprintf("apbf_%d", i)) {
+			t.Fatalf("False negative for element %d within the window", i)
+		}
+	}
+
+	// Elements from more than l+1 generations ago have lost too many slices
+	var stale int
+	for i := 0; i < total-7500; i++ {
+		if af.ContainsString(fmt.Sprintf("apbf_%d", i)) {
+			stale++
+		}
+	}
+	rate := float64(stale) / float64(total-7500)
+	if rate > 3*af.EstimatedFPP() {
+		t.Errorf("Expired elements reported at %.4f, filter FPP is %.4f", rate, af.EstimatedFPP())
+	}
+}
+
+// TestAgePartitionedBloomFilterFPP compares the reported false positive rate
+// with the measured one once every slice is in use
+func TestAgePartitionedBloomFilterFPP(t *testing.T) {
+	af, err := NewAgePartitionedBloomFilter(6, 10, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50000; i++ {
+		af.AddString(fmt.Sprintf("fpp_in_%d", i))
+	}
+
+	const trials = 200000
+	var falsePositives int
+	for i := 0; i < trials; i++ {
+		if af.ContainsString(fmt.Sprintf("fpp_out_%d", i)) {
+			falsePositives++
+		}
+	}
+	measured := float64(falsePositives) / trials
+	estimated := af.EstimatedFPP()
+	t.Logf("estimated FPP %.5f, measured %.5f", estimated, measured)
+	if measured < estimated*0.7 || measured > estimated*1.4 {
+		t.Errorf("Measured FPP %.5f does not match estimate %.5f", measured, estimated)
+	}
+}
+
+// TestAgePartitionedBloomFilterEstimate checks the run length calculation
+// against enumerating every hit pattern of the slices
+func TestAgePartitionedBloomFilterEstimate(t *testing.T) {
+	af, err := NewAgePartitionedBloomFilter(3, 4, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 550; i++ {
+		af.AddString(fmt.Sprintf("estimate_%d", i))
+	}
+
+	fills := af.fillRatios()
+	expected := 0.0
+	for pattern := 0; pattern < 1<<len(fills); pattern++ {
+		p, run, found := 1.0, 0, false
+		for i, fill := range fills {
+			if pattern&(1<<i) != 0 {
+				p *= fill
+				if run++; run >= af.K() {
+					found = true
+				}
+			} else {
+				p *= 1 - fill
+				run = 0
+			}
+		}
+		if found {
+			expected += p
+		}
+	}
+	if got := af.EstimatedFPP(); math.Abs(got-expected) > 1e-12 {
+		t.Errorf("EstimatedFPP %g, enumeration gives %g", got, expected)
+	}
+}
+
+// TestAgePartitionedBloomFilterShift tests manual aging and Clear
+func TestAgePartitionedBloomFilterShift(t *testing.T) {
+	af, err := NewAgePartitionedBloomFilter(2, 3, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if af.K() != 2 || af.L() != 3 || af.GenerationSize() != 1<<20 {
+		t.Errorf("Unexpected parameters k=%d l=%d g=%d", af.K(), af.L(), af.GenerationSize())
+	}
+
+	af.AddString("old")
+	for i := 0; i < 3; i++ {
+		af.Shift()
+		if !af.ContainsString("old") {
+			t.Fatalf("Element lost after %d shifts", i+1)
+		}
+	}
+	af.Shift()
+	if af.ContainsString("old") {
+		t.Error("Element still present after l+1 shifts")
+	}
+
+	af.AddString("new")
+	af.Clear()
+	if af.ContainsString("new") || af.EstimatedFPP() != 0 {
+		t.Error("Clear left elements behind")
+	}
+	if af.MemoryUsage() < 5*CacheLineSize {
+		t.Errorf("Memory usage %d too small for 5 slices", af.MemoryUsage())
+	}
+
+	for _, c := range []struct {
+		k, l int
+		g    uint64
+	}{{0, 3, 10}, {2, 0, 10}, {2, 3, 0}} {
+		if _, err := NewAgePartitionedBloomFilter(c.k, c.l, c.g); err == nil {
+			t.Errorf("Expected an error for k=%d l=%d g=%d", c.k, c.l, c.g)
+		}
+	}
+}
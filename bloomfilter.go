@@ -3,6 +3,7 @@ package bloomfilter
 import (
 	"fmt"
 	"math"
+	"math/bits"
 	"runtime"
 	"unsafe"
 )
@@ -11,6 +12,7 @@ import (
 type CacheOptimizedBloomFilter struct {
 	// Cache line aligned bitset
 	cacheLines     []CacheLine
+	memory         *cacheLineMemory // owns the storage cacheLines points into
 	bitCount       uint64
 	hashCount      uint32
 	cacheLineCount uint64
@@ -21,6 +23,12 @@ type CacheOptimizedBloomFilter struct {
 
 	// SIMD operations instance (initialized once for performance)
 	simdOps SIMDOperations
+	// Whether simdOps was chosen by Calibrate rather than capability detection
+	simdCalibrated bool
+
+	// One bit per cache line modified since the last checkpoint; nil when
+	// dirty tracking is disabled
+	dirty []uint64
 }
 
 // CacheStats provides detailed statistics about the bloom filter
@@ -34,68 +42,147 @@ type CacheStats struct {
 	CacheLineSize  int
 	MemoryUsage    uint64
 	Alignment      uintptr
+	// Where the cache lines live and whether they are locked into RAM
+	AllocationStrategy AllocationStrategy
+	MemoryLocked       bool
 	// SIMD capability information
 	HasAVX2     bool
 	HasAVX512   bool
 	HasNEON     bool
 	SIMDEnabled bool
+	// SIMD backend used by this filter and whether Calibrate selected it
+	SIMDBackend    string
+	SIMDCalibrated bool
 }
 
 // NewCacheOptimizedBloomFilter creates a cache line optimized bloom filter
-func NewCacheOptimizedBloomFilter(expectedElements uint64, falsePositiveRate float64) *CacheOptimizedBloomFilter {
+func NewCacheOptimizedBloomFilter(expectedElements uint64, falsePositiveRate float64, opts ...Option) *CacheOptimizedBloomFilter {
+	cacheLineCount, hashCount := optimalParameters(expectedElements, falsePositiveRate)
+
+	o := applyOptions(opts)
+	if o.powerOfTwo && cacheLineCount > 1 {
+		cacheLineCount = 1 << bits.Len64(cacheLineCount-1)
+	}
+
+	// Allocate cache line aligned memory; memory retains the backing storage
+	memory := allocateCacheLines(cacheLineCount, o)
+
+	bf := newFilterWithMemory(cacheLineCount, hashCount, memory)
+	if o.dirtyTracking {
+		bf.EnableDirtyTracking()
+	}
+	return bf
+}
+
+// maxHashCount bounds the probes per element; beyond 64 the false positive
+// rate is already below 2^-64
+const maxHashCount = 64
+
+// optimalParameters sizes a filter for the expected elements and false positive rate
+func optimalParameters(expectedElements uint64, falsePositiveRate float64) (cacheLineCount uint64, hashCount uint32) {
 	// Calculate optimal parameters
 	ln2 := math.Ln2
 	bitCount := uint64(-float64(expectedElements) * math.Log(falsePositiveRate) / (ln2 * ln2))
-	hashCount := uint32(float64(bitCount) * ln2 / float64(expectedElements))
+	hashCount = uint32(float64(bitCount) * ln2 / float64(expectedElements))
 
-	if hashCount < 1 {
-		hashCount = 1
-	}
+	hashCount = min(max(hashCount, 1), maxHashCount)
 
 	// Align to cache line boundaries (512 bits per cache line)
-	cacheLineCount := (bitCount + BitsPerCacheLine - 1) / BitsPerCacheLine
-	bitCount = cacheLineCount * BitsPerCacheLine
-
-	// Allocate cache line aligned memory
-	cacheLines := make([]CacheLine, cacheLineCount)
-
-	// Verify alignment
-	if uintptr(unsafe.Pointer(&cacheLines[0]))%CacheLineSize != 0 {
-		// Force alignment by creating a larger slice and finding aligned offset
-		oversized := make([]byte, int(cacheLineCount)*CacheLineSize+CacheLineSize)
-		alignedPtr := (uintptr(unsafe.Pointer(&oversized[0])) + CacheLineSize - 1) &^ (CacheLineSize - 1)
-		cacheLines = *(*[]CacheLine)(unsafe.Pointer(&struct {
-			ptr uintptr
-			len int
-			cap int
-		}{alignedPtr, int(cacheLineCount), int(cacheLineCount)}))
-	}
+	cacheLineCount = (bitCount + BitsPerCacheLine - 1) / BitsPerCacheLine
+	return cacheLineCount, hashCount
+}
+
+// newFilterWithMemory builds a filter around already allocated cache line storage
+func newFilterWithMemory(cacheLineCount uint64, hashCount uint32, memory *cacheLineMemory) *CacheOptimizedBloomFilter {
+	// Initialize SIMD operations once
+	simdOps, simdCalibrated := selectSIMDOperations()
 
 	return &CacheOptimizedBloomFilter{
-		cacheLines:       cacheLines,
-		bitCount:         bitCount,
+		cacheLines:       memory.lines,
+		memory:           memory,
+		bitCount:         cacheLineCount * BitsPerCacheLine,
 		hashCount:        hashCount,
 		cacheLineCount:   cacheLineCount,
 		positions:        make([]uint64, hashCount),
 		cacheLineIndices: make([]uint64, hashCount),
-		simdOps:          GetSIMDOperations(), // Initialize SIMD operations once
+		simdOps:          simdOps,
+		simdCalibrated:   simdCalibrated,
 	}
 }
 
 // Add adds an element with cache line optimization
 func (bf *CacheOptimizedBloomFilter) Add(data []byte) {
-	bf.getHashPositionsOptimized(data)
-	bf.prefetchCacheLines()
-	bf.setBitCacheOptimized(bf.positions[:bf.hashCount])
+	bf.addHashPair(hashOptimized1(data), hashOptimized2(data))
 }
 
 // Contains checks membership with cache line optimization
 func (bf *CacheOptimizedBloomFilter) Contains(data []byte) bool {
-	bf.getHashPositionsOptimized(data)
+	return bf.containsHashPair(hashOptimized1(data), hashOptimized2(data))
+}
+
+// addHashPair adds an element given its precomputed double hashing pair
+func (bf *CacheOptimizedBloomFilter) addHashPair(h1, h2 uint64) {
+	bf.getHashPositionsFromPair(h1, h2)
+	bf.prefetchCacheLines()
+	bf.setBitCacheOptimized(bf.positions[:bf.hashCount])
+}
+
+// containsHashPair checks membership given a precomputed double hashing pair
+func (bf *CacheOptimizedBloomFilter) containsHashPair(h1, h2 uint64) bool {
+	bf.getHashPositionsFromPair(h1, h2)
 	bf.prefetchCacheLines()
 	return bf.getBitCacheOptimized(bf.positions[:bf.hashCount])
 }
 
+// Number of keys hashed and prefetched ahead of the key being processed in batch operations
+const prefetchDistance = 4
+
+// AddBatch adds multiple elements, prefetching the cache lines of upcoming
+// keys while the current one is being inserted
+func (bf *CacheOptimizedBloomFilter) AddBatch(items [][]byte) {
+	var pending [prefetchDistance][2]uint64
+
+	for i := 0; i < len(items)+prefetchDistance; i++ {
+		slot := i % prefetchDistance
+		if i >= prefetchDistance {
+			bf.getHashPositionsFromPair(pending[slot][0], pending[slot][1])
+			bf.setBitCacheOptimized(bf.positions[:bf.hashCount])
+		}
+		if i < len(items) {
+			h1, h2 := hashOptimized1(items[i]), hashOptimized2(items[i])
+			bf.prefetchHashPair(h1, h2)
+			pending[slot] = [2]uint64{h1, h2}
+		}
+	}
+}
+
+// ContainsBatch checks multiple elements, prefetching the cache lines of
+// upcoming keys while the current one is being tested. Results are written
+// into results, which is reused when it has enough capacity.
+func (bf *CacheOptimizedBloomFilter) ContainsBatch(items [][]byte, results []bool) []bool {
+	if cap(results) < len(items) {
+		results = make([]bool, len(items))
+	}
+	results = results[:len(items)]
+
+	var pending [prefetchDistance][2]uint64
+
+	for i := 0; i < len(items)+prefetchDistance; i++ {
+		slot := i % prefetchDistance
+		if i >= prefetchDistance {
+			bf.getHashPositionsFromPair(pending[slot][0], pending[slot][1])
+			results[i-prefetchDistance] = bf.getBitCacheOptimized(bf.positions[:bf.hashCount])
+		}
+		if i < len(items) {
+			h1, h2 := hashOptimized1(items[i]), hashOptimized2(items[i])
+			bf.prefetchHashPair(h1, h2)
+			pending[slot] = [2]uint64{h1, h2}
+		}
+	}
+
+	return results
+}
+
 // AddString adds a string element to the bloom filter
 func (bf *CacheOptimizedBloomFilter) AddString(s string) {
 	data := *(*[]byte)(unsafe.Pointer(&struct {
@@ -137,12 +224,19 @@ func (bf *CacheOptimizedBloomFilter) Clear() {
 
 	// Use the pre-initialized SIMD operations for vectorized clear operation
 	bf.simdOps.VectorClear(unsafe.Pointer(&bf.cacheLines[0]), totalBytes)
+	runtime.KeepAlive(bf)
 }
 
-// Union performs vectorized union operation with automatic fallback to optimized scalar
+// Union performs vectorized union operation with automatic fallback to optimized scalar.
+// other may also be a larger filter that Fold can shrink to this filter's size.
 func (bf *CacheOptimizedBloomFilter) Union(other *CacheOptimizedBloomFilter) error {
 	if bf.cacheLineCount != other.cacheLineCount {
-		return fmt.Errorf("bloom filters must have same size for union")
+		// A larger power-of-two filter can be folded down to this size
+		factor := foldFactor(other, bf)
+		if factor == 0 {
+			return fmt.Errorf("bloom filters must have same size for union")
+		}
+		other = other.fold(factor)
 	}
 
 	if bf.cacheLineCount == 0 {
@@ -152,12 +246,18 @@ func (bf *CacheOptimizedBloomFilter) Union(other *CacheOptimizedBloomFilter) err
 	// Calculate total data size in bytes
 	totalBytes := int(bf.cacheLineCount * CacheLineSize)
 
+	if bf.dirty != nil {
+		bf.markChangedLines(other.cacheLines)
+	}
+
 	// Use the pre-initialized SIMD operations for vectorized OR operation
 	bf.simdOps.VectorOr(
 		unsafe.Pointer(&bf.cacheLines[0]),
 		unsafe.Pointer(&other.cacheLines[0]),
 		totalBytes,
 	)
+	runtime.KeepAlive(bf)
+	runtime.KeepAlive(other)
 
 	return nil
 }
@@ -181,6 +281,8 @@ func (bf *CacheOptimizedBloomFilter) Intersection(other *CacheOptimizedBloomFilt
 		unsafe.Pointer(&other.cacheLines[0]),
 		totalBytes,
 	)
+	runtime.KeepAlive(bf)
+	runtime.KeepAlive(other)
 
 	return nil
 }
@@ -196,6 +298,7 @@ func (bf *CacheOptimizedBloomFilter) PopCount() uint64 {
 
 	// Use the pre-initialized SIMD operations for vectorized population count
 	count := bf.simdOps.PopCount(unsafe.Pointer(&bf.cacheLines[0]), totalBytes)
+	runtime.KeepAlive(bf)
 
 	return uint64(count)
 }
@@ -220,13 +323,19 @@ func (bf *CacheOptimizedBloomFilter) GetCacheStats() CacheStats {
 		EstimatedFPP:   bf.EstimatedFPP(),
 		CacheLineCount: bf.cacheLineCount,
 		CacheLineSize:  CacheLineSize,
-		MemoryUsage:    bf.cacheLineCount * CacheLineSize,
+		MemoryUsage:    bf.memory.size,
 		Alignment:      alignment,
+		// Allocation information
+		AllocationStrategy: bf.memory.strategy,
+		MemoryLocked:       bf.memory.locked,
 		// SIMD capability information
 		HasAVX2:     hasAVX2,
 		HasAVX512:   hasAVX512,
 		HasNEON:     hasNEON,
 		SIMDEnabled: hasAVX2 || hasAVX512 || hasNEON,
+		// Backend selection
+		SIMDBackend:    simdBackendName(bf.simdOps),
+		SIMDCalibrated: bf.simdCalibrated,
 	}
 }
 
@@ -250,7 +359,6 @@ func HasSIMD() bool {
 	return hasAVX2 || hasAVX512 || hasNEON
 }
 
-
 // SIMD capabilities detection
 var (
 	hasAVX2   bool
@@ -260,6 +368,9 @@ var (
 
 func init() {
 	detectSIMDCapabilities()
+	if calibrateOnInit() {
+		Calibrate()
+	}
 }
 
 const (
@@ -402,95 +513,77 @@ func hashOptimized2(data []byte) uint64 {
 	return hash
 }
 
-// getHashPositionsOptimized generates hash positions with cache line grouping and vectorized hashing
-func (bf *CacheOptimizedBloomFilter) getHashPositionsOptimized(data []byte) {
-	h1 := hashOptimized1(data)
-	h2 := hashOptimized2(data)
-
-	// Generate positions and group by cache line to improve locality
-	cacheLineMap := make(map[uint64][]uint64)
+// getHashPositionsFromPair fills the pre-allocated position and cache line index
+// arrays from a double hashing pair without allocating
+func (bf *CacheOptimizedBloomFilter) getHashPositionsFromPair(h1, h2 uint64) {
+	bf.cacheLineIndices = bf.cacheLineIndices[:0]
 
 	for i := uint32(0); i < bf.hashCount; i++ {
-		hash := h1 + uint64(i)*h2
-		bitPos := hash % bf.bitCount
-		cacheLineIdx := bitPos / BitsPerCacheLine
-
+		bitPos := reduceRange(h1+uint64(i)*h2, bf.bitCount)
 		bf.positions[i] = bitPos
-		cacheLineMap[cacheLineIdx] = append(cacheLineMap[cacheLineIdx], bitPos)
-	}
 
-	// Store unique cache line indices for prefetching
-	bf.cacheLineIndices = bf.cacheLineIndices[:0]
-	for cacheLineIdx := range cacheLineMap {
-		bf.cacheLineIndices = append(bf.cacheLineIndices, cacheLineIdx)
+		// Record each cache line once for prefetching; hashCount is small,
+		// so a linear scan beats any map or set
+		cacheLineIdx := bitPos / BitsPerCacheLine
+		seen := false
+		for _, idx := range bf.cacheLineIndices {
+			if idx == cacheLineIdx {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			bf.cacheLineIndices = append(bf.cacheLineIndices, cacheLineIdx)
+		}
 	}
 }
 
-// prefetchCacheLines provides hints to prefetch cache lines
+// reduceRange maps a hash uniformly onto [0, n) with a multiply-shift instead of a division
+func reduceRange(hash, n uint64) uint64 {
+	hi, _ := bits.Mul64(hash, n)
+	return hi
+}
+
+// prefetchCacheLines issues hardware prefetches for the current key's cache lines
+// so that lines on different positions are fetched in parallel
 func (bf *CacheOptimizedBloomFilter) prefetchCacheLines() {
-	// In Go, we can't directly issue prefetch instructions,
-	// but we can hint to the runtime by touching memory
 	for _, idx := range bf.cacheLineIndices {
-		if idx < bf.cacheLineCount {
-			// Touch the cache line to bring it into cache
-			_ = bf.cacheLines[idx].words[0]
-		}
+		bf.simdOps.Prefetch(unsafe.Pointer(&bf.cacheLines[idx]))
+	}
+}
+
+// prefetchHashPair issues hardware prefetches for the cache lines an upcoming key will touch
+func (bf *CacheOptimizedBloomFilter) prefetchHashPair(h1, h2 uint64) {
+	for i := uint32(0); i < bf.hashCount; i++ {
+		cacheLineIdx := reduceRange(h1+uint64(i)*h2, bf.bitCount) / BitsPerCacheLine
+		bf.simdOps.Prefetch(unsafe.Pointer(&bf.cacheLines[cacheLineIdx]))
 	}
 }
 
 // setBitCacheOptimized sets multiple bits with cache line awareness
 func (bf *CacheOptimizedBloomFilter) setBitCacheOptimized(positions []uint64) {
-	// Group operations by cache line to minimize cache misses
-	cacheLineOps := make(map[uint64][]struct{ wordIdx, bitOffset uint64 })
-
 	for _, bitPos := range positions {
 		cacheLineIdx := bitPos / BitsPerCacheLine
 		wordInCacheLine := (bitPos % BitsPerCacheLine) / 64
 		bitOffset := bitPos % 64
 
-		cacheLineOps[cacheLineIdx] = append(cacheLineOps[cacheLineIdx], struct{ wordIdx, bitOffset uint64 }{
-			wordIdx: wordInCacheLine, bitOffset: bitOffset,
-		})
-	}
-
-	// Process each cache line's operations together
-	for cacheLineIdx, ops := range cacheLineOps {
-		if cacheLineIdx < bf.cacheLineCount {
-			cacheLine := &bf.cacheLines[cacheLineIdx]
-			for _, op := range ops {
-				cacheLine.words[op.wordIdx] |= 1 << op.bitOffset
-			}
+		bf.cacheLines[cacheLineIdx].words[wordInCacheLine] |= 1 << bitOffset
+		if bf.dirty != nil {
+			bf.dirty[cacheLineIdx/64] |= 1 << (cacheLineIdx % 64)
 		}
 	}
 }
 
 // getBitCacheOptimized checks multiple bits with cache line awareness
 func (bf *CacheOptimizedBloomFilter) getBitCacheOptimized(positions []uint64) bool {
-	// Group operations by cache line
-	cacheLineOps := make(map[uint64][]struct{ wordIdx, bitOffset uint64 })
-
 	for _, bitPos := range positions {
 		cacheLineIdx := bitPos / BitsPerCacheLine
 		wordInCacheLine := (bitPos % BitsPerCacheLine) / 64
 		bitOffset := bitPos % 64
 
-		cacheLineOps[cacheLineIdx] = append(cacheLineOps[cacheLineIdx], struct{ wordIdx, bitOffset uint64 }{
-			wordIdx: wordInCacheLine, bitOffset: bitOffset,
-		})
-	}
-
-	// Check each cache line's bits together
-	for cacheLineIdx, ops := range cacheLineOps {
-		if cacheLineIdx >= bf.cacheLineCount {
+		if (bf.cacheLines[cacheLineIdx].words[wordInCacheLine] & (1 << bitOffset)) == 0 {
 			return false
 		}
-
-		cacheLine := &bf.cacheLines[cacheLineIdx]
-		for _, op := range ops {
-			if (cacheLine.words[op.wordIdx] & (1 << op.bitOffset)) == 0 {
-				return false
-			}
-		}
 	}
 
 	return true
@@ -17,12 +17,14 @@ This benchmark suite provides detailed performance analysis across multiple dime
 3. BenchmarkLookup: Measures lookup throughput with load factor and accuracy metrics
 4. BenchmarkFalsePositives: Tests statistical accuracy of false positive rates
 5. BenchmarkComprehensive: Complete performance profile with throughput and accuracy analysis
+6. BenchmarkBatchLookup: Compares single-key lookups with prefetching batch lookups
+7. BenchmarkRibbonVsBloom: Compares a Ribbon filter with a bloom filter at equal FPP
 
 Key metrics reported:
 - Performance: insertions_per_sec, lookups_per_sec
 - Memory: MB_mem, KB_mem, cachelines, alignment_offset
 - Accuracy: actual_fpp_percent, estimated_fpp_percent, target_fpp_percent
-- Utilization: load_factor, bits_set
+- Utilization: load_factor, bits_set, bits_per_key
 
 Usage: go test -bench=. -benchmem
 */
@@ -233,3 +235,104 @@ func BenchmarkComprehensive(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkBatchLookup compares single-key lookups with prefetching batch lookups
+// on a filter much larger than the CPU caches
+func BenchmarkBatchLookup(b *testing.B) {
+	const numElements = 10000000
+	bf := NewCacheOptimizedBloomFilter(numElements, 0.01)
+
+	keys := make([][]byte, 4096)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("batch_key_%d", rand.Int63()))
+	}
+	bf.AddBatch(keys)
+	results := make([]bool, len(keys))
+
+	b.Run("Single", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j, key := range keys {
+				results[j] = bf.Contains(key)
+			}
+		}
+		b.ReportMetric(float64(b.N*len(keys))/b.Elapsed().Seconds(), "lookups_per_sec")
+	})
+
+	b.Run("Batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			results = bf.ContainsBatch(keys, results)
+		}
+		b.ReportMetric(float64(b.N*len(keys))/b.Elapsed().Seconds(), "lookups_per_sec")
+	})
+}
+
+// BenchmarkRibbonVsBloom compares lookups and space of a Ribbon filter and a
+// bloom filter built for the same keys at the same false positive rate
+func BenchmarkRibbonVsBloom(b *testing.B) {
+	const numElements = 1000000
+	keys := make([][]byte, numElements)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("ribbon_key_%d", i))
+	}
+	probes := make([][]byte, 4096)
+	for i := range probes {
+		probes[i] = []byte(fmt.Sprintf("probe_%d", rand.Int63()))
+	}
+
+	for _, fingerprintBits := range []int{8, 16} {
+		fpp := 1 / float64(uint64(1)<<fingerprintBits)
+
+		rf, err := BuildRibbonFilter(keys, fingerprintBits)
+		if err != nil {
+			b.Fatal(err)
+		}
+		bf := NewCacheOptimizedBloomFilter(numElements, fpp)
+		bf.AddBatch(keys)
+
+		b.Run(fmt.Sprintf("Ribbon_%dbit", fingerprintBits), func(b *testing.B) {
+			hits := 0
+			for i := 0; i < b.N; i++ {
+				for _, key := range probes {
+					if rf.Contains(key) {
+						hits++
+					}
+				}
+			}
+			b.ReportMetric(float64(b.N*len(probes))/b.Elapsed().Seconds(), "lookups_per_sec")
+			b.ReportMetric(rf.BitsPerKey(), "bits_per_key")
+			b.ReportMetric(float64(hits)/float64(b.N*len(probes))*100, "actual_fpp_percent")
+		})
+
+		b.Run(fmt.Sprintf("Bloom_%dbit", fingerprintBits), func(b *testing.B) {
+			hits := 0
+			for i := 0; i < b.N; i++ {
+				for _, key := range probes {
+					if bf.Contains(key) {
+						hits++
+					}
+				}
+			}
+			b.ReportMetric(float64(b.N*len(probes))/b.Elapsed().Seconds(), "lookups_per_sec")
+			b.ReportMetric(float64(bf.bitCount)/numElements, "bits_per_key")
+			b.ReportMetric(float64(hits)/float64(b.N*len(probes))*100, "actual_fpp_percent")
+		})
+	}
+}
+
+// BenchmarkAdaptiveFillToConversion fills a large adaptive filter in sparse
+// mode until it converts to dense, which must stay well below quadratic
+func BenchmarkAdaptiveFillToConversion(b *testing.B) {
+	for _, expected := range []uint64{100000, 10000000} {
+		b.Run(fmt.Sprintf("Elements_%d", expected), func(b *testing.B) {
+			added := 0
+			for i := 0; i < b.N; i++ {
+				af := NewAdaptiveBloomFilter(expected, 0.01)
+				for n := uint64(0); af.IsSparse(); n++ {
+					af.AddUint64(n)
+					added++
+				}
+			}
+			b.ReportMetric(float64(added)/b.Elapsed().Seconds(), "adds_per_sec")
+		})
+	}
+}
@@ -368,3 +368,87 @@ func TestFalsePositiveRate(t *testing.T) {
 	t.Logf("False positive rate test: actual=%.4f%%, target=%.4f%%, elements=%d, tests=%d",
 		actualFPP*100, targetFPP*100, numElements, numTests)
 }
+
+// TestZeroAllocations tests that the Add/Contains hot path does not allocate
+func TestZeroAllocations(t *testing.T) {
+	bf := NewCacheOptimizedBloomFilter(10000, 0.01)
+	data := []byte("zero_alloc_key")
+	str := "zero_alloc_string"
+	var n uint64 = 0xDEADBEEF
+	batch := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e"), []byte("f")}
+	results := make([]bool, len(batch))
+
+	cases := []struct {
+		name string
+		fn   func()
+	}{
+		{"Add", func() { bf.Add(data) }},
+		{"Contains", func() { bf.Contains(data) }},
+		{"AddString", func() { bf.AddString(str) }},
+		{"ContainsString", func() { bf.ContainsString(str) }},
+		{"AddUint64", func() { bf.AddUint64(n) }},
+		{"ContainsUint64", func() { bf.ContainsUint64(n) }},
+		{"AddBatch", func() { bf.AddBatch(batch) }},
+		{"ContainsBatch", func() { results = bf.ContainsBatch(batch, results) }},
+	}
+
+	for _, tc := range cases {
+		if allocs := testing.AllocsPerRun(100, tc.fn); allocs != 0 {
+			t.Errorf("%s allocated %.1f times per run, expected 0", tc.name, allocs)
+		}
+	}
+}
+
+// TestReduceRange tests that the multiply-shift reduction stays in range
+func TestReduceRange(t *testing.T) {
+	ranges := []uint64{1, 7, BitsPerCacheLine, 9586 * BitsPerCacheLine}
+	hashes := []uint64{0, 1, 1 << 63, ^uint64(0), 0x9e3779b97f4a7c15}
+
+	for _, n := range ranges {
+		for _, h := range hashes {
+			if pos := reduceRange(h, n); pos >= n {
+				t.Errorf("reduceRange(%#x, %d) = %d, out of range", h, n, pos)
+			}
+		}
+	}
+
+	if pos := reduceRange(^uint64(0), 1000); pos != 999 {
+		t.Errorf("Expected max hash to map to last slot, got %d", pos)
+	}
+}
+
+// TestBatchOperations tests that batch operations match single-key operations
+func TestBatchOperations(t *testing.T) {
+	for _, size := range []int{0, 1, 3, prefetchDistance, 1000} {
+		batchFilter := NewCacheOptimizedBloomFilter(10000, 0.01)
+		singleFilter := NewCacheOptimizedBloomFilter(10000, 0.01)
+
+		items := make([][]byte, size)
+		for i := range items {
+			items[i] = []byte(fmt.Sprintf("batch_%d", i))
+			singleFilter.Add(items[i])
+		}
+		batchFilter.AddBatch(items)
+
+		if batchFilter.PopCount() != singleFilter.PopCount() {
+			t.Errorf("Size %d: batch and single insertion set different bits", size)
+		}
+
+		queries := make([][]byte, 2*size)
+		for i := range queries {
+			queries[i] = []byte(fmt.Sprintf("batch_%d", i))
+		}
+		results := batchFilter.ContainsBatch(queries, nil)
+		if len(results) != len(queries) {
+			t.Fatalf("Size %d: expected %d results, got %d", size, len(queries), len(results))
+		}
+		for i, query := range queries {
+			if results[i] != batchFilter.Contains(query) {
+				t.Errorf("Size %d: ContainsBatch disagrees with Contains for %q", size, query)
+			}
+			if i < size && !results[i] {
+				t.Errorf("Size %d: expected to find %q", size, query)
+			}
+		}
+	}
+}
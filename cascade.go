@@ -0,0 +1,231 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// Filter cascade construction parameters
+const (
+	// False positive rate of every level after the first
+	cascadeLevelFPP = 0.5
+	// Levels allowed before Build gives up; each roughly halves the false positives
+	cascadeMaxLevels = 64
+	// Bytes of cascade parameters at the start of the payload
+	cascadePayloadHeaderSize = 8
+)
+
+// FilterCascade encodes a set exactly relative to a known universe, as in
+// CRLite (Larisch et al., 2017). Level 0 is a bloom filter of the included
+// keys; each later level holds the false positives of the level before it
+// drawn from the opposite set, until a level has none. A key's membership is
+// decided by the first level that does not contain it.
+//
+// Contains is exact for every key passed to BuildFilterCascade; keys outside
+// that universe get an arbitrary answer. Each level salts the element hash so
+// false positives do not repeat from level to level.
+type FilterCascade struct {
+	levels []*CacheOptimizedBloomFilter
+}
+
+// BuildFilterCascade builds a cascade for which Contains is true for every
+// key in include and false for every key in exclude. The two sets must be
+// disjoint; duplicates within a set are allowed.
+func BuildFilterCascade(include, exclude [][]byte) (*FilterCascade, error) {
+	seen := make(map[string]struct{}, len(include))
+	for _, key := range include {
+		seen[string(key)] = struct{}{}
+	}
+	for _, key := range exclude {
+		if _, ok := seen[string(key)]; ok {
+			return nil, fmt.Errorf("key %q is both included and excluded", key)
+		}
+	}
+
+	current, other := cascadeKeyHashes(include), cascadeKeyHashes(exclude)
+	fc := &FilterCascade{}
+
+	// The first level's rate is chosen to minimize the total size (CRLite)
+	fpp := cascadeLevelFPP
+	if len(other) > 0 {
+		fpp = min(cascadeLevelFPP, float64(len(current))/(float64(len(other))*math.Sqrt2))
+	}
+	for len(current) > 0 {
+		if len(fc.levels) == cascadeMaxLevels {
+			return nil, fmt.Errorf("%w: false positives remain after %d cascade levels", ErrBuildFailed, cascadeMaxLevels)
+		}
+		level := len(fc.levels)
+		filter := NewCacheOptimizedBloomFilter(uint64(len(current)), fpp)
+		for _, pair := range current {
+			filter.addHashPair(cascadeLevelHashes(pair, level))
+		}
+		fc.levels = append(fc.levels, filter)
+
+		var falsePositives [][2]uint64
+		for _, pair := range other {
+			if filter.containsHashPair(cascadeLevelHashes(pair, level)) {
+				falsePositives = append(falsePositives, pair)
+			}
+		}
+		current, other = falsePositives, current
+		fpp = cascadeLevelFPP
+	}
+	return fc, nil
+}
+
+// cascadeKeyHashes returns the double hashing pair of each key
+func cascadeKeyHashes(keys [][]byte) [][2]uint64 {
+	pairs := make([][2]uint64, len(keys))
+	for i, key := range keys {
+		pairs[i] = [2]uint64{hashOptimized1(key), hashOptimized2(key)}
+	}
+	return pairs
+}
+
+// cascadeLevelHashes salts a key's hash pair for one level
+func cascadeLevelHashes(pair [2]uint64, level int) (uint64, uint64) {
+	salt := uint64(level) * 0x9E3779B97F4A7C15
+	return fuseMix(pair[0], salt), fuseMix(pair[1], salt)
+}
+
+// Contains reports whether data is in the included set. The answer is exact
+// for keys of the universe the cascade was built from.
+func (fc *FilterCascade) Contains(data []byte) bool {
+	pair := [2]uint64{hashOptimized1(data), hashOptimized2(data)}
+	for level, filter := range fc.levels {
+		if !filter.containsHashPair(cascadeLevelHashes(pair, level)) {
+			// Odd levels hold excluded keys, so missing one means included
+			return level%2 == 1
+		}
+	}
+	// The last level has no false positives, so its set decides
+	return len(fc.levels)%2 == 1
+}
+
+// ContainsString checks if a string element is in the included set
+func (fc *FilterCascade) ContainsString(s string) bool {
+	return fc.Contains(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// Levels returns the number of bloom filters in the cascade
+func (fc *FilterCascade) Levels() int {
+	return len(fc.levels)
+}
+
+// MemoryUsage returns the bytes allocated for all levels
+func (fc *FilterCascade) MemoryUsage() uint64 {
+	var total uint64
+	for _, filter := range fc.levels {
+		total += filter.memory.size
+	}
+	return total
+}
+
+/*
+Filter cascade payload
+
+After the standard header (filter kind 3), the payload holds the level count
+as a little-endian uint64 followed by each level, in order, as a complete
+bloom filter in the package's binary format, compressed where that is smaller.
+*/
+
+// WriteTo writes the cascade as a single file in the package's binary
+// format, implementing io.WriterTo
+func (fc *FilterCascade) WriteTo(w io.Writer) (int64, error) {
+	payloadSize := uint64(cascadePayloadHeaderSize)
+	for _, filter := range fc.levels {
+		payloadSize += filter.CompressedSize()
+	}
+
+	var buf [headerSize + cascadePayloadHeaderSize]byte
+	h := filterHeader{
+		version:     formatVersion,
+		kind:        filterKindCascade,
+		payloadSize: payloadSize,
+	}
+	h.encode(buf[:headerSize])
+	binary.LittleEndian.PutUint64(buf[headerSize:], uint64(len(fc.levels)))
+
+	n, err := w.Write(buf[:])
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+	for _, filter := range fc.levels {
+		n, err := filter.WriteCompressedTo(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// MarshalBinary encodes the cascade like WriteTo
+func (fc *FilterCascade) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := fc.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadFrom replaces the cascade with one read from r, implementing
+// io.ReaderFrom. It leaves any following data unread.
+func (fc *FilterCascade) ReadFrom(r io.Reader) (int64, error) {
+	var buf [headerSize + cascadePayloadHeaderSize]byte
+	n, err := io.ReadFull(r, buf[:])
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+
+	h, err := parseHeader(buf[:headerSize])
+	if err != nil {
+		return total, err
+	}
+	if h.kind != filterKindCascade || h.flags != 0 || h.payloadSize < cascadePayloadHeaderSize {
+		return total, fmt.Errorf("%w: not a filter cascade", ErrInvalidFormat)
+	}
+	count := binary.LittleEndian.Uint64(buf[headerSize:])
+	if count > cascadeMaxLevels {
+		return total, fmt.Errorf("%w: %d cascade levels", ErrInvalidFormat, count)
+	}
+
+	// Levels must account for exactly the rest of the payload
+	lr := &io.LimitedReader{R: r, N: int64(h.payloadSize - cascadePayloadHeaderSize)}
+	levels := make([]*CacheOptimizedBloomFilter, count)
+	for i := range levels {
+		levels[i] = &CacheOptimizedBloomFilter{}
+		n, err := levels[i].ReadFrom(lr)
+		total += n
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return total, err
+		}
+	}
+	if lr.N != 0 {
+		return total, fmt.Errorf("%w: %d unused payload bytes", ErrInvalidFormat, lr.N)
+	}
+
+	fc.levels = levels
+	return total, nil
+}
+
+// UnmarshalBinary replaces the cascade with data produced by MarshalBinary
+func (fc *FilterCascade) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := fc.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidFormat, r.Len())
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// cascadeUniverse splits count keys into included (every tenth) and excluded
+func cascadeUniverse(count int) (include, exclude [][]byte) {
+	for i := 0; i < count; i++ {
+		key := []byte(fmt.Sprintf("serial_%d", i))
+		if i%10 == 0 {
+			include = append(include, key)
+		} else {
+			exclude = append(exclude, key)
+		}
+	}
+	return include, exclude
+}
+
+// assertCascadeExact checks every key of the universe
+func assertCascadeExact(t *testing.T, fc *FilterCascade, include, exclude [][]byte) {
+	t.Helper()
+	for _, key := range include {
+		if !fc.Contains(key) {
+			t.Fatalf("Included key %q not found", key)
+		}
+	}
+	for _, key := range exclude {
+		if fc.Contains(key) {
+			t.Fatalf("Excluded key %q found", key)
+		}
+	}
+}
+
+// TestFilterCascadeExact tests that the cascade has no errors over the universe
+func TestFilterCascadeExact(t *testing.T) {
+	include, exclude := cascadeUniverse(100000)
+	fc, err := BuildFilterCascade(include, exclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fc.Levels() < 2 {
+		t.Errorf("Expected several levels, got %d", fc.Levels())
+	}
+	assertCascadeExact(t, fc, include, exclude)
+
+	// Far smaller than storing the included keys
+	if fc.MemoryUsage() > uint64(len(include))*8 {
+		t.Errorf("Cascade uses %d bytes for %d included keys", fc.MemoryUsage(), len(include))
+	}
+}
+
+// TestFilterCascadeEdgeCases tests empty sets and overlapping input
+func TestFilterCascadeEdgeCases(t *testing.T) {
+	include, exclude := cascadeUniverse(1000)
+
+	fc, err := BuildFilterCascade(nil, exclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fc.Levels() != 0 {
+		t.Errorf("Expected no levels for an empty include set, got %d", fc.Levels())
+	}
+	assertCascadeExact(t, fc, nil, exclude)
+
+	fc, err = BuildFilterCascade(include, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCascadeExact(t, fc, include, nil)
+
+	fc, err = BuildFilterCascade(append(include, include...), exclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCascadeExact(t, fc, include, exclude)
+
+	if _, err := BuildFilterCascade(include, append(exclude, include[3])); err == nil {
+		t.Error("Expected error for a key in both sets")
+	}
+}
+
+// TestFilterCascadeSerialization tests the single-file round trip
+func TestFilterCascadeSerialization(t *testing.T) {
+	include, exclude := cascadeUniverse(20000)
+	fc, _ := BuildFilterCascade(include, exclude)
+
+	data, err := fc.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded FilterCascade
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Levels() != fc.Levels() {
+		t.Errorf("Expected %d levels, got %d", fc.Levels(), decoded.Levels())
+	}
+	assertCascadeExact(t, &decoded, include, exclude)
+
+	var bf CacheOptimizedBloomFilter
+	if err := bf.UnmarshalBinary(data); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat loading a cascade as a bloom filter, got %v", err)
+	}
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+
+	var buf bytes.Buffer
+	fc.WriteTo(&buf)
+	buf.WriteString("tail")
+	if _, err := decoded.ReadFrom(&buf); err != nil || buf.String() != "tail" {
+		t.Errorf("ReadFrom did not stop at the end of the cascade: %v", err)
+	}
+}
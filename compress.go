@@ -0,0 +1,349 @@
+package bloomfilter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"runtime"
+)
+
+/*
+Compressed payload
+
+A sparse filter is mostly zero bits, so instead of the cache line array the
+payload can hold the gaps between consecutive set bits. Each gap g (the number
+of zero bits skipped since the previous set bit) is Golomb-Rice coded with the
+header's parameter k: g>>k in unary (that many 1 bits, then a 0) followed by
+the low k bits of g, most significant bit first. The stream is padded with
+zero bits to a whole byte.
+
+k is derived from the density of the filter, and the compressed form is only
+written when it is smaller than the raw payload, so dense filters stay raw.
+*/
+
+// riceParameter picks the Rice parameter for setBits set bits spread over bitCount bits
+func riceParameter(bitCount, setBits uint64) uint8 {
+	if setBits == 0 {
+		return 0
+	}
+	// Gaps are roughly geometric; 2^k near ln2 times the mean gap minimizes the code
+	meanGap := float64(bitCount-setBits) / float64(setBits)
+	k := math.Floor(math.Log2(meanGap * math.Ln2))
+	if k < 0 || math.IsNaN(k) {
+		return 0
+	}
+	return uint8(min(k, 62))
+}
+
+// forEachGap calls fn with the gap before each set bit, in bit order
+func (bf *CacheOptimizedBloomFilter) forEachGap(fn func(gap uint64)) {
+	next := uint64(0) // position after the previous set bit
+	for i := range bf.cacheLines {
+		for j, word := range bf.cacheLines[i].words {
+			base := uint64(i)*BitsPerCacheLine + uint64(j)*64
+			for word != 0 {
+				pos := base + uint64(bits.TrailingZeros64(word))
+				fn(pos - next)
+				next = pos + 1
+				word &= word - 1
+			}
+		}
+	}
+}
+
+// compressedHeader returns the header for the compressed encoding and
+// whether it is smaller than the raw one
+func (bf *CacheOptimizedBloomFilter) compressedHeader() (filterHeader, bool) {
+	if bf.cacheLineCount == 0 {
+		return bf.header(), false
+	}
+	return compressedHeaderFor(bf.header(), bf.PopCount(), bf.forEachGap)
+}
+
+// compressedHeaderFor sizes the Rice code for setBits bits whose gaps are
+// produced by gaps. Sizing takes a pass over the bits but allocates nothing.
+func compressedHeaderFor(h filterHeader, setBits uint64, gaps func(fn func(gap uint64))) (filterHeader, bool) {
+	k := riceParameter(h.bitCount, setBits)
+	var codeBits uint64
+	gaps(func(gap uint64) {
+		codeBits += gap>>k + 1 + uint64(k)
+	})
+
+	size := (codeBits + 7) / 8
+	if size >= h.payloadSize {
+		return h, false
+	}
+	h.flags |= flagCompressed
+	h.payloadSize = size
+	h.setBits = setBits
+	h.riceParam = k
+	return h, true
+}
+
+// CompressedSize returns the number of bytes WriteCompressedTo would write
+func (bf *CacheOptimizedBloomFilter) CompressedSize() uint64 {
+	h, _ := bf.compressedHeader()
+	return headerSize + h.payloadSize
+}
+
+// CompressionRatio returns the raw serialized size divided by the compressed
+// one; 1 means the filter is too dense to benefit
+func (bf *CacheOptimizedBloomFilter) CompressionRatio() float64 {
+	return float64(headerSize+bf.cacheLineCount*CacheLineSize) / float64(bf.CompressedSize())
+}
+
+// WriteCompressedTo writes the filter in the binary format with a
+// Golomb-Rice coded payload, or with the raw payload when the filter is too
+// dense for that to be smaller. The code is streamed through a small buffer.
+func (bf *CacheOptimizedBloomFilter) WriteCompressedTo(w io.Writer) (int64, error) {
+	h, ok := bf.compressedHeader()
+	if !ok {
+		return bf.WriteTo(w)
+	}
+
+	n, err := writeCompressed(w, h, bf.forEachGap)
+	runtime.KeepAlive(bf)
+	return n, err
+}
+
+// writeCompressed writes a compressed header and the Rice code of the gaps
+// produced by gaps, streaming through a small buffer
+func writeCompressed(w io.Writer, h filterHeader, gaps func(fn func(gap uint64))) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriterSize(cw, payloadChunkSize)
+
+	var headerBuf [headerSize]byte
+	h.encode(headerBuf[:])
+	bw.Write(headerBuf[:])
+
+	rw := riceWriter{w: bw}
+	k := uint(h.riceParam)
+	gaps(func(gap uint64) {
+		rw.writeRice(gap, k)
+	})
+	rw.flush()
+
+	// bufio.Writer errors are sticky, so Flush reports any earlier failure
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// MarshalCompressed encodes the filter like WriteCompressedTo
+func (bf *CacheOptimizedBloomFilter) MarshalCompressed() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(int(bf.CompressedSize()))
+	if _, err := bf.WriteCompressedTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalCompressed decodes a compressed payload held in memory
+func (bf *CacheOptimizedBloomFilter) unmarshalCompressed(h filterHeader, payload []byte) error {
+	if uint64(len(payload)) != h.payloadSize {
+		return fmt.Errorf("%w: payload is %d bytes, expected %d", ErrInvalidFormat, len(payload), h.payloadSize)
+	}
+	_, err := bf.readCompressedFrom(h, bytes.NewReader(payload))
+	return err
+}
+
+// readCompressedFrom decodes a compressed payload straight into a new
+// filter's cache lines. Positions are collected until they would take as
+// much memory as the cache lines; each costs at least one payload bit, so
+// nothing larger than 64 times the bytes read is allocated before the cache
+// lines are justified or the payload has been read in full.
+func (bf *CacheOptimizedBloomFilter) readCompressedFrom(h filterHeader, r io.Reader) (int64, error) {
+	if _, err := checkPayloadSource(r, h.payloadSize); err != nil {
+		return 0, err
+	}
+
+	var positions []uint64
+	var filter *CacheOptimizedBloomFilter
+	setBit := func(pos uint64) {
+		filter.cacheLines[pos/BitsPerCacheLine].words[(pos%BitsPerCacheLine)/64] |= 1 << (pos % 64)
+	}
+	densify := func() {
+		filter = newFilterWithMemory(h.cacheLineCount, h.hashCount, allocateCacheLines(h.cacheLineCount, filterOptions{}))
+		for _, pos := range positions {
+			setBit(pos)
+		}
+		positions = nil
+	}
+
+	n, err := readCompressed(h, r, func(pos uint64) {
+		if filter != nil {
+			setBit(pos)
+			return
+		}
+		positions = append(positions, pos)
+		if uint64(len(positions)) >= h.cacheLineCount*WordsPerCacheLine {
+			densify()
+		}
+	})
+	if err != nil {
+		return n, err
+	}
+	if filter == nil {
+		densify()
+	}
+
+	*bf = *filter
+	return n, nil
+}
+
+// readCompressed decodes a compressed payload, reading exactly h.payloadSize
+// bytes from r and calling set with each set bit position in ascending order
+func readCompressed(h filterHeader, r io.Reader, set func(pos uint64)) (int64, error) {
+	lr := &io.LimitedReader{R: r, N: int64(h.payloadSize)}
+	rr := riceReader{r: bufio.NewReaderSize(lr, payloadChunkSize)}
+	err := decodeRice(&rr, h, set)
+	read := int64(h.payloadSize) - lr.N - int64(rr.r.Buffered())
+	if err != nil {
+		return read, err
+	}
+	if read != int64(h.payloadSize) {
+		return read, fmt.Errorf("%w: %d unused payload bytes", ErrInvalidFormat, int64(h.payloadSize)-read)
+	}
+	return read, nil
+}
+
+// decodeRice reads h.setBits gaps and reports the resulting bit positions
+func decodeRice(rr *riceReader, h filterHeader, set func(pos uint64)) error {
+	k := uint(h.riceParam)
+	next := uint64(0)
+	for i := uint64(0); i < h.setBits; i++ {
+		q := rr.readUnary(h.bitCount >> k)
+		gap := q<<k | rr.readBits(k)
+		if rr.err != nil {
+			return rr.err
+		}
+		if gap >= h.bitCount-next {
+			return fmt.Errorf("%w: set bit beyond the end of the filter", ErrInvalidFormat)
+		}
+
+		pos := next + gap
+		set(pos)
+		next = pos + 1
+	}
+
+	if rr.acc&(1<<rr.n-1) != 0 {
+		return fmt.Errorf("%w: nonzero padding", ErrInvalidFormat)
+	}
+	return nil
+}
+
+// riceWriter packs Golomb-Rice codes most significant bit first
+type riceWriter struct {
+	w   *bufio.Writer
+	acc uint64 // the low n bits are pending output
+	n   uint
+}
+
+// writeBits appends the low n bits of v; n must be at most 56
+func (rw *riceWriter) writeBits(v uint64, n uint) {
+	rw.acc = rw.acc<<n | v&(1<<n-1)
+	rw.n += n
+	for rw.n >= 8 {
+		rw.n -= 8
+		rw.w.WriteByte(byte(rw.acc >> rw.n))
+	}
+}
+
+// writeRice appends the code for one gap
+func (rw *riceWriter) writeRice(gap uint64, k uint) {
+	q := gap >> k
+	for ; q >= 56; q -= 56 {
+		rw.writeBits(math.MaxUint64, 56)
+	}
+	rw.writeBits((1<<q-1)<<1, uint(q)+1)
+
+	if k > 32 {
+		rw.writeBits(gap>>32, k-32)
+		k = 32
+	}
+	rw.writeBits(gap, k)
+}
+
+// flush pads the last partial byte with zero bits and writes it
+func (rw *riceWriter) flush() {
+	if rw.n > 0 {
+		rw.w.WriteByte(byte(rw.acc << (8 - rw.n)))
+		rw.n = 0
+	}
+}
+
+// riceReader unpacks Golomb-Rice codes; the first error is kept in err
+type riceReader struct {
+	r   *bufio.Reader
+	acc uint64 // the low n bits of the current byte are unread
+	n   uint
+	err error
+}
+
+// fill loads the next byte, reporting false at the end of the stream
+func (rr *riceReader) fill() bool {
+	if rr.err != nil {
+		return false
+	}
+	b, err := rr.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		rr.err = err
+		return false
+	}
+	rr.acc, rr.n = uint64(b), 8
+	return true
+}
+
+// readUnary reads a unary quotient, failing once it exceeds limit
+func (rr *riceReader) readUnary(limit uint64) uint64 {
+	var q uint64
+	for q <= limit {
+		if rr.n == 0 && !rr.fill() {
+			return 0
+		}
+		// Count the leading ones among the unread bits
+		ones := uint(bits.LeadingZeros64(^(rr.acc << (64 - rr.n))))
+		if ones < rr.n {
+			rr.n -= ones + 1
+			return q + uint64(ones)
+		}
+		q += uint64(rr.n)
+		rr.n = 0
+	}
+	rr.err = fmt.Errorf("%w: gap beyond the end of the filter", ErrInvalidFormat)
+	return 0
+}
+
+// readBits reads an n-bit value
+func (rr *riceReader) readBits(n uint) uint64 {
+	var v uint64
+	for n > 0 {
+		if rr.n == 0 && !rr.fill() {
+			return 0
+		}
+		take := min(n, rr.n)
+		rr.n -= take
+		v = v<<take | rr.acc>>rr.n&(1<<take-1)
+		n -= take
+	}
+	return v
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,134 @@
+package bloomfilter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestCompressedRoundTrip tests compressed encoding at several fill levels
+func TestCompressedRoundTrip(t *testing.T) {
+	for _, count := range []int{0, 1, 100, 5000, 100000} {
+		t.Run(fmt.Sprintf("Elements%d", count), func(t *testing.T) {
+			bf := NewCacheOptimizedBloomFilter(100000, 0.01)
+			for i := 0; i < count; i++ {
+				bf.AddString(fmt.Sprintf("compress_%d", i))
+			}
+
+			data, err := bf.MarshalCompressed()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uint64(len(data)) != bf.CompressedSize() {
+				t.Errorf("CompressedSize %d does not match encoded length %d", bf.CompressedSize(), len(data))
+			}
+
+			decoded := &CacheOptimizedBloomFilter{}
+			if err := decoded.UnmarshalBinary(data); err != nil {
+				t.Fatalf("UnmarshalBinary failed: %v", err)
+			}
+			assertSameFilter(t, bf, decoded)
+
+			// Streaming decode must stop exactly at the end of the filter
+			var buf bytes.Buffer
+			n, err := bf.WriteCompressedTo(&buf)
+			if err != nil || n != int64(len(data)) {
+				t.Fatalf("WriteCompressedTo wrote %d bytes (%v), expected %d", n, err, len(data))
+			}
+			buf.WriteString("trailer")
+			streamed := &CacheOptimizedBloomFilter{}
+			if n, err := streamed.ReadFrom(&buf); err != nil || n != int64(len(data)) {
+				t.Fatalf("ReadFrom read %d bytes (%v), expected %d", n, err, len(data))
+			}
+			assertSameFilter(t, bf, streamed)
+			if buf.String() != "trailer" {
+				t.Errorf("ReadFrom consumed data past the filter")
+			}
+		})
+	}
+}
+
+// TestCompressionRatio tests that sparse filters shrink and dense ones stay raw
+func TestCompressionRatio(t *testing.T) {
+	bf := NewCacheOptimizedBloomFilter(100000, 0.01)
+	for i := 0; i < 1000; i++ {
+		bf.AddString(fmt.Sprintf("sparse_%d", i))
+	}
+	if ratio := bf.CompressionRatio(); ratio < 5 {
+		t.Errorf("Expected a 1%% full filter to compress at least 5x, got %.2f", ratio)
+	}
+
+	for i := 0; i < 200000; i++ {
+		bf.AddString(fmt.Sprintf("dense_%d", i))
+	}
+	if ratio := bf.CompressionRatio(); ratio != 1 {
+		t.Errorf("Expected an overfull filter to stay raw, got ratio %.2f", ratio)
+	}
+	data, _ := bf.MarshalCompressed()
+	raw, _ := bf.MarshalBinary()
+	if !bytes.Equal(data, raw) {
+		t.Error("Dense filter was not written in the raw format")
+	}
+}
+
+// TestCompressedRejected tests corrupt compressed data and raw-only readers
+func TestCompressedRejected(t *testing.T) {
+	bf := NewCacheOptimizedBloomFilter(10000, 0.01)
+	for i := 0; i < 100; i++ {
+		bf.AddString(fmt.Sprintf("item_%d", i))
+	}
+	data, _ := bf.MarshalCompressed()
+
+	var decoded CacheOptimizedBloomFilter
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat for truncated payload, got %v", err)
+	}
+	if _, err := decoded.ReadFrom(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("Expected error reading a truncated stream")
+	}
+
+	// Claiming more set bits than are coded runs past the payload
+	corrupt := append([]byte(nil), data...)
+	corrupt[48]++
+	if err := decoded.UnmarshalBinary(corrupt); err == nil {
+		t.Error("Expected error for inflated set bit count")
+	}
+
+	if _, err := NewFilterView(data); !errors.Is(err, ErrCompressed) {
+		t.Errorf("Expected ErrCompressed from NewFilterView, got %v", err)
+	}
+	if _, err := NewReaderAtFilter(bytes.NewReader(data), 0); !errors.Is(err, ErrCompressed) {
+		t.Errorf("Expected ErrCompressed from NewReaderAtFilter, got %v", err)
+	}
+}
+
+// TestRiceCodeRoundTrip tests the bit packing across quotient and parameter extremes
+func TestRiceCodeRoundTrip(t *testing.T) {
+	gaps := []uint64{0, 1, 55, 56, 57, 200, 1 << 20, 1<<40 + 12345}
+	for _, k := range []uint{0, 3, 33, 45} {
+		var buf bytes.Buffer
+		bw := bufio.NewWriter(&buf)
+		rw := riceWriter{w: bw}
+		for _, gap := range gaps {
+			if gap>>k > 1<<16 {
+				continue
+			}
+			rw.writeRice(gap, k)
+		}
+		rw.flush()
+		bw.Flush()
+
+		rr := riceReader{r: bufio.NewReader(&buf)}
+		for _, gap := range gaps {
+			if gap>>k > 1<<16 {
+				continue
+			}
+			got := rr.readUnary(1<<20)<<k | rr.readBits(k)
+			if rr.err != nil || got != gap {
+				t.Fatalf("k=%d: decoded %d (%v), expected %d", k, got, rr.err, gap)
+			}
+		}
+	}
+}
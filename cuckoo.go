@@ -0,0 +1,303 @@
+package bloomfilter
+
+import (
+	"math"
+	"math/bits"
+	"runtime"
+	"unsafe"
+)
+
+// Cuckoo filter geometry: 16-bit fingerprints, four per bucket, so a bucket
+// is one 64-bit word and a cache line holds eight buckets
+const (
+	cuckooSlotsPerBucket  = 4
+	cuckooBucketsPerLine  = WordsPerCacheLine
+	cuckooFingerprintBits = 16
+
+	// Relocations attempted before an insert falls back to the stash
+	cuckooMaxKicks = 500
+	// Entries the victim stash can hold
+	cuckooStashSize = 8
+	// Load the table is sized for; four-slot buckets fill to about 95%
+	cuckooTargetLoad = 0.95
+)
+
+// CuckooFilter is a cuckoo filter with 16-bit fingerprints that supports
+// deletion. Each element lives in one of two candidate buckets of four
+// fingerprints. Eight buckets share each 64-byte cache line of the same
+// aligned storage the bloom filter uses, so a lookup touches at most two
+// lines; a bucket is searched with a single SIMDOperations.MatchUint16 lane
+// compare over its line, masked to its four slots. Small buckets keep the
+// false positive rate, which grows with the slots compared, near 2^-13.
+//
+// When an insert cannot be placed within the kick-out bound, the displaced
+// fingerprint goes to a small victim stash; an insert that would need a full
+// stash reports failure and leaves the filter unchanged.
+type CuckooFilter struct {
+	cacheLines  []CacheLine
+	memory      *cacheLineMemory
+	bucketCount uint64 // power of two
+	count       uint64
+
+	stash []cuckooStashEntry
+	// xorshift state choosing kick-out victims
+	rng uint64
+	// Kick-out path of the current insert, kept to undo it when the stash is full
+	kicks []cuckooKick
+
+	simdOps SIMDOperations
+}
+
+// cuckooStashEntry is a fingerprint that could not be placed in the table
+type cuckooStashEntry struct {
+	bucket      uint64
+	fingerprint uint16
+}
+
+// cuckooKick records the fingerprint a relocation overwrote
+type cuckooKick struct {
+	bucket   uint64
+	slot     int
+	previous uint16
+}
+
+// NewCuckooFilter creates a cuckoo filter able to hold at least capacity
+// elements. Options control the allocation as for NewCacheOptimizedBloomFilter.
+func NewCuckooFilter(capacity uint64, opts ...Option) *CuckooFilter {
+	buckets := uint64(math.Ceil(float64(capacity) / (cuckooSlotsPerBucket * cuckooTargetLoad)))
+	// Partial-key cuckoo hashing needs a power-of-two bucket count; use at
+	// least one line of them so elements have somewhere to move
+	buckets = max(buckets, cuckooBucketsPerLine)
+	buckets = 1 << bits.Len64(buckets-1)
+
+	memory := allocateCacheLines(buckets/cuckooBucketsPerLine, applyOptions(opts))
+	simdOps, _ := selectSIMDOperations()
+
+	return &CuckooFilter{
+		cacheLines:  memory.lines,
+		memory:      memory,
+		bucketCount: buckets,
+		rng:         0x9E3779B97F4A7C15,
+		simdOps:     simdOps,
+	}
+}
+
+// locate derives the fingerprint and primary bucket for data. Both hashes
+// are mixed first: similar keys differ in few of their bits, which crowds
+// them into a handful of four-slot buckets. Fingerprints are never zero,
+// which marks an empty slot.
+func (cf *CuckooFilter) locate(data []byte) (fingerprint uint16, bucket uint64) {
+	fingerprint = uint16(fuseMix(hashOptimized2(data), 0) >> (64 - cuckooFingerprintBits))
+	if fingerprint == 0 {
+		fingerprint = 1
+	}
+	return fingerprint, fuseMix(hashOptimized1(data), 0) & (cf.bucketCount - 1)
+}
+
+// altBucket returns the other candidate bucket for a fingerprint. It is its
+// own inverse, so it can be computed from either bucket.
+func (cf *CuckooFilter) altBucket(bucket uint64, fingerprint uint16) uint64 {
+	return (bucket ^ (uint64(fingerprint) * 0xC6A4A7935BD1E995 >> 32)) & (cf.bucketCount - 1)
+}
+
+// slots returns the fingerprints of a bucket, which fill one word of its line
+func (cf *CuckooFilter) slots(bucket uint64) *[cuckooSlotsPerBucket]uint16 {
+	line := &cf.cacheLines[bucket/cuckooBucketsPerLine]
+	return (*[cuckooSlotsPerBucket]uint16)(unsafe.Pointer(&line.words[bucket%cuckooBucketsPerLine]))
+}
+
+// findInBucket returns the first slot of a bucket holding value, or -1
+func (cf *CuckooFilter) findInBucket(bucket uint64, value uint16) int {
+	// Match the whole line, then keep the lanes of this bucket's word
+	line := &cf.cacheLines[bucket/cuckooBucketsPerLine]
+	mask := cf.simdOps.MatchUint16(unsafe.Pointer(line), value)
+	mask = mask >> (bucket % cuckooBucketsPerLine * cuckooSlotsPerBucket) & (1<<cuckooSlotsPerBucket - 1)
+	runtime.KeepAlive(cf)
+	if mask == 0 {
+		return -1
+	}
+	return bits.TrailingZeros32(mask)
+}
+
+// insertIntoBucket places value in an empty slot, reporting success
+func (cf *CuckooFilter) insertIntoBucket(bucket uint64, value uint16) bool {
+	if slot := cf.findInBucket(bucket, 0); slot >= 0 {
+		cf.slots(bucket)[slot] = value
+		return true
+	}
+	return false
+}
+
+// Add inserts an element. It returns false only when both candidate buckets
+// are full and relocating fingerprints would need room in a full victim
+// stash, in which case the filter is unchanged.
+func (cf *CuckooFilter) Add(data []byte) bool {
+	fingerprint, i1 := cf.locate(data)
+	i2 := cf.altBucket(i1, fingerprint)
+	if cf.insertIntoBucket(i1, fingerprint) || cf.insertIntoBucket(i2, fingerprint) {
+		cf.count++
+		return true
+	}
+
+	// Only a full stash can make the relocations below fail, so only then
+	// are they recorded for undoing
+	stashFull := len(cf.stash) >= cuckooStashSize
+	cf.kicks = cf.kicks[:0]
+
+	// Evict a random fingerprint and move it to its alternate bucket
+	bucket := i1
+	if cf.nextRandom()&1 == 1 {
+		bucket = i2
+	}
+	for kick := 0; kick < cuckooMaxKicks; kick++ {
+		slots := cf.slots(bucket)
+		slot := int(cf.nextRandom() % cuckooSlotsPerBucket)
+		evicted := slots[slot]
+		slots[slot] = fingerprint
+		if stashFull {
+			cf.kicks = append(cf.kicks, cuckooKick{bucket: bucket, slot: slot, previous: evicted})
+		}
+		fingerprint = evicted
+
+		bucket = cf.altBucket(bucket, fingerprint)
+		if cf.insertIntoBucket(bucket, fingerprint) {
+			cf.count++
+			return true
+		}
+	}
+
+	if stashFull {
+		// Put every displaced fingerprint back where it was
+		for i := len(cf.kicks) - 1; i >= 0; i-- {
+			k := cf.kicks[i]
+			cf.slots(k.bucket)[k.slot] = k.previous
+		}
+		return false
+	}
+
+	// The element in hand is no longer the one being added, so it cannot be dropped
+	cf.stash = append(cf.stash, cuckooStashEntry{bucket: bucket, fingerprint: fingerprint})
+	cf.count++
+	return true
+}
+
+// Contains checks membership
+func (cf *CuckooFilter) Contains(data []byte) bool {
+	fingerprint, i1 := cf.locate(data)
+	i2 := cf.altBucket(i1, fingerprint)
+	cf.simdOps.Prefetch(unsafe.Pointer(&cf.cacheLines[i2/cuckooBucketsPerLine]))
+
+	found := cf.findInBucket(i1, fingerprint) >= 0 ||
+		cf.findInBucket(i2, fingerprint) >= 0 ||
+		cf.stashIndex(i1, i2, fingerprint) >= 0
+	runtime.KeepAlive(cf)
+	return found
+}
+
+// Delete removes one occurrence of an element, reporting whether it was
+// found. Only delete elements that were added: removing a false positive
+// deletes another element's fingerprint.
+func (cf *CuckooFilter) Delete(data []byte) bool {
+	fingerprint, i1 := cf.locate(data)
+	i2 := cf.altBucket(i1, fingerprint)
+
+	for _, bucket := range [2]uint64{i1, i2} {
+		if slot := cf.findInBucket(bucket, fingerprint); slot >= 0 {
+			cf.slots(bucket)[slot] = 0
+			cf.count--
+			cf.drainStash()
+			return true
+		}
+	}
+	if i := cf.stashIndex(i1, i2, fingerprint); i >= 0 {
+		cf.stash = append(cf.stash[:i], cf.stash[i+1:]...)
+		cf.count--
+		return true
+	}
+	return false
+}
+
+// AddString adds a string element
+func (cf *CuckooFilter) AddString(s string) bool {
+	return cf.Add(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// ContainsString checks if a string element exists
+func (cf *CuckooFilter) ContainsString(s string) bool {
+	return cf.Contains(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// DeleteString removes a string element
+func (cf *CuckooFilter) DeleteString(s string) bool {
+	return cf.Delete(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// stashIndex returns the stash entry for a fingerprint in either bucket, or -1
+func (cf *CuckooFilter) stashIndex(i1, i2 uint64, fingerprint uint16) int {
+	for i, entry := range cf.stash {
+		if entry.fingerprint == fingerprint && (entry.bucket == i1 || entry.bucket == i2) {
+			return i
+		}
+	}
+	return -1
+}
+
+// drainStash moves stashed fingerprints back into the table where there is now room
+func (cf *CuckooFilter) drainStash() {
+	kept := cf.stash[:0]
+	for _, entry := range cf.stash {
+		if !cf.insertIntoBucket(entry.bucket, entry.fingerprint) &&
+			!cf.insertIntoBucket(cf.altBucket(entry.bucket, entry.fingerprint), entry.fingerprint) {
+			kept = append(kept, entry)
+		}
+	}
+	cf.stash = kept
+}
+
+// nextRandom advances the xorshift generator used to pick victims
+func (cf *CuckooFilter) nextRandom() uint64 {
+	cf.rng ^= cf.rng << 13
+	cf.rng ^= cf.rng >> 7
+	cf.rng ^= cf.rng << 17
+	return cf.rng
+}
+
+// Count returns the number of elements stored
+func (cf *CuckooFilter) Count() uint64 {
+	return cf.count
+}
+
+// Capacity returns the number of fingerprint slots in the table
+func (cf *CuckooFilter) Capacity() uint64 {
+	return cf.bucketCount * cuckooSlotsPerBucket
+}
+
+// LoadFactor returns the fraction of slots in use
+func (cf *CuckooFilter) LoadFactor() float64 {
+	return float64(cf.count) / float64(cf.Capacity())
+}
+
+// StashSize returns the number of fingerprints held in the victim stash
+func (cf *CuckooFilter) StashSize() int {
+	return len(cf.stash)
+}
+
+// EstimatedFPP estimates the false positive probability at the current load:
+// a lookup compares against the occupied slots of two buckets
+func (cf *CuckooFilter) EstimatedFPP() float64 {
+	compared := 2 * cuckooSlotsPerBucket * cf.LoadFactor()
+	return 1 - math.Pow(1-1/float64(1<<cuckooFingerprintBits-1), compared)
+}
+
+// MemoryUsage returns the bytes allocated for the table
+func (cf *CuckooFilter) MemoryUsage() uint64 {
+	return cf.memory.size
+}
+
+// Clear removes every element
+func (cf *CuckooFilter) Clear() {
+	cf.simdOps.VectorClear(unsafe.Pointer(&cf.cacheLines[0]), len(cf.cacheLines)*CacheLineSize)
+	runtime.KeepAlive(cf)
+	cf.stash = cf.stash[:0]
+	cf.count = 0
+}
@@ -0,0 +1,177 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// TestCuckooFilterBasic tests add, contains and delete
+func TestCuckooFilterBasic(t *testing.T) {
+	cf := NewCuckooFilter(10000)
+
+	for i := 0; i < 10000; i++ {
+		if !cf.AddString(fmt.Sprintf("cuckoo_%d", i)) {
+			t.Fatalf("Add failed at element %d (load %.3f)", i, cf.LoadFactor())
+		}
+	}
+	if cf.Count() != 10000 {
+		t.Errorf("Expected count 10000, got %d", cf.Count())
+	}
+	for i := 0; i < 10000; i++ {
+		if !cf.ContainsString(fmt.Sprintf("cuckoo_%d", i)) {
+			t.Fatalf("False negative for element %d", i)
+		}
+	}
+
+	for i := 0; i < 5000; i++ {
+		if !cf.DeleteString(fmt.Sprintf("cuckoo_%d", i)) {
+			t.Fatalf("Delete failed for element %d", i)
+		}
+	}
+	if cf.Count() != 5000 {
+		t.Errorf("Expected count 5000 after deletes, got %d", cf.Count())
+	}
+	for i := 5000; i < 10000; i++ {
+		if !cf.ContainsString(fmt.Sprintf("cuckoo_%d", i)) {
+			t.Fatalf("Element %d lost after deleting others", i)
+		}
+	}
+
+	present := 0
+	for i := 0; i < 5000; i++ {
+		if cf.ContainsString(fmt.Sprintf("cuckoo_%d", i)) {
+			present++
+		}
+	}
+	if present > 50 {
+		t.Errorf("%d of 5000 deleted elements still reported present", present)
+	}
+}
+
+// TestCuckooFilterFPP tests the false positive rate against the estimate
+func TestCuckooFilterFPP(t *testing.T) {
+	cf := NewCuckooFilter(50000)
+	for i := 0; i < 50000; i++ {
+		cf.AddString(fmt.Sprintf("member_%d", i))
+	}
+
+	falsePositives := 0
+	const trials = 200000
+	for i := 0; i < trials; i++ {
+		if cf.ContainsString(fmt.Sprintf("absent_%d", i)) {
+			falsePositives++
+		}
+	}
+	rate := float64(falsePositives) / trials
+	if rate > 3*cf.EstimatedFPP() {
+		t.Errorf("False positive rate %.5f, estimated %.5f", rate, cf.EstimatedFPP())
+	}
+}
+
+// TestCuckooFilterBitsPerKey tests that a loaded cuckoo filter takes fewer
+// bits per key than a bloom filter with the same false positive rate
+func TestCuckooFilterBitsPerKey(t *testing.T) {
+	// Just under the 95% target load of 16384 buckets, so the power-of-two
+	// rounding costs nothing
+	const n = 62000
+	cf := NewCuckooFilter(n)
+	for i := 0; i < n; i++ {
+		if !cf.AddString(fmt.Sprintf("space_%d", i)) {
+			t.Fatalf("Add failed at element %d (load %.3f)", i, cf.LoadFactor())
+		}
+	}
+
+	bf := NewCacheOptimizedBloomFilter(n, cf.EstimatedFPP())
+	cuckooBits := float64(cf.MemoryUsage()*8) / n
+	bloomBits := float64(bf.GetCacheStats().MemoryUsage*8) / n
+	if cuckooBits >= bloomBits {
+		t.Errorf("Cuckoo filter uses %.2f bits per key at FPP %.6f, bloom filter %.2f",
+			cuckooBits, cf.EstimatedFPP(), bloomBits)
+	}
+	t.Logf("FPP %.6f: cuckoo %.2f bits per key, bloom %.2f", cf.EstimatedFPP(), cuckooBits, bloomBits)
+}
+
+// TestCuckooFilterFull tests the victim stash and refusal once full
+func TestCuckooFilterFull(t *testing.T) {
+	cf := NewCuckooFilter(32)
+	capacity := cf.Capacity()
+
+	added := uint64(0)
+	for i := 0; ; i++ {
+		if !cf.AddString(fmt.Sprintf("full_%d", i)) {
+			break
+		}
+		added++
+		if added > 2*capacity {
+			t.Fatal("Filter never reported being full")
+		}
+	}
+	if cf.StashSize() != cuckooStashSize {
+		t.Errorf("Expected a full stash, got %d entries", cf.StashSize())
+	}
+	if cf.LoadFactor() < 0.9 {
+		t.Errorf("Filter refused inserts at load %.3f", cf.LoadFactor())
+	}
+
+	// Every accepted element, including stashed ones, is still present
+	for i := uint64(0); i < added; i++ {
+		if !cf.ContainsString(fmt.Sprintf("full_%d", i)) {
+			t.Fatalf("Accepted element %d lost", i)
+		}
+	}
+
+	// Deleting makes room and drains the stash back into the table
+	for i := uint64(0); i < added/2; i++ {
+		cf.DeleteString(fmt.Sprintf("full_%d", i))
+	}
+	if cf.StashSize() != 0 {
+		t.Errorf("Expected the stash to drain, %d entries left", cf.StashSize())
+	}
+	for i := added / 2; i < added; i++ {
+		if !cf.ContainsString(fmt.Sprintf("full_%d", i)) {
+			t.Fatalf("Element %d lost while draining the stash", i)
+		}
+	}
+
+	cf.Clear()
+	if cf.Count() != 0 || cf.ContainsString("full_1") {
+		t.Error("Clear did not empty the filter")
+	}
+}
+
+// TestCuckooFilterFullStash tests that a full stash only refuses inserts
+// that would need it, and that a refused insert leaves the table unchanged
+func TestCuckooFilterFullStash(t *testing.T) {
+	cf := NewCuckooFilter(1000)
+
+	// A full stash of entries that match nothing
+	for i := 0; i < cuckooStashSize; i++ {
+		cf.stash = append(cf.stash, cuckooStashEntry{bucket: uint64(i), fingerprint: 0xFFFF})
+	}
+
+	added := 0
+	for ; ; added++ {
+		before := slices.Clone(cf.cacheLines)
+		if !cf.AddString(fmt.Sprintf("stash_%d", added)) {
+			if !slices.Equal(before, cf.cacheLines) {
+				t.Error("A refused insert modified the table")
+			}
+			break
+		}
+		if added > int(cf.Capacity()) {
+			t.Fatal("Filter never reported being full")
+		}
+	}
+	if cf.LoadFactor() < 0.9 {
+		t.Errorf("Inserts refused at load %.3f although buckets had free slots", cf.LoadFactor())
+	}
+	if cf.StashSize() != cuckooStashSize {
+		t.Errorf("Expected the stash to stay full, got %d entries", cf.StashSize())
+	}
+	for i := 0; i < added; i++ {
+		if !cf.ContainsString(fmt.Sprintf("stash_%d", i)) {
+			t.Fatalf("Accepted element %d lost", i)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package bloomfilter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+/*
+Delta format
+
+A FilterDelta serializes as a 32-byte little-endian header followed by one
+entry per cache line:
+
+	offset  size  field
+	0       4     magic "BLMD"
+	4       2     format version
+	6       2     reserved, zero
+	8       4     hash count
+	12      4     reserved, zero
+	16      8     cache line count of the filter
+	24      8     number of entries
+
+Each entry is the uvarint gap to the previous index (the first is the index
+itself) followed by the 64-byte cache line, words little-endian.
+*/
+
+const (
+	// Size of the serialized delta header
+	deltaHeaderSize = 32
+	// Current delta format version
+	deltaFormatVersion = 1
+)
+
+// Magic bytes identifying a serialized delta
+var deltaMagic = [4]byte{'B', 'L', 'M', 'D'}
+
+// FilterDelta holds the contents of a set of cache lines of a filter. Applying
+// it ORs the lines into a replica of the same size, so deltas only carry
+// additions: after Clear or Intersection a replica needs a full copy.
+type FilterDelta struct {
+	// Parameters of the filter the delta was taken from
+	CacheLineCount uint64
+	HashCount      uint32
+	// Indices of the changed cache lines in ascending order and their contents
+	Indices []uint64
+	Lines   []CacheLine
+}
+
+// EnableDirtyTracking starts recording which cache lines are modified by Add
+// and Union. Every line starts clean; call ExportDelta to collect changes
+// and Checkpoint to start a new interval.
+func (bf *CacheOptimizedBloomFilter) EnableDirtyTracking() {
+	if bf.dirty == nil {
+		bf.dirty = make([]uint64, (bf.cacheLineCount+63)/64)
+	}
+}
+
+// DirtyTracking reports whether dirty tracking is enabled
+func (bf *CacheOptimizedBloomFilter) DirtyTracking() bool {
+	return bf.dirty != nil
+}
+
+// DirtyCount returns the number of cache lines modified since the last checkpoint
+func (bf *CacheOptimizedBloomFilter) DirtyCount() int {
+	count := 0
+	for _, word := range bf.dirty {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// Checkpoint marks every cache line clean
+func (bf *CacheOptimizedBloomFilter) Checkpoint() {
+	clear(bf.dirty)
+}
+
+// ExportDelta returns the cache lines modified since the last checkpoint.
+// It does not reset the dirty set; call Checkpoint once the delta has been
+// delivered.
+func (bf *CacheOptimizedBloomFilter) ExportDelta() (*FilterDelta, error) {
+	if bf.dirty == nil {
+		return nil, fmt.Errorf("dirty tracking is not enabled")
+	}
+
+	count := bf.DirtyCount()
+	delta := &FilterDelta{
+		CacheLineCount: bf.cacheLineCount,
+		HashCount:      bf.hashCount,
+		Indices:        make([]uint64, 0, count),
+		Lines:          make([]CacheLine, 0, count),
+	}
+	for i, word := range bf.dirty {
+		for word != 0 {
+			idx := uint64(i)*64 + uint64(bits.TrailingZeros64(word))
+			delta.Indices = append(delta.Indices, idx)
+			delta.Lines = append(delta.Lines, bf.cacheLines[idx])
+			word &= word - 1
+		}
+	}
+	return delta, nil
+}
+
+// ApplyDelta ORs the delta's cache lines into the filter, which must have the
+// same size and hash count as the filter the delta came from. Lines it
+// changes are marked dirty, so replicas can forward deltas in turn.
+func (bf *CacheOptimizedBloomFilter) ApplyDelta(delta *FilterDelta) error {
+	if delta.CacheLineCount != bf.cacheLineCount || delta.HashCount != bf.hashCount {
+		return fmt.Errorf("delta is for a filter with %d cache lines and %d hashes, not %d and %d",
+			delta.CacheLineCount, delta.HashCount, bf.cacheLineCount, bf.hashCount)
+	}
+	if len(delta.Indices) != len(delta.Lines) {
+		return fmt.Errorf("delta has %d indices but %d cache lines", len(delta.Indices), len(delta.Lines))
+	}
+	for _, idx := range delta.Indices {
+		if idx >= bf.cacheLineCount {
+			return fmt.Errorf("delta cache line %d out of range", idx)
+		}
+	}
+
+	for i, idx := range delta.Indices {
+		line := &bf.cacheLines[idx]
+		changed := false
+		for j, word := range delta.Lines[i].words {
+			if word&^line.words[j] != 0 {
+				line.words[j] |= word
+				changed = true
+			}
+		}
+		if changed && bf.dirty != nil {
+			bf.dirty[idx/64] |= 1 << (idx % 64)
+		}
+	}
+	return nil
+}
+
+// Diff returns the cache lines of b that differ from a, in the delta format.
+// When b is a later state of a, applying the result to a copy of a yields b.
+func Diff(a, b *CacheOptimizedBloomFilter) (*FilterDelta, error) {
+	if a.cacheLineCount != b.cacheLineCount || a.hashCount != b.hashCount {
+		return nil, fmt.Errorf("bloom filters must have same size and hash count for diff")
+	}
+
+	delta := &FilterDelta{CacheLineCount: b.cacheLineCount, HashCount: b.hashCount}
+	for i := range b.cacheLines {
+		if a.cacheLines[i] != b.cacheLines[i] {
+			delta.Indices = append(delta.Indices, uint64(i))
+			delta.Lines = append(delta.Lines, b.cacheLines[i])
+		}
+	}
+	return delta, nil
+}
+
+// markChangedLines marks the lines that ORing other into bf would modify
+func (bf *CacheOptimizedBloomFilter) markChangedLines(other []CacheLine) {
+	for i := range other {
+		for j, word := range other[i].words {
+			if word&^bf.cacheLines[i].words[j] != 0 {
+				bf.dirty[i/64] |= 1 << (i % 64)
+				break
+			}
+		}
+	}
+}
+
+// MarshalBinary encodes the delta in its compact binary format
+func (d *FilterDelta) MarshalBinary() ([]byte, error) {
+	if len(d.Indices) != len(d.Lines) {
+		return nil, fmt.Errorf("delta has %d indices but %d cache lines", len(d.Indices), len(d.Lines))
+	}
+
+	buf := make([]byte, deltaHeaderSize, deltaHeaderSize+len(d.Lines)*(CacheLineSize+2))
+	copy(buf[0:4], deltaMagic[:])
+	binary.LittleEndian.PutUint16(buf[4:], deltaFormatVersion)
+	binary.LittleEndian.PutUint32(buf[8:], d.HashCount)
+	binary.LittleEndian.PutUint64(buf[16:], d.CacheLineCount)
+	binary.LittleEndian.PutUint64(buf[24:], uint64(len(d.Indices)))
+
+	var line [CacheLineSize]byte
+	prev := uint64(0)
+	for i, idx := range d.Indices {
+		if i > 0 && idx <= prev {
+			return nil, fmt.Errorf("delta indices must be strictly ascending")
+		}
+		buf = binary.AppendUvarint(buf, idx-prev)
+		prev = idx
+		encodeCacheLines(line[:], d.Lines[i:i+1])
+		buf = append(buf, line[:]...)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a delta produced by MarshalBinary
+func (d *FilterDelta) UnmarshalBinary(data []byte) error {
+	if len(data) < deltaHeaderSize || [4]byte(data[0:4]) != deltaMagic {
+		return fmt.Errorf("%w: bad delta header", ErrInvalidFormat)
+	}
+	if version := binary.LittleEndian.Uint16(data[4:]); version > deltaFormatVersion {
+		return fmt.Errorf("%w: delta version %d", ErrUnsupportedVersion, version)
+	}
+
+	cacheLineCount := binary.LittleEndian.Uint64(data[16:])
+	count := binary.LittleEndian.Uint64(data[24:])
+	// Every entry takes at least one gap byte and a cache line
+	if count > uint64(len(data)-deltaHeaderSize)/(CacheLineSize+1) {
+		return fmt.Errorf("%w: delta truncated", ErrInvalidFormat)
+	}
+
+	indices := make([]uint64, count)
+	lines := make([]CacheLine, count)
+	rest := data[deltaHeaderSize:]
+	idx := uint64(0)
+	for i := range indices {
+		gap, n := binary.Uvarint(rest)
+		if n <= 0 || len(rest)-n < CacheLineSize {
+			return fmt.Errorf("%w: delta truncated", ErrInvalidFormat)
+		}
+		if i > 0 && gap == 0 {
+			return fmt.Errorf("%w: delta indices not ascending", ErrInvalidFormat)
+		}
+		if gap >= cacheLineCount-idx {
+			return fmt.Errorf("%w: delta cache line out of range", ErrInvalidFormat)
+		}
+		idx += gap
+		indices[i] = idx
+		decodeCacheLines(lines[i:i+1], rest[n:n+CacheLineSize])
+		rest = rest[n+CacheLineSize:]
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes after delta", ErrInvalidFormat, len(rest))
+	}
+
+	*d = FilterDelta{
+		CacheLineCount: cacheLineCount,
+		HashCount:      binary.LittleEndian.Uint32(data[8:]),
+		Indices:        indices,
+		Lines:          lines,
+	}
+	return nil
+}
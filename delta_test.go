@@ -0,0 +1,131 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestDirtyTrackingDelta tests exporting changes since a checkpoint and applying them to a replica
+func TestDirtyTrackingDelta(t *testing.T) {
+	primary := NewCacheOptimizedBloomFilter(100000, 0.01, WithDirtyTracking())
+	replica := NewCacheOptimizedBloomFilter(100000, 0.01)
+
+	for i := 0; i < 5000; i++ {
+		primary.AddString(fmt.Sprintf("base_%d", i))
+	}
+	full, err := primary.ExportDelta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.ApplyDelta(full); err != nil {
+		t.Fatal(err)
+	}
+	primary.Checkpoint()
+	if primary.DirtyCount() != 0 {
+		t.Fatalf("Expected no dirty lines after checkpoint, got %d", primary.DirtyCount())
+	}
+
+	for i := 0; i < 100; i++ {
+		primary.AddString(fmt.Sprintf("incremental_%d", i))
+	}
+	delta, err := primary.ExportDelta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Indices) == 0 || len(delta.Indices) > 100*int(primary.hashCount) {
+		t.Errorf("Unexpected delta size %d for 100 inserts", len(delta.Indices))
+	}
+	if uint64(len(delta.Indices)) >= primary.cacheLineCount/2 {
+		t.Errorf("Delta of %d lines is not compact (filter has %d)", len(delta.Indices), primary.cacheLineCount)
+	}
+
+	data, err := delta.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded FilterDelta
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if err := replica.ApplyDelta(&decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	assertSameFilter(t, primary, replica)
+}
+
+// TestDirtyTrackingUnion tests that Union marks only the lines it changes
+func TestDirtyTrackingUnion(t *testing.T) {
+	bf := NewCacheOptimizedBloomFilter(10000, 0.01)
+	bf.EnableDirtyTracking()
+	other := NewCacheOptimizedBloomFilter(10000, 0.01)
+	other.AddString("only_in_other")
+
+	bf.Union(other)
+	if bf.DirtyCount() == 0 || bf.DirtyCount() > int(bf.hashCount) {
+		t.Errorf("Expected 1..%d dirty lines after union, got %d", bf.hashCount, bf.DirtyCount())
+	}
+
+	bf.Checkpoint()
+	bf.Union(other)
+	if bf.DirtyCount() != 0 {
+		t.Errorf("Union adding no bits marked %d lines dirty", bf.DirtyCount())
+	}
+}
+
+// TestDiff tests that the difference of two snapshots brings the older one up to date
+func TestDiff(t *testing.T) {
+	before := NewCacheOptimizedBloomFilter(10000, 0.01)
+	for i := 0; i < 1000; i++ {
+		before.AddString(fmt.Sprintf("item_%d", i))
+	}
+	data, _ := before.MarshalBinary()
+	after := &CacheOptimizedBloomFilter{}
+	after.UnmarshalBinary(data)
+	for i := 1000; i < 1050; i++ {
+		after.AddString(fmt.Sprintf("item_%d", i))
+	}
+
+	delta, err := Diff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := before.ApplyDelta(delta); err != nil {
+		t.Fatal(err)
+	}
+	assertSameFilter(t, after, before)
+
+	if _, err := Diff(before, NewCacheOptimizedBloomFilter(100, 0.01)); err == nil {
+		t.Error("Expected error diffing filters of different sizes")
+	}
+}
+
+// TestDeltaErrors tests rejection of mismatched and malformed deltas
+func TestDeltaErrors(t *testing.T) {
+	bf := NewCacheOptimizedBloomFilter(10000, 0.01)
+	if _, err := bf.ExportDelta(); err == nil {
+		t.Error("Expected error exporting without dirty tracking")
+	}
+
+	small := NewCacheOptimizedBloomFilter(100, 0.01, WithDirtyTracking())
+	small.AddString("x")
+	delta, _ := small.ExportDelta()
+	if err := bf.ApplyDelta(delta); err == nil {
+		t.Error("Expected error applying a delta from a different size filter")
+	}
+
+	data, _ := delta.MarshalBinary()
+	var decoded FilterDelta
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("Expected error for truncated delta")
+	}
+	if err := decoded.UnmarshalBinary(append(data, 0)); err == nil {
+		t.Error("Expected error for trailing bytes")
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[deltaHeaderSize] = 0xFF // huge first index
+	corrupt[deltaHeaderSize+1] = 0x7F
+	if err := decoded.UnmarshalBinary(corrupt); err == nil {
+		t.Error("Expected error for out of range index")
+	}
+}
@@ -0,0 +1,365 @@
+package bloomfilter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// File names used inside a DurableFilter directory
+const (
+	durableSnapshotFile = "snapshot.bloom"
+	durableLogFile      = "wal.log"
+)
+
+// Write-ahead log record: h1, h2 and a CRC-32C of both
+const walRecordSize = 8 + 8 + 4
+
+// CRC table for write-ahead log records
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrSnapshotFailed is returned by Add and AddBatch when the elements were
+// logged and added but the automatic snapshot that followed failed; the log
+// still holds them, and the snapshot is retried after the next Add
+var ErrSnapshotFailed = errors.New("automatic snapshot failed")
+
+// walFile is the write-ahead log; an *os.File outside of tests
+type walFile interface {
+	io.ReadWriteSeeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// DurableOptions configures OpenDurable
+type DurableOptions struct {
+	// Sizing used when the directory holds no snapshot yet
+	ExpectedElements  uint64
+	FalsePositiveRate float64
+
+	// SnapshotInterval takes a snapshot automatically after this many logged
+	// elements; zero leaves snapshots to explicit Snapshot calls
+	SnapshotInterval int
+
+	// SyncWrites fsyncs the log after every Add so elements survive power
+	// loss, not only process crashes
+	SyncWrites bool
+}
+
+// DurableFilter is a CacheOptimizedBloomFilter that survives crashes. Every
+// added element's hash pair is appended to a write-ahead log before it is
+// applied; snapshots persist the whole filter and truncate the log. Opening
+// the directory loads the latest snapshot and replays the log on top of it,
+// stopping at a torn or corrupt final record. Because adding is idempotent,
+// replaying records a snapshot already contains is harmless, so a crash
+// between snapshot and truncation loses nothing.
+//
+// A DurableFilter is safe for concurrent use.
+type DurableFilter struct {
+	mu        sync.Mutex
+	filter    *CacheOptimizedBloomFilter
+	dir       string
+	log       walFile
+	opts      DurableOptions
+	sinceSnap int // records logged since the last snapshot
+
+	// Length of the log up to its last complete record
+	logSize int64
+	// Set when a failed append could not be cut off the log; Adds are refused
+	// until a Snapshot rewrites it
+	logErr error
+}
+
+// OpenDurable opens or creates a durable filter in dir
+func OpenDurable(dir string, opts DurableOptions) (*DurableFilter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	filter, err := loadDurableSnapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		// Persist the empty filter first so the sizing survives a reopen
+		// before the first explicit snapshot
+		if opts.ExpectedElements == 0 || opts.FalsePositiveRate <= 0 || opts.FalsePositiveRate >= 1 {
+			return nil, fmt.Errorf("creating a durable filter in %s requires ExpectedElements and a FalsePositiveRate in (0, 1)", dir)
+		}
+		filter = NewCacheOptimizedBloomFilter(opts.ExpectedElements, opts.FalsePositiveRate)
+		if err := writeDurableSnapshot(dir, filter); err != nil {
+			return nil, err
+		}
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, durableLogFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DurableFilter{filter: filter, dir: dir, log: log, opts: opts}
+	if err := d.replayLog(); err != nil {
+		log.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// loadDurableSnapshot reads the snapshot in dir, returning nil if there is none
+func loadDurableSnapshot(dir string) (*CacheOptimizedBloomFilter, error) {
+	file, err := os.Open(filepath.Join(dir, durableSnapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	filter := &CacheOptimizedBloomFilter{}
+	if _, err := filter.ReadFrom(file); err != nil {
+		return nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+	return filter, nil
+}
+
+// replayLog applies every intact log record and cuts off a torn tail
+func (d *DurableFilter) replayLog() error {
+	data, err := io.ReadAll(d.log)
+	if err != nil {
+		return err
+	}
+
+	valid := 0
+	for ; valid+walRecordSize <= len(data); valid += walRecordSize {
+		h1, h2, ok := decodeWALRecord(data[valid : valid+walRecordSize])
+		if !ok {
+			break
+		}
+		d.filter.addHashPair(h1, h2)
+		d.sinceSnap++
+	}
+
+	// Drop a partial or corrupt final record so new records follow intact ones
+	return d.truncateLog(int64(valid))
+}
+
+// truncateLog cuts the log to size and positions it for the next append
+func (d *DurableFilter) truncateLog(size int64) error {
+	if err := d.log.Truncate(size); err != nil {
+		return err
+	}
+	if _, err := d.log.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	d.logSize = size
+	return nil
+}
+
+// encodeWALRecord writes one log record into buf
+func encodeWALRecord(buf []byte, h1, h2 uint64) {
+	binary.LittleEndian.PutUint64(buf[0:], h1)
+	binary.LittleEndian.PutUint64(buf[8:], h2)
+	binary.LittleEndian.PutUint32(buf[16:], crc32.Checksum(buf[:16], walCRCTable))
+}
+
+// decodeWALRecord parses one log record, reporting false if its checksum is wrong
+func decodeWALRecord(buf []byte) (h1, h2 uint64, ok bool) {
+	if binary.LittleEndian.Uint32(buf[16:]) != crc32.Checksum(buf[:16], walCRCTable) {
+		return 0, 0, false
+	}
+	return binary.LittleEndian.Uint64(buf[0:]), binary.LittleEndian.Uint64(buf[8:]), true
+}
+
+// Add logs an element and then adds it to the filter
+func (d *DurableFilter) Add(data []byte) error {
+	return d.AddBatch([][]byte{data})
+}
+
+// AddString logs a string element and then adds it to the filter
+func (d *DurableFilter) AddString(s string) error {
+	return d.Add([]byte(s))
+}
+
+// AddBatch logs several elements with a single write and then adds them.
+// If the log write fails, none of the elements are added and the partial
+// write is cut off the log, so later records are not lost behind it on replay.
+func (d *DurableFilter) AddBatch(items [][]byte) error {
+	records := make([]byte, len(items)*walRecordSize)
+	pairs := make([][2]uint64, len(items))
+	for i, item := range items {
+		h1, h2 := hashOptimized1(item), hashOptimized2(item)
+		pairs[i] = [2]uint64{h1, h2}
+		encodeWALRecord(records[i*walRecordSize:], h1, h2)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.log == nil {
+		return ErrClosed
+	}
+	if d.logErr != nil {
+		return fmt.Errorf("write-ahead log is damaged, take a snapshot to recover: %w", d.logErr)
+	}
+	if err := d.appendLog(records); err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		d.filter.addHashPair(pair[0], pair[1])
+	}
+
+	d.sinceSnap += len(items)
+	if d.opts.SnapshotInterval > 0 && d.sinceSnap >= d.opts.SnapshotInterval {
+		if err := d.snapshotLocked(); err != nil {
+			return fmt.Errorf("%w: %w", ErrSnapshotFailed, err)
+		}
+	}
+	return nil
+}
+
+// appendLog writes records to the end of the log, syncing if configured. On
+// failure the log is truncated back to its previous length; if that fails
+// too, the log is marked damaged.
+func (d *DurableFilter) appendLog(records []byte) error {
+	_, err := d.log.Write(records)
+	if err == nil && d.opts.SyncWrites {
+		err = d.log.Sync()
+	}
+	if err == nil {
+		d.logSize += int64(len(records))
+		return nil
+	}
+
+	if truncErr := d.truncateLog(d.logSize); truncErr != nil {
+		d.logErr = truncErr
+		return errors.Join(err, truncErr)
+	}
+	return err
+}
+
+// Contains checks membership
+func (d *DurableFilter) Contains(data []byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.filter.Contains(data)
+}
+
+// ContainsString checks if a string element exists
+func (d *DurableFilter) ContainsString(s string) bool {
+	return d.Contains([]byte(s))
+}
+
+// Snapshot writes the whole filter to disk and truncates the log
+func (d *DurableFilter) Snapshot() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.snapshotLocked()
+}
+
+// snapshotLocked writes a snapshot and then truncates the log; d.mu must be held
+func (d *DurableFilter) snapshotLocked() error {
+	if d.log == nil {
+		return ErrClosed
+	}
+	if err := writeDurableSnapshot(d.dir, d.filter); err != nil {
+		return err
+	}
+
+	// The snapshot now holds every logged element, and any damage to the log
+	// is discarded with it
+	if err := d.truncateLog(0); err != nil {
+		return err
+	}
+	d.sinceSnap = 0
+	d.logErr = nil
+	return d.log.Sync()
+}
+
+// writeDurableSnapshot replaces the snapshot in dir atomically: temp file,
+// fsync, rename, then fsync of the directory
+func writeDurableSnapshot(dir string, filter *CacheOptimizedBloomFilter) error {
+	tmp, err := os.CreateTemp(dir, durableSnapshotFile+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := filter.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, durableSnapshotFile)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir fsyncs a directory so a rename inside it is durable
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Windows cannot fsync directories; the rename is still atomic there
+	if err := f.Sync(); err != nil && runtime.GOOS != "windows" {
+		return err
+	}
+	return nil
+}
+
+// Sync flushes the log to stable storage
+func (d *DurableFilter) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.log == nil {
+		return ErrClosed
+	}
+	return d.log.Sync()
+}
+
+// LogSize returns the number of records logged since the last snapshot
+func (d *DurableFilter) LogSize() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sinceSnap
+}
+
+// GetCacheStats returns statistics about the underlying filter
+func (d *DurableFilter) GetCacheStats() CacheStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.filter.GetCacheStats()
+}
+
+// Close syncs and closes the log. It does not take a snapshot; the next
+// OpenDurable replays the log.
+func (d *DurableFilter) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.log == nil {
+		return ErrClosed
+	}
+	err := d.log.Sync()
+	if closeErr := d.log.Close(); err == nil {
+		err = closeErr
+	}
+	d.log = nil
+	return err
+}
@@ -0,0 +1,255 @@
+package bloomfilter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDurableFilterReplay tests that logged elements survive reopening without a snapshot
+func TestDurableFilterReplay(t *testing.T) {
+	dir := t.TempDir()
+	opts := DurableOptions{ExpectedElements: 10000, FalsePositiveRate: 0.01}
+
+	d, err := OpenDurable(dir, opts)
+	if err != nil {
+		t.Fatalf("OpenDurable failed: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		if err := d.AddString(fmt.Sprintf("durable_%d", i)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	// Simulate a crash: the log file is never closed or synced explicitly
+	d.log = nil
+
+	reopened, err := OpenDurable(dir, DurableOptions{})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+
+	if reopened.LogSize() != 500 {
+		t.Errorf("Expected 500 replayed records, got %d", reopened.LogSize())
+	}
+	for i := 0; i < 500; i++ {
+		if !reopened.ContainsString(fmt.Sprintf("durable_%d", i)) {
+			t.Fatalf("Element %d lost after replay", i)
+		}
+	}
+}
+
+// TestDurableFilterSnapshot tests that snapshots persist the filter and truncate the log
+func TestDurableFilterSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	opts := DurableOptions{ExpectedElements: 10000, FalsePositiveRate: 0.01, SnapshotInterval: 100}
+
+	d, err := OpenDurable(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 250; i++ {
+		d.AddString(fmt.Sprintf("snap_%d", i))
+	}
+	if d.LogSize() != 50 {
+		t.Errorf("Expected 50 records after automatic snapshots, got %d", d.LogSize())
+	}
+	if info, _ := os.Stat(filepath.Join(dir, durableLogFile)); info.Size() != 50*walRecordSize {
+		t.Errorf("Expected log of %d bytes, got %d", 50*walRecordSize, info.Size())
+	}
+
+	if err := d.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, durableLogFile)); info.Size() != 0 {
+		t.Errorf("Expected empty log after snapshot, got %d bytes", info.Size())
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed on second Close, got %v", err)
+	}
+
+	// The snapshot is a regular serialized filter
+	data, err := os.ReadFile(filepath.Join(dir, durableSnapshotFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot CacheOptimizedBloomFilter
+	if err := snapshot.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Snapshot is not a valid serialized filter: %v", err)
+	}
+
+	reopened, err := OpenDurable(dir, DurableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for i := 0; i < 250; i++ {
+		if !reopened.ContainsString(fmt.Sprintf("snap_%d", i)) {
+			t.Fatalf("Element %d lost after snapshot", i)
+		}
+	}
+}
+
+// TestDurableFilterTornRecord tests recovery from a partially written or corrupt final record
+func TestDurableFilterTornRecord(t *testing.T) {
+	for name, damage := range map[string]func(path string){
+		"Partial": func(path string) {
+			f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			f.Write([]byte{1, 2, 3, 4, 5, 6, 7})
+			f.Close()
+		},
+		"BadChecksum": func(path string) {
+			data, _ := os.ReadFile(path)
+			data[len(data)-1] ^= 0xFF
+			os.WriteFile(path, data, 0o644)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			d, err := OpenDurable(dir, DurableOptions{ExpectedElements: 1000, FalsePositiveRate: 0.01})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				d.AddString(fmt.Sprintf("torn_%d", i))
+			}
+			d.Close()
+
+			logPath := filepath.Join(dir, durableLogFile)
+			damage(logPath)
+
+			reopened, err := OpenDurable(dir, DurableOptions{})
+			if err != nil {
+				t.Fatalf("Reopen with damaged log failed: %v", err)
+			}
+			for i := 0; i < 9; i++ {
+				if !reopened.ContainsString(fmt.Sprintf("torn_%d", i)) {
+					t.Fatalf("Intact element %d lost", i)
+				}
+			}
+
+			// New records must follow the last intact one
+			reopened.AddString("after_recovery")
+			reopened.Close()
+			info, _ := os.Stat(logPath)
+			if info.Size()%walRecordSize != 0 {
+				t.Errorf("Log not truncated to whole records: %d bytes", info.Size())
+			}
+
+			again, err := OpenDurable(dir, DurableOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer again.Close()
+			if !again.ContainsString("after_recovery") {
+				t.Error("Record written after recovery was lost")
+			}
+		})
+	}
+}
+
+// TestDurableFilterRequiresSizing tests that a new directory needs sizing options
+func TestDurableFilterRequiresSizing(t *testing.T) {
+	if _, err := OpenDurable(t.TempDir(), DurableOptions{}); err == nil {
+		t.Error("Expected error creating a durable filter without sizing")
+	}
+}
+
+// faultyLog wraps the log file to inject failures
+type faultyLog struct {
+	walFile
+	// Bytes of the next write that reach the file before it fails; -1 for none
+	shortWrite   int
+	failTruncate bool
+}
+
+func (f *faultyLog) Write(p []byte) (int, error) {
+	if f.shortWrite < 0 {
+		return f.walFile.Write(p)
+	}
+	n, _ := f.walFile.Write(p[:f.shortWrite])
+	f.shortWrite = -1
+	return n, io.ErrShortWrite
+}
+
+func (f *faultyLog) Truncate(size int64) error {
+	if f.failTruncate {
+		return errors.New("injected truncate failure")
+	}
+	return f.walFile.Truncate(size)
+}
+
+// TestDurableFilterPartialWrite tests that a torn append does not hide the
+// records logged after it
+func TestDurableFilterPartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDurable(dir, DurableOptions{ExpectedElements: 1000, FalsePositiveRate: 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AddString("before")
+
+	faulty := &faultyLog{walFile: d.log, shortWrite: 7}
+	d.log = faulty
+	if err := d.AddString("torn"); err == nil {
+		t.Fatal("Expected the partial write to fail")
+	}
+	if d.ContainsString("torn") {
+		t.Error("Element applied although its record was not logged")
+	}
+	if err := d.AddString("after"); err != nil {
+		t.Fatalf("Add after a recovered partial write failed: %v", err)
+	}
+	d.Close()
+
+	reopened, err := OpenDurable(dir, DurableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.LogSize() != 2 || !reopened.ContainsString("before") || !reopened.ContainsString("after") {
+		t.Errorf("Expected both acknowledged records after replay, got %d records", reopened.LogSize())
+	}
+
+	// When the tear cannot be cut off, Adds are refused until a snapshot
+	faulty = &faultyLog{walFile: reopened.log, shortWrite: 7, failTruncate: true}
+	reopened.log = faulty
+	if err := reopened.AddString("torn"); err == nil {
+		t.Fatal("Expected the partial write to fail")
+	}
+	faulty.shortWrite, faulty.failTruncate = -1, false
+	if err := reopened.AddString("refused"); err == nil {
+		t.Error("Expected Adds to be refused while the log is damaged")
+	}
+	if err := reopened.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.AddString("recovered"); err != nil || !reopened.ContainsString("recovered") {
+		t.Errorf("Add after snapshot failed: %v", err)
+	}
+}
+
+// TestDurableFilterSnapshotFailure tests that a failed automatic snapshot is
+// reported separately from a failed Add
+func TestDurableFilterSnapshotFailure(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDurable(dir, DurableOptions{ExpectedElements: 1000, FalsePositiveRate: 0.01, SnapshotInterval: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	d.dir = filepath.Join(dir, "missing")
+	if err := d.AddString("kept"); !errors.Is(err, ErrSnapshotFailed) {
+		t.Fatalf("Expected ErrSnapshotFailed, got %v", err)
+	}
+	if !d.ContainsString("kept") || d.LogSize() != 1 {
+		t.Error("Element not kept after the snapshot failed")
+	}
+}
@@ -0,0 +1,116 @@
+package bloomfilter
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// MarshalText encodes the filter as base64 of its compressed binary format,
+// implementing encoding.TextMarshaler
+func (bf *CacheOptimizedBloomFilter) MarshalText() ([]byte, error) {
+	data, err := bf.MarshalCompressed()
+	if err != nil {
+		return nil, err
+	}
+	text := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(text, data)
+	return text, nil
+}
+
+// UnmarshalText decodes text produced by MarshalText, implementing encoding.TextUnmarshaler
+func (bf *CacheOptimizedBloomFilter) UnmarshalText(text []byte) error {
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(data, text)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+	}
+	return bf.UnmarshalBinary(data[:n])
+}
+
+// jsonFilter is the JSON representation of a filter
+type jsonFilter struct {
+	Parameters jsonParameters `json:"parameters"`
+	// Base64 of the compressed binary format
+	Data string `json:"data"`
+}
+
+// jsonParameters describes a filter in its JSON representation
+type jsonParameters struct {
+	BitCount       uint64 `json:"bitCount"`
+	HashCount      uint32 `json:"hashCount"`
+	CacheLineCount uint64 `json:"cacheLineCount"`
+}
+
+// MarshalJSON encodes the filter as an object holding its parameters and
+// its base64 binary form, implementing json.Marshaler
+func (bf *CacheOptimizedBloomFilter) MarshalJSON() ([]byte, error) {
+	text, err := bf.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonFilter{
+		Parameters: jsonParameters{
+			BitCount:       bf.bitCount,
+			HashCount:      bf.hashCount,
+			CacheLineCount: bf.cacheLineCount,
+		},
+		Data: string(text),
+	})
+}
+
+// UnmarshalJSON decodes an object produced by MarshalJSON, implementing
+// json.Unmarshaler. The parameters must agree with the encoded filter.
+func (bf *CacheOptimizedBloomFilter) UnmarshalJSON(data []byte) error {
+	var v jsonFilter
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	var filter CacheOptimizedBloomFilter
+	if err := filter.UnmarshalText([]byte(v.Data)); err != nil {
+		return err
+	}
+	p := v.Parameters
+	if p.BitCount != filter.bitCount || p.HashCount != filter.hashCount || p.CacheLineCount != filter.cacheLineCount {
+		return fmt.Errorf("%w: parameters do not match the encoded filter", ErrInvalidFormat)
+	}
+
+	*bf = filter
+	return nil
+}
+
+// GobEncode encodes the filter in its compressed binary format, implementing gob.GobEncoder
+func (bf *CacheOptimizedBloomFilter) GobEncode() ([]byte, error) {
+	return bf.MarshalCompressed()
+}
+
+// GobDecode decodes data produced by GobEncode, implementing gob.GobDecoder
+func (bf *CacheOptimizedBloomFilter) GobDecode(data []byte) error {
+	return bf.UnmarshalBinary(data)
+}
+
+// Value stores the filter as its compressed binary format, for BYTEA or BLOB
+// columns, implementing driver.Valuer. A nil filter is stored as NULL.
+func (bf *CacheOptimizedBloomFilter) Value() (driver.Value, error) {
+	if bf == nil {
+		return nil, nil
+	}
+	return bf.MarshalCompressed()
+}
+
+// Scan loads a filter from a database column, implementing sql.Scanner.
+// Binary columns hold the binary format; text columns the MarshalText form.
+func (bf *CacheOptimizedBloomFilter) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return bf.UnmarshalBinary(v)
+	case string:
+		return bf.UnmarshalText([]byte(v))
+	case nil:
+		return fmt.Errorf("cannot scan NULL into a bloom filter")
+	default:
+		return fmt.Errorf("cannot scan %T into a bloom filter", src)
+	}
+}
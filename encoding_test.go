@@ -0,0 +1,115 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newEncodingTestFilter returns a filter with some elements added
+func newEncodingTestFilter() *CacheOptimizedBloomFilter {
+	bf := NewCacheOptimizedBloomFilter(10000, 0.01)
+	for i := 0; i < 500; i++ {
+		bf.AddString(fmt.Sprintf("encode_%d", i))
+	}
+	return bf
+}
+
+// TestTextRoundTrip tests encoding.TextMarshaler support
+func TestTextRoundTrip(t *testing.T) {
+	bf := newEncodingTestFilter()
+	text, err := bf.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded CacheOptimizedBloomFilter
+	if err := decoded.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	assertSameFilter(t, bf, &decoded)
+
+	if err := decoded.UnmarshalText([]byte("not base64!")); err == nil {
+		t.Error("Expected error for invalid base64")
+	}
+}
+
+// TestJSONRoundTrip tests json.Marshaler support inside a configuration document
+func TestJSONRoundTrip(t *testing.T) {
+	type config struct {
+		Name   string                     `json:"name"`
+		Filter *CacheOptimizedBloomFilter `json:"filter"`
+	}
+
+	bf := newEncodingTestFilter()
+	data, err := json.Marshal(config{Name: "tenant", Filter: bf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), fmt.Sprintf(`"hashCount":%d`, bf.hashCount)) {
+		t.Errorf("Parameters missing from JSON: %s", data)
+	}
+
+	var decoded config
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	assertSameFilter(t, bf, decoded.Filter)
+
+	tampered := strings.Replace(string(data), fmt.Sprintf(`"hashCount":%d`, bf.hashCount), `"hashCount":99`, 1)
+	if err := json.Unmarshal([]byte(tampered), &decoded); err == nil {
+		t.Error("Expected error for parameters that do not match the data")
+	}
+}
+
+// TestGobRoundTrip tests gob.GobEncoder support
+func TestGobRoundTrip(t *testing.T) {
+	bf := newEncodingTestFilter()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(bf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded CacheOptimizedBloomFilter
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	assertSameFilter(t, bf, &decoded)
+}
+
+// TestSQLRoundTrip tests driver.Valuer and sql.Scanner support
+func TestSQLRoundTrip(t *testing.T) {
+	bf := newEncodingTestFilter()
+
+	value, err := bf.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromBytes CacheOptimizedBloomFilter
+	if err := fromBytes.Scan(value); err != nil {
+		t.Fatalf("Scan of []byte failed: %v", err)
+	}
+	assertSameFilter(t, bf, &fromBytes)
+
+	text, _ := bf.MarshalText()
+	var fromText CacheOptimizedBloomFilter
+	if err := fromText.Scan(string(text)); err != nil {
+		t.Fatalf("Scan of string failed: %v", err)
+	}
+	assertSameFilter(t, bf, &fromText)
+
+	if err := fromText.Scan(nil); err == nil {
+		t.Error("Expected error scanning NULL")
+	}
+	if err := fromText.Scan(42); err == nil {
+		t.Error("Expected error scanning an integer")
+	}
+
+	var missing *CacheOptimizedBloomFilter
+	if value, err := missing.Value(); value != nil || err != nil {
+		t.Errorf("Expected NULL for a nil filter, got %v, %v", value, err)
+	}
+}
@@ -0,0 +1,104 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Fold returns a copy of the filter shrunk by factor, which must be a power
+// of two no larger than the cache line count; the cache line count itself
+// must be a power of two (see WithPowerOfTwoSize). The folded filter answers
+// Contains for every element added to the original, at a higher false
+// positive rate.
+//
+// Positions are mapped with a multiply-shift, which for a power-of-two size
+// takes the top bits of the hash. Halving the size therefore drops the lowest
+// position bit: each pair of neighbouring bits is ORed into one, so folding
+// keeps cache line locality and needs no change to Add or Contains.
+func (bf *CacheOptimizedBloomFilter) Fold(factor int) (*CacheOptimizedBloomFilter, error) {
+	if !isPowerOfTwo(bf.cacheLineCount) {
+		return nil, fmt.Errorf("folding requires a power-of-two cache line count, have %d", bf.cacheLineCount)
+	}
+	if factor < 1 || !isPowerOfTwo(uint64(factor)) || uint64(factor) > bf.cacheLineCount {
+		return nil, fmt.Errorf("fold factor must be a power of two between 1 and %d, got %d", bf.cacheLineCount, factor)
+	}
+	return bf.fold(uint64(factor)), nil
+}
+
+// UnionFolded returns a new filter holding the union of a and b at the size
+// of the smaller one, folding the larger. Both must have power-of-two sizes
+// and the same hash count; neither is modified.
+func UnionFolded(a, b *CacheOptimizedBloomFilter) (*CacheOptimizedBloomFilter, error) {
+	if a.cacheLineCount > b.cacheLineCount {
+		a, b = b, a
+	}
+	factor := foldFactor(b, a)
+	if factor == 0 {
+		return nil, fmt.Errorf("bloom filters must have power-of-two sizes and the same hash count to union after folding")
+	}
+
+	result := b.fold(factor)
+	if err := result.Union(a); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// foldFactor returns the factor that folds larger to the size of smaller, or
+// zero if that is not possible. Equal sizes give a factor of one.
+func foldFactor(larger, smaller *CacheOptimizedBloomFilter) uint64 {
+	if larger.hashCount != smaller.hashCount || smaller.cacheLineCount == 0 ||
+		!isPowerOfTwo(larger.cacheLineCount) || !isPowerOfTwo(smaller.cacheLineCount) ||
+		larger.cacheLineCount < smaller.cacheLineCount {
+		return 0
+	}
+	return larger.cacheLineCount / smaller.cacheLineCount
+}
+
+// fold shrinks a power-of-two filter by a power-of-two factor
+func (bf *CacheOptimizedBloomFilter) fold(factor uint64) *CacheOptimizedBloomFilter {
+	cacheLineCount := bf.cacheLineCount / factor
+	result := newFilterWithMemory(cacheLineCount, bf.hashCount, allocateCacheLines(cacheLineCount, filterOptions{}))
+	if factor == 1 {
+		copy(result.cacheLines, bf.cacheLines)
+		return result
+	}
+
+	// The first halving reads the source; later ones run in place, which is
+	// safe because output word i only depends on input words 2i and 2i+1
+	words := make([]uint64, bf.cacheLineCount*WordsPerCacheLine/2)
+	for i := range words {
+		lo := bf.cacheLines[(2*i)/WordsPerCacheLine].words[(2*i)%WordsPerCacheLine]
+		hi := bf.cacheLines[(2*i+1)/WordsPerCacheLine].words[(2*i+1)%WordsPerCacheLine]
+		words[i] = foldWord(lo) | foldWord(hi)<<32
+	}
+	for f := factor / 2; f > 1; f /= 2 {
+		half := words[:len(words)/2]
+		for i := range half {
+			half[i] = foldWord(words[2*i]) | foldWord(words[2*i+1])<<32
+		}
+		words = half
+	}
+
+	for i := range result.cacheLines {
+		copy(result.cacheLines[i].words[:], words[i*WordsPerCacheLine:])
+	}
+	return result
+}
+
+// foldWord ORs each pair of neighbouring bits of x and packs the 32 results
+// into the low half
+func foldWord(x uint64) uint64 {
+	x = (x | x>>1) & 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x>>4) & 0x00FF00FF00FF00FF
+	x = (x | x>>8) & 0x0000FFFF0000FFFF
+	x = (x | x>>16) & 0x00000000FFFFFFFF
+	return x
+}
+
+// isPowerOfTwo reports whether n is a nonzero power of two
+func isPowerOfTwo(n uint64) bool {
+	return bits.OnesCount64(n) == 1
+}
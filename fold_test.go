@@ -0,0 +1,85 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"testing"
+)
+
+// newTestFilter creates a heap filter with explicit parameters
+func newTestFilter(cacheLineCount uint64, hashCount uint32) *CacheOptimizedBloomFilter {
+	return newFilterWithMemory(cacheLineCount, hashCount, allocateCacheLines(cacheLineCount, filterOptions{}))
+}
+
+// TestFoldMatchesSmallerFilter tests that folding equals building the smaller filter directly
+func TestFoldMatchesSmallerFilter(t *testing.T) {
+	big := NewCacheOptimizedBloomFilter(50000, 0.001, WithPowerOfTwoSize())
+	if !isPowerOfTwo(big.cacheLineCount) {
+		t.Fatalf("WithPowerOfTwoSize gave %d cache lines", big.cacheLineCount)
+	}
+	for i := 0; i < 2000; i++ {
+		big.AddString(fmt.Sprintf("fold_%d", i))
+	}
+
+	for factor := 1; uint64(factor) <= big.cacheLineCount; factor *= 2 {
+		folded, err := big.Fold(factor)
+		if err != nil {
+			t.Fatalf("Fold(%d) failed: %v", factor, err)
+		}
+
+		direct := newTestFilter(big.cacheLineCount/uint64(factor), big.hashCount)
+		for i := 0; i < 2000; i++ {
+			direct.AddString(fmt.Sprintf("fold_%d", i))
+		}
+		assertSameFilter(t, direct, folded)
+	}
+}
+
+// TestFoldErrors tests rejection of unsupported sizes and factors
+func TestFoldErrors(t *testing.T) {
+	odd := newTestFilter(3, 4)
+	if _, err := odd.Fold(2); err == nil {
+		t.Error("Expected error folding a non power-of-two filter")
+	}
+
+	bf := newTestFilter(8, 4)
+	for _, factor := range []int{0, 3, 16} {
+		if _, err := bf.Fold(factor); err == nil {
+			t.Errorf("Expected error for fold factor %d", factor)
+		}
+	}
+}
+
+// TestUnionDifferentSizes tests union through folding the larger filter
+func TestUnionDifferentSizes(t *testing.T) {
+	large := newTestFilter(64, 5)
+	small := newTestFilter(16, 5)
+	for i := 0; i < 300; i++ {
+		large.AddString(fmt.Sprintf("large_%d", i))
+		small.AddString(fmt.Sprintf("small_%d", i))
+	}
+
+	combined, err := UnionFolded(small, large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if combined.cacheLineCount != 16 {
+		t.Errorf("Expected the union at the smaller size, got %d cache lines", combined.cacheLineCount)
+	}
+
+	if err := small.Union(large); err != nil {
+		t.Fatalf("Union with a larger power-of-two filter failed: %v", err)
+	}
+	assertSameFilter(t, combined, small)
+	for i := 0; i < 300; i++ {
+		if !small.ContainsString(fmt.Sprintf("large_%d", i)) || !small.ContainsString(fmt.Sprintf("small_%d", i)) {
+			t.Fatalf("Element %d missing after union", i)
+		}
+	}
+
+	if err := large.Union(newTestFilter(16, 5)); err == nil {
+		t.Error("Expected error unioning a smaller filter into a larger one")
+	}
+	if _, err := UnionFolded(large, newTestFilter(16, 4)); err == nil {
+		t.Error("Expected error for different hash counts")
+	}
+}
@@ -0,0 +1,136 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+)
+
+// fuseTestKeys returns count distinct keys
+func fuseTestKeys(prefix string, count int) [][]byte {
+	keys := make([][]byte, count)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%s_%d", prefix, i))
+	}
+	return keys
+}
+
+// TestBinaryFuseFilter tests membership, false positive rate and size for both fingerprint widths
+func TestBinaryFuseFilter(t *testing.T) {
+	for _, width := range []int{8, 16} {
+		for _, count := range []int{0, 1, 2, 10, 1000, 100000} {
+			t.Run(fmt.Sprintf("Bits%d/Keys%d", width, count), func(t *testing.T) {
+				keys := fuseTestKeys("fuse", count)
+				f, err := BuildBinaryFuseFilter(keys, width)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f.Len() != uint64(count) || f.FingerprintBits() != width {
+					t.Errorf("Len %d, FingerprintBits %d", f.Len(), f.FingerprintBits())
+				}
+				for _, key := range keys {
+					if !f.Contains(key) {
+						t.Fatalf("False negative for %q", key)
+					}
+				}
+
+				if count < 1000 {
+					return
+				}
+				const trials = 200000
+				falsePositives := 0
+				for i := 0; i < trials; i++ {
+					if f.ContainsString(fmt.Sprintf("absent_%d", i)) {
+						falsePositives++
+					}
+				}
+				if rate := float64(falsePositives) / trials; rate > 2*f.EstimatedFPP()+0.0005 {
+					t.Errorf("False positive rate %.5f, expected about %.5f", rate, f.EstimatedFPP())
+				}
+				if count == 100000 && f.BitsPerKey() > float64(width)*1.2 {
+					t.Errorf("%.2f bits per key for %d-bit fingerprints", f.BitsPerKey(), width)
+				}
+			})
+		}
+	}
+}
+
+// TestBinaryFuseDuplicates tests that duplicate keys do not break construction
+func TestBinaryFuseDuplicates(t *testing.T) {
+	keys := append(fuseTestKeys("dup", 500), fuseTestKeys("dup", 500)...)
+	f, err := BuildBinaryFuseFilter(keys, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Len() != 500 {
+		t.Errorf("Expected 500 distinct keys, got %d", f.Len())
+	}
+	for _, key := range keys {
+		if !f.Contains(key) {
+			t.Fatalf("False negative for %q", key)
+		}
+	}
+}
+
+// TestBinaryFuseSerialization tests the binary format round trip
+func TestBinaryFuseSerialization(t *testing.T) {
+	keys := fuseTestKeys("serialize", 5000)
+	f, _ := BuildBinaryFuseFilter(keys, 16)
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded BinaryFuseFilter
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if !decoded.Contains(key) {
+			t.Fatalf("False negative after round trip for %q", key)
+		}
+	}
+
+	// Not a bloom filter, and truncated data is rejected
+	var bf CacheOptimizedBloomFilter
+	if err := bf.UnmarshalBinary(data); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat loading a fuse filter as a bloom filter, got %v", err)
+	}
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+	bloom, _ := NewCacheOptimizedBloomFilter(100, 0.01).MarshalBinary()
+	if err := decoded.UnmarshalBinary(bloom); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat loading a bloom filter as a fuse filter, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	f.WriteTo(&buf)
+	buf.WriteString("tail")
+	if _, err := decoded.ReadFrom(&buf); err != nil || buf.String() != "tail" {
+		t.Errorf("ReadFrom did not stop at the end of the filter: %v", err)
+	}
+
+	// A header claiming an 8 GiB array fails before allocating it
+	const segmentLength, segmentCount = fuseMaxSegmentLength, 1<<32/fuseMaxSegmentLength - 2
+	oversized := slices.Clone(data[:headerSize+fusePayloadHeaderSize])
+	binary.LittleEndian.PutUint64(oversized[40:], fusePayloadHeaderSize+(segmentCount+2)*segmentLength*2)
+	binary.LittleEndian.PutUint32(oversized[headerSize+16:], segmentLength)
+	binary.LittleEndian.PutUint32(oversized[headerSize+20:], segmentCount)
+	for _, r := range []io.Reader{bytes.NewReader(oversized), opaqueReader{bytes.NewReader(oversized)}} {
+		if _, err := decoded.ReadFrom(r); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected io.ErrUnexpectedEOF for an oversized header, got %v", err)
+		}
+	}
+}
+
+// TestBinaryFuseInvalidWidth tests fingerprint width validation
+func TestBinaryFuseInvalidWidth(t *testing.T) {
+	if _, err := BuildBinaryFuseFilter(fuseTestKeys("w", 10), 12); err == nil {
+		t.Error("Expected error for 12-bit fingerprints")
+	}
+}
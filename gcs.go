@@ -0,0 +1,299 @@
+package bloomfilter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"slices"
+)
+
+// BIP158 basic filter parameters
+const (
+	// BIP158P is the Golomb-Rice parameter of BIP158 basic filters
+	BIP158P = 19
+	// BIP158M is the inverse false positive rate of BIP158 basic filters
+	BIP158M = 784931
+)
+
+// GCSFilter is a Golomb-coded set as specified by BIP158: each item is
+// hashed with SipHash-2-4 under a 128-bit key into [0, N*M), the sorted
+// values are delta encoded with Golomb-Rice parameter P, and a query matches
+// with probability about 1/M. Filters built with BIP158P and BIP158M, keyed
+// by a block hash, are byte-for-byte compatible with BIP158 basic filters.
+//
+// The encoding is kept as is and decoded on every query, so a filter costs
+// its serialized size in memory.
+type GCSFilter struct {
+	key [16]byte
+	p   uint8
+	m   uint64
+	n   uint64
+	// Golomb-Rice coded deltas, without the item count
+	encoded []byte
+}
+
+// BIP158Key returns the SipHash key BIP158 derives from a block hash: its
+// first 16 bytes in internal (little-endian) byte order
+func BIP158Key(blockHash [32]byte) [16]byte {
+	return [16]byte(blockHash[:16])
+}
+
+// BuildGCSFilter encodes a set of items. Duplicate items are counted once,
+// as BIP158 requires.
+func BuildGCSFilter(key [16]byte, p uint8, m uint64, items [][]byte) (*GCSFilter, error) {
+	if err := validateGCSParameters(p, m); err != nil {
+		return nil, err
+	}
+
+	unique := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		unique[string(item)] = struct{}{}
+	}
+
+	f := &GCSFilter{key: key, p: p, m: m, n: uint64(len(unique))}
+	if bits.Len64(f.n)+bits.Len64(m) > 64 {
+		return nil, fmt.Errorf("GCS range of %d items with modulus %d overflows 64 bits", f.n, m)
+	}
+	values := make([]uint64, 0, len(unique))
+	for item := range unique {
+		values = append(values, f.hashToRange([]byte(item)))
+	}
+	slices.Sort(values)
+
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	rw := riceWriter{w: bw}
+	var previous uint64
+	for _, v := range values {
+		rw.writeRice(v-previous, uint(p))
+		previous = v
+	}
+	rw.flush()
+	bw.Flush()
+	f.encoded = buf.Bytes()
+	return f, nil
+}
+
+// ParseGCSFilter wraps a serialized filter: a CompactSize item count
+// followed by the Golomb-Rice stream, as BIP158 filters are transmitted.
+// The stream is validated lazily, by queries.
+func ParseGCSFilter(key [16]byte, p uint8, m uint64, data []byte) (*GCSFilter, error) {
+	if err := validateGCSParameters(p, m); err != nil {
+		return nil, err
+	}
+	n, size, err := readCompactSize(data)
+	if err != nil {
+		return nil, err
+	}
+	if bits.Len64(n)+bits.Len64(m) > 64 {
+		return nil, fmt.Errorf("%w: GCS item count %d too large", ErrInvalidFormat, n)
+	}
+	return &GCSFilter{key: key, p: p, m: m, n: n, encoded: slices.Clone(data[size:])}, nil
+}
+
+// validateGCSParameters checks P and M
+func validateGCSParameters(p uint8, m uint64) error {
+	if p > 32 {
+		return fmt.Errorf("GCS parameter P must be at most 32, got %d", p)
+	}
+	if m == 0 {
+		return fmt.Errorf("GCS modulus M must be positive")
+	}
+	return nil
+}
+
+// hashToRange maps an item uniformly onto [0, N*M) by multiplying its
+// SipHash value and keeping the high 64 bits
+func (f *GCSFilter) hashToRange(item []byte) uint64 {
+	hash := sipHash24(binary.LittleEndian.Uint64(f.key[0:]), binary.LittleEndian.Uint64(f.key[8:]), item)
+	hi, _ := bits.Mul64(hash, f.n*f.m)
+	return hi
+}
+
+// Bytes returns the BIP158 serialization: the CompactSize item count
+// followed by the Golomb-Rice stream
+func (f *GCSFilter) Bytes() []byte {
+	return append(appendCompactSize(nil, f.n), f.encoded...)
+}
+
+// N returns the number of items in the set
+func (f *GCSFilter) N() uint64 {
+	return f.n
+}
+
+// P returns the Golomb-Rice parameter
+func (f *GCSFilter) P() uint8 {
+	return f.p
+}
+
+// M returns the inverse false positive rate
+func (f *GCSFilter) M() uint64 {
+	return f.m
+}
+
+// Match reports whether item may be in the set. It returns an error only if
+// the encoded stream is corrupt.
+func (f *GCSFilter) Match(item []byte) (bool, error) {
+	if f.n == 0 {
+		return false, nil
+	}
+	target := f.hashToRange(item)
+	found := false
+	err := f.decode(func(v uint64) bool {
+		found = v == target
+		return v < target
+	})
+	return found, err
+}
+
+// MatchAny reports whether any of items may be in the set, decoding the
+// filter once for the whole batch
+func (f *GCSFilter) MatchAny(items [][]byte) (bool, error) {
+	if f.n == 0 || len(items) == 0 {
+		return false, nil
+	}
+	targets := make([]uint64, len(items))
+	for i, item := range items {
+		targets[i] = f.hashToRange(item)
+	}
+	slices.Sort(targets)
+
+	// Walk the two sorted lists together
+	found := false
+	i := 0
+	err := f.decode(func(v uint64) bool {
+		for i < len(targets) && targets[i] < v {
+			i++
+		}
+		if i == len(targets) {
+			return false
+		}
+		found = targets[i] == v
+		return !found
+	})
+	return found, err
+}
+
+// decode calls fn with each set value in ascending order until it returns false
+func (f *GCSFilter) decode(fn func(v uint64) bool) error {
+	rr := riceReader{r: bufio.NewReaderSize(bytes.NewReader(f.encoded), 16)}
+	k := uint(f.p)
+	limit := f.n * f.m
+	var value uint64
+	for i := uint64(0); i < f.n; i++ {
+		q := rr.readUnary(limit >> k)
+		delta := q<<k | rr.readBits(k)
+		if rr.err != nil {
+			return rr.err
+		}
+		if delta >= limit-value {
+			return fmt.Errorf("%w: GCS value beyond N*M", ErrInvalidFormat)
+		}
+		value += delta
+		if !fn(value) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// readCompactSize decodes a Bitcoin CompactSize integer, returning it and
+// its encoded length
+func readCompactSize(data []byte) (uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, fmt.Errorf("%w: missing GCS item count", ErrInvalidFormat)
+	}
+	size := 1
+	switch data[0] {
+	case 0xFD:
+		size = 3
+	case 0xFE:
+		size = 5
+	case 0xFF:
+		size = 9
+	}
+	if len(data) < size {
+		return 0, 0, fmt.Errorf("%w: truncated GCS item count", ErrInvalidFormat)
+	}
+
+	var n, minimum uint64
+	switch size {
+	case 1:
+		return uint64(data[0]), 1, nil
+	case 3:
+		n, minimum = uint64(binary.LittleEndian.Uint16(data[1:])), 0xFD
+	case 5:
+		n, minimum = uint64(binary.LittleEndian.Uint32(data[1:])), 1<<16
+	default:
+		n, minimum = binary.LittleEndian.Uint64(data[1:]), 1<<32
+	}
+	if n < minimum {
+		return 0, 0, fmt.Errorf("%w: non-canonical GCS item count", ErrInvalidFormat)
+	}
+	return n, size, nil
+}
+
+// appendCompactSize appends the Bitcoin CompactSize encoding of n
+func appendCompactSize(dst []byte, n uint64) []byte {
+	switch {
+	case n < 0xFD:
+		return append(dst, byte(n))
+	case n <= 0xFFFF:
+		return binary.LittleEndian.AppendUint16(append(dst, 0xFD), uint16(n))
+	case n <= 0xFFFFFFFF:
+		return binary.LittleEndian.AppendUint32(append(dst, 0xFE), uint32(n))
+	}
+	return binary.LittleEndian.AppendUint64(append(dst, 0xFF), n)
+}
+
+// sipHash24 is SipHash-2-4 with the key k0, k1 (each read little-endian)
+func sipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	length := len(data)
+	for ; len(data) >= 8; data = data[8:] {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+
+	// The final block holds the remaining bytes and the length in its top byte
+	var last [8]byte
+	copy(last[:], data)
+	m := binary.LittleEndian.Uint64(last[:]) | uint64(length)<<56
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,207 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// TestSipHash24 tests against the reference implementation's vectors for the
+// key 00..0f and messages 00, 01, .. of increasing length
+func TestSipHash24(t *testing.T) {
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	message := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}
+
+	if got := sipHash24(k0, k1, nil); got != 0x726fdb47dd0e0e31 {
+		t.Errorf("Empty message: got %#x", got)
+	}
+	if got := sipHash24(k0, k1, message); got != 0xa129ca6149be45e5 {
+		t.Errorf("15-byte message: got %#x", got)
+	}
+}
+
+// TestGCSFilterBIP158Genesis tests the BIP158 basic filter of the testnet
+// genesis block, whose only element is the coinbase output script
+func TestGCSFilterBIP158Genesis(t *testing.T) {
+	blockHash, _ := hex.DecodeString("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943")
+	// Block hashes are displayed byte-reversed
+	slices.Reverse(blockHash)
+	script, _ := hex.DecodeString("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+	key := BIP158Key([32]byte(blockHash))
+
+	f, err := BuildGCSFilter(key, BIP158P, BIP158M, [][]byte{script, script})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(f.Bytes()); got != "019dfca8" {
+		t.Errorf("Expected filter 019dfca8, got %s", got)
+	}
+
+	parsed, err := ParseGCSFilter(key, BIP158P, BIP158M, f.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := parsed.Match(script); !ok || err != nil {
+		t.Errorf("Genesis script not matched: %v", err)
+	}
+}
+
+// TestGCSFilterMultiElement tests filters of several P2PKH scripts under the
+// testnet genesis key. The expected bytes were produced by the independent
+// BIP158 implementation in github.com/btcsuite/btcd/btcutil/gcs (v1.1.6,
+// BuildGCSFilter with P=19, M=784931, serialized with NBytes); script i pays
+// to the 20-byte hash whose byte j is i*31+j.
+func TestGCSFilterMultiElement(t *testing.T) {
+	blockHash, _ := hex.DecodeString("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943")
+	slices.Reverse(blockHash)
+	key := BIP158Key([32]byte(blockHash))
+
+	tests := []struct {
+		count  int
+		filter string
+	}{
+		{2, "029ba0023e4380"},
+		{3, "03c4b801aeb2884600"},
+		{10, "0aaedddc907b87eb5b2b3e0a3bd20d0cb699a16dca0195debcee04c0"},
+		{100, "643fab9f72b49cd36f32ed9bc242a81ccec4d24f0d2461bf4a5ab206474e40a3c1b2442baffc5918f30d3b309465506dd86f983096357ed71a3a261b965abb13679d84b953e6cc0691f0c92bf9275603f3b17fef68efd1e9f247e87394eda5ac5e9fdf943122254c80f34cba9781bb0c9ce969b3e24751a383bc0b518f7a53352e7be7741e77bd2039bf3bc6ef7eec8611fb29a84902e5275eb1c1c8c4471964b156294315fbfe32d1f910003f82e75ca7b286a41166c7f40f4654a63af9795c285ed48515c6057965964320088eb2a97c240ce61b52a8b0338996fde56f71cb2c05d8a05eaa23f9ff886280e1aa6aa75249c7f847f796ac1683ff247702e922836a64b59569da6a"},
+	}
+
+	for _, tt := range tests {
+		scripts := make([][]byte, tt.count)
+		for i := range scripts {
+			script := []byte{0x76, 0xa9, 0x14}
+			for j := 0; j < 20; j++ {
+				script = append(script, byte(i*31+j))
+			}
+			scripts[i] = append(script, 0x88, 0xac)
+		}
+
+		f, err := BuildGCSFilter(key, BIP158P, BIP158M, scripts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(f.Bytes()); got != tt.filter {
+			t.Errorf("%d scripts: expected filter %s, got %s", tt.count, tt.filter, got)
+		}
+
+		data, _ := hex.DecodeString(tt.filter)
+		parsed, err := ParseGCSFilter(key, BIP158P, BIP158M, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.N() != uint64(tt.count) {
+			t.Errorf("%d scripts: parsed filter holds %d", tt.count, parsed.N())
+		}
+		for i, script := range scripts {
+			if ok, err := parsed.Match(script); !ok || err != nil {
+				t.Errorf("%d scripts: script %d not matched: %v", tt.count, i, err)
+			}
+		}
+		if ok, err := parsed.MatchAny(scripts[len(scripts)-1:]); !ok || err != nil {
+			t.Errorf("%d scripts: MatchAny missed the last script: %v", tt.count, err)
+		}
+	}
+}
+
+// TestGCSFilterMatch tests Match and MatchAny against members and non-members
+func TestGCSFilterMatch(t *testing.T) {
+	key := [16]byte{1, 2, 3}
+	items := fuseTestKeys("gcs", 5000)
+	f, err := BuildGCSFilter(key, 10, 1024, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.N() != 5000 {
+		t.Errorf("Expected N 5000, got %d", f.N())
+	}
+
+	parsed, err := ParseGCSFilter(key, 10, 1024, f.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if ok, err := parsed.Match(item); !ok || err != nil {
+			t.Fatalf("False negative for %q: %v", item, err)
+		}
+	}
+
+	falsePositives := 0
+	absent := fuseTestKeys("absent", 10000)
+	for _, item := range absent {
+		if ok, _ := parsed.Match(item); ok {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / float64(len(absent)); rate > 2.0/1024 {
+		t.Errorf("False positive rate %.5f, expected about %.5f", rate, 1.0/1024)
+	}
+
+	batch := append(slices.Clone(absent[:20]), items[4321])
+	if ok, err := parsed.MatchAny(batch); !ok || err != nil {
+		t.Errorf("MatchAny missed a member: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		query := absent[i*20 : i*20+20]
+		matched := false
+		for _, item := range query {
+			ok, _ := parsed.Match(item)
+			matched = matched || ok
+		}
+		if ok, _ := parsed.MatchAny(query); ok != matched {
+			t.Fatalf("MatchAny %v, Match %v for batch %d", ok, matched, i)
+		}
+	}
+}
+
+// TestGCSFilterEmpty tests a filter with no items
+func TestGCSFilterEmpty(t *testing.T) {
+	f, err := BuildGCSFilter([16]byte{}, BIP158P, BIP158M, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Bytes(), []byte{0}) {
+		t.Errorf("Expected a single zero byte, got %x", f.Bytes())
+	}
+	if ok, _ := f.MatchAny([][]byte{[]byte("x")}); ok {
+		t.Error("Empty filter matched")
+	}
+}
+
+// TestGCSFilterCorrupt tests that malformed streams are reported
+func TestGCSFilterCorrupt(t *testing.T) {
+	key := [16]byte{9}
+	f, _ := BuildGCSFilter(key, BIP158P, BIP158M, fuseTestKeys("corrupt", 100))
+	data := f.Bytes()
+
+	truncated, err := ParseGCSFilter(key, BIP158P, BIP158M, data[:len(data)/2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Items in the missing half can only be reached by decoding past the end
+	if _, err := truncated.MatchAny(fuseTestKeys("absent", 100)); err == nil {
+		t.Error("Expected error decoding a truncated stream")
+	}
+	if _, err := ParseGCSFilter(key, BIP158P, BIP158M, nil); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat for empty data, got %v", err)
+	}
+	if _, err := ParseGCSFilter(key, BIP158P, BIP158M, []byte{0xFD, 0x10, 0x00}); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat for a non-canonical count, got %v", err)
+	}
+}
+
+// TestCompactSize tests the CompactSize encoding boundaries
+func TestCompactSize(t *testing.T) {
+	for _, n := range []uint64{0, 0xFC, 0xFD, 0xFFFF, 0x10000, 0xFFFFFFFF, 0x100000000} {
+		encoded := appendCompactSize(nil, n)
+		got, size, err := readCompactSize(encoded)
+		if err != nil || got != n || size != len(encoded) {
+			t.Errorf("%d: decoded %d (%d of %d bytes), %v", n, got, size, len(encoded), err)
+		}
+	}
+	if got := fmt.Sprintf("%x", appendCompactSize(nil, 0xFD)); got != "fdfd00" {
+		t.Errorf("Expected fdfd00, got %s", got)
+	}
+}
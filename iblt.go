@@ -0,0 +1,398 @@
+package bloomfilter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// Invertible bloom lookup table parameters
+const (
+	// Cells each key is added to, one in each subtable
+	ibltHashCount = 4
+	// Largest key size accepted when reading a table
+	ibltMaxKeySize = 1 << 16
+	// Bytes of IBLT parameters at the start of the payload
+	ibltPayloadHeaderSize = 16
+	// Seed of the checksum that identifies cells holding a single key
+	ibltChecksumSeed = 0x5851F42D4C957F2D
+)
+
+// ErrDecodeFailed is returned when an IBLT holds too many keys to be decoded
+var ErrDecodeFailed = errors.New("IBLT could not be fully decoded")
+
+// IBLT is an invertible bloom lookup table (Goodrich and Mitzenmacher, 2011)
+// for set reconciliation. Each cell holds a count, the XOR of the keys added
+// to it and the XOR of their checksums; a key is added to one cell in each of
+// four subtables. Subtracting the table of another replica cancels the keys
+// both hold, and decoding what remains recovers the symmetric difference, so
+// only a table sized for the difference needs to be exchanged.
+//
+// Keys have a fixed size in bytes. A table holds a set: inserting a key that
+// is already present makes it undecodable until one copy is deleted. Both
+// replicas must create their tables with the same cell count and key size.
+type IBLT struct {
+	counts    []int64
+	hashSums  []uint64
+	keySums   []byte
+	keySize   int
+	cellCount uint64
+}
+
+// NewIBLT creates a table of at least cells cells for keys of keySize bytes
+func NewIBLT(cells uint64, keySize int) (*IBLT, error) {
+	if keySize < 1 || keySize > ibltMaxKeySize {
+		return nil, fmt.Errorf("IBLT key size must be between 1 and %d bytes, got %d", ibltMaxKeySize, keySize)
+	}
+	cells = max(ibltHashCount, (cells+ibltHashCount-1)/ibltHashCount*ibltHashCount)
+	return newIBLT(cells, keySize), nil
+}
+
+// NewIBLTForDifference creates a table that decodes a symmetric difference
+// of up to difference keys with high probability. Large differences need
+// about 1.3 cells per key to decode; small ones need relatively more, mostly
+// so that no two keys share all of their cells.
+func NewIBLTForDifference(difference uint64, keySize int) (*IBLT, error) {
+	return NewIBLT(ibltCellsForDifference(difference), keySize)
+}
+
+// ibltCellsForDifference returns the cell count NewIBLTForDifference uses
+func ibltCellsForDifference(difference uint64) uint64 {
+	d := float64(difference)
+	return uint64(math.Ceil(1.4*d+8*math.Sqrt(d))) + 40
+}
+
+// newIBLT allocates a table whose cell count is a multiple of ibltHashCount
+func newIBLT(cells uint64, keySize int) *IBLT {
+	return &IBLT{
+		counts:    make([]int64, cells),
+		hashSums:  make([]uint64, cells),
+		keySums:   make([]byte, cells*uint64(keySize)),
+		keySize:   keySize,
+		cellCount: cells,
+	}
+}
+
+// checksum returns the hash identifying a key in hashSum
+func (t *IBLT) checksum(key []byte) uint64 {
+	return fuseMix(hashOptimized2(key), ibltChecksumSeed)
+}
+
+// cell returns the key's cell in a subtable
+func (t *IBLT) cell(key []byte, subtable int) uint64 {
+	salt := uint64(subtable+1) * 0x9E3779B97F4A7C15
+	size := t.cellCount / ibltHashCount
+	return uint64(subtable)*size + reduceRange(fuseMix(hashOptimized1(key), salt), size)
+}
+
+// keySum returns the key XOR of a cell
+func (t *IBLT) keySum(i uint64) []byte {
+	return t.keySums[i*uint64(t.keySize) : (i+1)*uint64(t.keySize)]
+}
+
+// update adds a key to its cells count times, negative to remove it
+func (t *IBLT) update(key []byte, count int64) {
+	check := t.checksum(key)
+	for s := 0; s < ibltHashCount; s++ {
+		i := t.cell(key, s)
+		t.counts[i] += count
+		t.hashSums[i] ^= check
+		sum := t.keySum(i)
+		subtle.XORBytes(sum, sum, key)
+	}
+}
+
+// checkKey validates the size of a key
+func (t *IBLT) checkKey(key []byte) error {
+	if len(key) != t.keySize {
+		return fmt.Errorf("IBLT key must be %d bytes, got %d", t.keySize, len(key))
+	}
+	return nil
+}
+
+// Insert adds a key to the table
+func (t *IBLT) Insert(key []byte) error {
+	if err := t.checkKey(key); err != nil {
+		return err
+	}
+	t.update(key, 1)
+	return nil
+}
+
+// Delete removes a key from the table. Deleting a key that was never
+// inserted is allowed and records it with a negative count, which Decode
+// reports as deleted.
+func (t *IBLT) Delete(key []byte) error {
+	if err := t.checkKey(key); err != nil {
+		return err
+	}
+	t.update(key, -1)
+	return nil
+}
+
+// InsertString adds a string key
+func (t *IBLT) InsertString(s string) error {
+	return t.Insert(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// DeleteString removes a string key
+func (t *IBLT) DeleteString(s string) error {
+	return t.Delete(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// Subtract removes every key of other from the table, leaving the keys only
+// this table holds with positive counts and those only other holds with
+// negative counts. Both tables must have the same cell count and key size.
+func (t *IBLT) Subtract(other *IBLT) error {
+	if t.cellCount != other.cellCount || t.keySize != other.keySize {
+		return fmt.Errorf("IBLTs must have same cell count and key size to subtract")
+	}
+	for i := range t.counts {
+		t.counts[i] -= other.counts[i]
+		t.hashSums[i] ^= other.hashSums[i]
+	}
+	subtle.XORBytes(t.keySums, t.keySums, other.keySums)
+	return nil
+}
+
+// pure reports whether a cell holds exactly one key, inserted or deleted
+func (t *IBLT) pure(i uint64) bool {
+	if t.counts[i] != 1 && t.counts[i] != -1 {
+		return false
+	}
+	key := t.keySum(i)
+	// The key must also hash to this cell, which rules out most checksum
+	// collisions between mixtures of keys
+	return t.hashSums[i] == t.checksum(key) && t.cell(key, int(i/(t.cellCount/ibltHashCount))) == i
+}
+
+// Decode recovers the keys of the table by repeatedly removing keys from
+// cells that hold only one, emptying the table. Keys with a positive count
+// are returned as inserted and those with a negative count, such as the keys
+// of the other table after Subtract, as deleted. If the table holds too many
+// keys for its size, Decode returns the keys it did recover together with
+// ErrDecodeFailed, and the rest remain in the table.
+func (t *IBLT) Decode() (inserted, deleted [][]byte, err error) {
+	var queue []uint64
+	for i := uint64(0); i < t.cellCount; i++ {
+		if t.pure(i) {
+			queue = append(queue, i)
+		}
+	}
+
+	for len(queue) > 0 {
+		i := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		// Earlier removals may have changed the cell since it was queued
+		if !t.pure(i) {
+			continue
+		}
+		count := t.counts[i]
+		key := bytes.Clone(t.keySum(i))
+		if count > 0 {
+			inserted = append(inserted, key)
+		} else {
+			deleted = append(deleted, key)
+		}
+		t.update(key, -count)
+		for s := 0; s < ibltHashCount; s++ {
+			if j := t.cell(key, s); t.pure(j) {
+				queue = append(queue, j)
+			}
+		}
+	}
+
+	if !t.IsEmpty() {
+		return inserted, deleted, ErrDecodeFailed
+	}
+	return inserted, deleted, nil
+}
+
+// ListEntries decodes a copy of the table, leaving it unchanged
+func (t *IBLT) ListEntries() (inserted, deleted [][]byte, err error) {
+	return t.Clone().Decode()
+}
+
+// IsEmpty reports whether every cell is empty, as after subtracting a table
+// of the same set
+func (t *IBLT) IsEmpty() bool {
+	for i := range t.counts {
+		if t.counts[i] != 0 || t.hashSums[i] != 0 {
+			return false
+		}
+	}
+	for _, b := range t.keySums {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns an independent copy of the table
+func (t *IBLT) Clone() *IBLT {
+	c := newIBLT(t.cellCount, t.keySize)
+	copy(c.counts, t.counts)
+	copy(c.hashSums, t.hashSums)
+	copy(c.keySums, t.keySums)
+	return c
+}
+
+// Cells returns the number of cells
+func (t *IBLT) Cells() uint64 {
+	return t.cellCount
+}
+
+// KeySize returns the size of every key in bytes
+func (t *IBLT) KeySize() int {
+	return t.keySize
+}
+
+// MemoryUsage returns the bytes allocated for the cells
+func (t *IBLT) MemoryUsage() uint64 {
+	return t.cellCount * (16 + uint64(t.keySize))
+}
+
+// Clear empties every cell
+func (t *IBLT) Clear() {
+	clear(t.counts)
+	clear(t.hashSums)
+	clear(t.keySums)
+}
+
+/*
+IBLT payload
+
+After the standard header (filter kind 4), a 16-byte parameter block holds
+the cell count as a little-endian uint64, the key size as a uint32 and the
+hash count as one byte, followed by zero padding. The cells follow in order,
+each as its count (little-endian int64), its checksum XOR (uint64) and its
+key XOR.
+*/
+
+// WriteTo writes the table in the package's binary format, implementing
+// io.WriterTo
+func (t *IBLT) WriteTo(w io.Writer) (int64, error) {
+	var buf [headerSize + ibltPayloadHeaderSize]byte
+	h := filterHeader{
+		version:     formatVersion,
+		kind:        filterKindIBLT,
+		payloadSize: ibltPayloadHeaderSize + t.MemoryUsage(),
+	}
+	h.encode(buf[:headerSize])
+	p := buf[headerSize:]
+	binary.LittleEndian.PutUint64(p[0:], t.cellCount)
+	binary.LittleEndian.PutUint32(p[8:], uint32(t.keySize))
+	p[12] = ibltHashCount
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriterSize(cw, payloadChunkSize)
+	bw.Write(buf[:])
+	var cell [16]byte
+	for i := uint64(0); i < t.cellCount; i++ {
+		binary.LittleEndian.PutUint64(cell[0:], uint64(t.counts[i]))
+		binary.LittleEndian.PutUint64(cell[8:], t.hashSums[i])
+		bw.Write(cell[:])
+		bw.Write(t.keySum(i))
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// MarshalBinary encodes the table like WriteTo
+func (t *IBLT) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := t.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadFrom replaces the table with one read from r, implementing
+// io.ReaderFrom. It leaves any following data unread.
+func (t *IBLT) ReadFrom(r io.Reader) (int64, error) {
+	var buf [headerSize + ibltPayloadHeaderSize]byte
+	n, err := io.ReadFull(r, buf[:])
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+
+	h, err := parseHeader(buf[:headerSize])
+	if err != nil {
+		return total, err
+	}
+	if h.kind != filterKindIBLT || h.flags != 0 {
+		return total, fmt.Errorf("%w: not an IBLT", ErrInvalidFormat)
+	}
+
+	p := buf[headerSize:]
+	cells := binary.LittleEndian.Uint64(p[0:])
+	keySize := uint64(binary.LittleEndian.Uint32(p[8:]))
+	if p[12] != ibltHashCount || keySize == 0 || keySize > ibltMaxKeySize ||
+		cells == 0 || cells%ibltHashCount != 0 {
+		return total, fmt.Errorf("%w: inconsistent IBLT parameters", ErrInvalidFormat)
+	}
+	cellsSize := h.payloadSize - ibltPayloadHeaderSize
+	if h.payloadSize < ibltPayloadHeaderSize || cells > cellsSize/(16+keySize) || cellsSize != cells*(16+keySize) {
+		return total, fmt.Errorf("%w: payload size %d", ErrInvalidFormat, h.payloadSize)
+	}
+
+	if cellsSize > maxCacheLines*CacheLineSize {
+		return total, fmt.Errorf("%w: IBLT too large", ErrInvalidFormat)
+	}
+	sized, err := checkPayloadSource(r, cellsSize)
+	if err != nil {
+		return total, err
+	}
+
+	// Cells are appended as they arrive, so a truncated payload cannot make
+	// the table allocate more than it delivered
+	capacity := initialCapacity(cells, 16+keySize, sized)
+	table := &IBLT{
+		counts:    make([]int64, 0, capacity),
+		hashSums:  make([]uint64, 0, capacity),
+		keySums:   make([]byte, 0, capacity*keySize),
+		keySize:   int(keySize),
+		cellCount: cells,
+	}
+	lr := &io.LimitedReader{R: r, N: int64(cellsSize)}
+	br := bufio.NewReaderSize(lr, payloadChunkSize)
+	cell := make([]byte, 16+keySize)
+	for i := uint64(0); i < cells; i++ {
+		if _, err = io.ReadFull(br, cell); err != nil {
+			break
+		}
+		table.counts = append(table.counts, int64(binary.LittleEndian.Uint64(cell[0:])))
+		table.hashSums = append(table.hashSums, binary.LittleEndian.Uint64(cell[8:]))
+		table.keySums = append(table.keySums, cell[16:]...)
+	}
+	total += int64(cellsSize) - lr.N - int64(br.Buffered())
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return total, err
+	}
+
+	*t = *table
+	return total, nil
+}
+
+// UnmarshalBinary replaces the table with data produced by MarshalBinary
+func (t *IBLT) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := t.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidFormat, r.Len())
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+// ibltTestKeys returns count distinct 16-byte keys tagged with tag
+func ibltTestKeys(tag uint64, count int) [][]byte {
+	keys := make([][]byte, count)
+	for i := range keys {
+		keys[i] = binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, tag), uint64(i))
+	}
+	return keys
+}
+
+// assertSameKeys checks that two key lists hold the same keys in any order
+func assertSameKeys(t *testing.T, what string, got, want [][]byte) {
+	t.Helper()
+	got, want = slices.Clone(got), slices.Clone(want)
+	slices.SortFunc(got, bytes.Compare)
+	slices.SortFunc(want, bytes.Compare)
+	if !slices.EqualFunc(got, want, bytes.Equal) {
+		t.Errorf("%s: expected %d keys, got %d different ones", what, len(want), len(got))
+	}
+}
+
+// TestIBLTReconcile tests recovering the difference between two replicas
+func TestIBLTReconcile(t *testing.T) {
+	shared := ibltTestKeys(0, 10000)
+	onlyA, onlyB := ibltTestKeys(1, 30), ibltTestKeys(2, 20)
+
+	a, err := NewIBLTForDifference(50, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewIBLTForDifference(50, 16)
+	for _, key := range append(slices.Clone(shared), onlyA...) {
+		a.Insert(key)
+	}
+	for _, key := range append(slices.Clone(shared), onlyB...) {
+		b.Insert(key)
+	}
+
+	if err := a.Subtract(b); err != nil {
+		t.Fatal(err)
+	}
+	inserted, deleted, err := a.ListEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameKeys(t, "keys only in A", inserted, onlyA)
+	assertSameKeys(t, "keys only in B", deleted, onlyB)
+
+	// ListEntries works on a copy; Decode empties the table itself
+	if a.IsEmpty() {
+		t.Fatal("ListEntries modified the table")
+	}
+	if _, _, err := a.Decode(); err != nil || !a.IsEmpty() {
+		t.Errorf("Decode did not empty the table: %v", err)
+	}
+}
+
+// TestIBLTInsertDelete tests that deletes cancel inserts and that deletes of
+// absent keys are reported
+func TestIBLTInsertDelete(t *testing.T) {
+	tb, err := NewIBLT(100, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tb.Cells() != 100 || tb.KeySize() != 16 || tb.MemoryUsage() != 100*32 {
+		t.Errorf("Unexpected size: %d cells of %d-byte keys, %d bytes", tb.Cells(), tb.KeySize(), tb.MemoryUsage())
+	}
+
+	keys := ibltTestKeys(3, 500)
+	for _, key := range keys {
+		tb.Insert(key)
+	}
+	for _, key := range keys[:490] {
+		tb.Delete(key)
+	}
+	tb.DeleteString("sixteen byte key")
+	inserted, deleted, err := tb.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameKeys(t, "inserted", inserted, keys[490:])
+	assertSameKeys(t, "deleted", deleted, [][]byte{[]byte("sixteen byte key")})
+
+	tb.InsertString("sixteen byte key")
+	tb.Clear()
+	if !tb.IsEmpty() {
+		t.Error("Clear left cells behind")
+	}
+
+	if err := tb.Insert([]byte("short")); err == nil {
+		t.Error("Expected error for a key of the wrong size")
+	}
+	if _, err := NewIBLT(100, 0); err == nil {
+		t.Error("Expected error for a zero key size")
+	}
+	other, _ := NewIBLT(200, 16)
+	if err := tb.Subtract(other); err == nil {
+		t.Error("Expected error subtracting tables of different sizes")
+	}
+}
+
+// TestIBLTOverfull tests that a table with too many keys reports failure
+// and returns what it could recover
+func TestIBLTOverfull(t *testing.T) {
+	tb, _ := NewIBLT(40, 16)
+	keys := ibltTestKeys(4, 200)
+	for _, key := range keys {
+		tb.Insert(key)
+	}
+	inserted, _, err := tb.ListEntries()
+	if !errors.Is(err, ErrDecodeFailed) {
+		t.Fatalf("Expected ErrDecodeFailed, got %v", err)
+	}
+	for _, key := range inserted {
+		if !slices.ContainsFunc(keys, func(k []byte) bool { return bytes.Equal(k, key) }) {
+			t.Fatalf("Decoded key %x was never inserted", key)
+		}
+	}
+}
+
+// TestIBLTSizing tests that tables sized for a difference decode it
+func TestIBLTSizing(t *testing.T) {
+	for _, d := range []int{1, 10, 100, 1000, 10000} {
+		tb, _ := NewIBLTForDifference(uint64(d), 16)
+		if float64(tb.Cells()) < 1.3*float64(d) {
+			t.Errorf("%d cells are too few for a difference of %d", tb.Cells(), d)
+		}
+		for _, key := range ibltTestKeys(uint64(d), d) {
+			tb.Insert(key)
+		}
+		if inserted, _, err := tb.Decode(); err != nil || len(inserted) != d {
+			t.Errorf("Difference of %d: decoded %d keys, %v", d, len(inserted), err)
+		}
+	}
+}
+
+// TestIBLTSerialization tests exchanging a table in the binary format
+func TestIBLTSerialization(t *testing.T) {
+	tb, _ := NewIBLTForDifference(20, 16)
+	keys := ibltTestKeys(5, 20)
+	for _, key := range keys {
+		tb.Insert(key)
+	}
+
+	data, err := tb.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded IBLT
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Cells() != tb.Cells() || decoded.KeySize() != tb.KeySize() {
+		t.Errorf("Expected %d cells of %d bytes, got %d of %d", tb.Cells(), tb.KeySize(), decoded.Cells(), decoded.KeySize())
+	}
+	if err := decoded.Subtract(tb); err != nil || !decoded.IsEmpty() {
+		t.Errorf("Decoded table differs from the original: %v", err)
+	}
+
+	var bf CacheOptimizedBloomFilter
+	if err := bf.UnmarshalBinary(data); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat loading an IBLT as a bloom filter, got %v", err)
+	}
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+
+	var buf bytes.Buffer
+	tb.WriteTo(&buf)
+	buf.WriteString("tail")
+	if n, err := decoded.ReadFrom(&buf); err != nil || n != int64(len(data)) || buf.String() != "tail" {
+		t.Errorf("ReadFrom did not stop at the end of the table: read %d of %d bytes, %v", n, len(data), err)
+	}
+	inserted, _, err := decoded.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameKeys(t, "decoded keys", inserted, keys)
+
+	// A header claiming far more cells than follow fails before allocating
+	const cells = 1 << 24
+	oversized := slices.Clone(data[:headerSize+ibltPayloadHeaderSize])
+	binary.LittleEndian.PutUint64(oversized[40:], ibltPayloadHeaderSize+cells*32)
+	binary.LittleEndian.PutUint64(oversized[headerSize:], cells)
+	for _, r := range []io.Reader{bytes.NewReader(oversized), opaqueReader{bytes.NewReader(oversized)}} {
+		if _, err := decoded.ReadFrom(r); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected io.ErrUnexpectedEOF for an oversized header, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package bloomfilter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"unsafe"
+)
+
+var (
+	// ErrReadOnly is returned when writing back a filter that was mapped read-only
+	ErrReadOnly = errors.New("bloom filter is mapped read-only")
+	// ErrClosed is returned when using a file-backed filter after Close
+	ErrClosed = errors.New("bloom filter is closed")
+	// ErrMappingUnsupported is returned where file mappings are not available
+	ErrMappingUnsupported = errors.New("memory-mapped bloom filters are not supported on this platform")
+	// ErrReplaceMapped is returned by the decoders of a mapped filter, which
+	// would otherwise move it off its file
+	ErrReplaceMapped = errors.New("cannot replace the contents of a memory-mapped bloom filter")
+)
+
+// MappedOptions configures OpenMapped
+type MappedOptions struct {
+	// Sizing used when the file is created; ignored for existing files,
+	// whose parameters come from the header
+	ExpectedElements  uint64
+	FalsePositiveRate float64
+
+	// ReadOnly opens the file without write access. The filter can still be
+	// modified, but changes stay private to the process and are discarded on Close.
+	ReadOnly bool
+}
+
+// MappedBloomFilter is a CacheOptimizedBloomFilter whose cache lines live
+// directly in a memory-mapped file using the package's binary format.
+// Add and Contains operate on the mapping itself, so nothing is loaded up
+// front and the filter may be larger than is comfortable on the heap.
+// Decoding into a mapped filter (UnmarshalBinary, ReadFrom, UnmarshalText,
+// UnmarshalJSON, GobDecode, Scan) fails with ErrReplaceMapped; merge
+// another filter with Union or ApplyDelta instead.
+type MappedBloomFilter struct {
+	*CacheOptimizedBloomFilter
+
+	file     *os.File
+	data     []byte // whole mapping: header followed by the cache line payload
+	readOnly bool
+}
+
+// OpenMapped opens the filter stored at path, creating it from
+// opts.ExpectedElements and opts.FalsePositiveRate if the file does not
+// exist or is empty. The payload follows the 64-byte header, so it is
+// cache line aligned within the page-aligned mapping.
+func OpenMapped(path string, opts MappedOptions) (*MappedBloomFilter, error) {
+	if !hostLittleEndian {
+		return nil, ErrMappingUnsupported
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := mapFilterFile(file, opts)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// mapFilterFile initializes or validates the file and maps it
+func mapFilterFile(file *os.File, opts MappedOptions) (*MappedBloomFilter, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var h filterHeader
+	create := info.Size() == 0
+	if create {
+		if opts.ReadOnly {
+			return nil, fmt.Errorf("%w: %s is empty", ErrInvalidFormat, file.Name())
+		}
+		if opts.ExpectedElements == 0 || opts.FalsePositiveRate <= 0 || opts.FalsePositiveRate >= 1 {
+			return nil, fmt.Errorf("creating %s requires ExpectedElements and a FalsePositiveRate in (0, 1)", file.Name())
+		}
+
+		cacheLineCount, hashCount := optimalParameters(opts.ExpectedElements, opts.FalsePositiveRate)
+		h = filterHeader{
+			version:        formatVersion,
+			hashCount:      hashCount,
+			bitCount:       cacheLineCount * BitsPerCacheLine,
+			cacheLineCount: cacheLineCount,
+			payloadSize:    cacheLineCount * CacheLineSize,
+		}
+		// Truncate zero-fills the payload, which is an empty filter
+		if err := file.Truncate(int64(headerSize + h.payloadSize)); err != nil {
+			return nil, err
+		}
+	} else {
+		var headerBuf [headerSize]byte
+		if _, err := file.ReadAt(headerBuf[:], 0); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+		}
+		if h, err = decodeHeader(headerBuf[:]); err != nil {
+			return nil, err
+		}
+		if h.compressed() {
+			return nil, ErrCompressed
+		}
+		if uint64(info.Size()) != headerSize+h.payloadSize {
+			return nil, fmt.Errorf("%w: file is %d bytes, expected %d", ErrInvalidFormat, info.Size(), headerSize+h.payloadSize)
+		}
+	}
+
+	data, err := mapFile(file, int(headerSize+h.payloadSize), opts.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	if create {
+		h.encode(data[:headerSize])
+	}
+
+	memory := &cacheLineMemory{
+		lines:    unsafe.Slice((*CacheLine)(unsafe.Pointer(&data[headerSize])), h.cacheLineCount),
+		mapped:   data,
+		size:     uint64(len(data)),
+		strategy: AllocationFileMapping,
+	}
+	runtime.SetFinalizer(memory, (*cacheLineMemory).release)
+
+	return &MappedBloomFilter{
+		CacheOptimizedBloomFilter: newFilterWithMemory(h.cacheLineCount, h.hashCount, memory),
+		file:                      file,
+		data:                      data,
+		readOnly:                  opts.ReadOnly,
+	}, nil
+}
+
+// Sync flushes changes to the underlying file
+func (m *MappedBloomFilter) Sync() error {
+	if m.file == nil {
+		return ErrClosed
+	}
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	err := syncMapping(m.data)
+	runtime.KeepAlive(m.CacheOptimizedBloomFilter)
+	return err
+}
+
+// Close unmaps the filter and closes the file. Changes already made are
+// visible in the file without Sync, but are only guaranteed durable after
+// Sync. The filter must not be used after Close.
+func (m *MappedBloomFilter) Close() error {
+	if m.file == nil {
+		return ErrClosed
+	}
+
+	memory := m.memory
+	runtime.SetFinalizer(memory, nil)
+	memory.release()
+	m.cacheLines = nil
+	m.data = nil
+
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
+
+// UnmarshalBinary fails with ErrReplaceMapped
+func (m *MappedBloomFilter) UnmarshalBinary(data []byte) error {
+	return ErrReplaceMapped
+}
+
+// ReadFrom fails with ErrReplaceMapped without reading from r
+func (m *MappedBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	return 0, ErrReplaceMapped
+}
+
+// UnmarshalText fails with ErrReplaceMapped
+func (m *MappedBloomFilter) UnmarshalText(text []byte) error {
+	return ErrReplaceMapped
+}
+
+// UnmarshalJSON fails with ErrReplaceMapped
+func (m *MappedBloomFilter) UnmarshalJSON(data []byte) error {
+	return ErrReplaceMapped
+}
+
+// GobDecode fails with ErrReplaceMapped
+func (m *MappedBloomFilter) GobDecode(data []byte) error {
+	return ErrReplaceMapped
+}
+
+// Scan fails with ErrReplaceMapped
+func (m *MappedBloomFilter) Scan(src any) error {
+	return ErrReplaceMapped
+}
@@ -0,0 +1,186 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// skipWithoutMapping skips tests on platforms without file mapping support
+func skipWithoutMapping(t *testing.T) {
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd":
+	default:
+		t.Skipf("file mappings are not supported on %s", runtime.GOOS)
+	}
+}
+
+// TestMappedFilterPersistence tests that a mapped filter survives close and reopen
+func TestMappedFilterPersistence(t *testing.T) {
+	skipWithoutMapping(t)
+	path := filepath.Join(t.TempDir(), "filter.bloom")
+	opts := MappedOptions{ExpectedElements: 10000, FalsePositiveRate: 0.01}
+
+	m, err := OpenMapped(path, opts)
+	if err != nil {
+		t.Fatalf("OpenMapped failed: %v", err)
+	}
+	stats := m.GetCacheStats()
+	if stats.Alignment != 0 {
+		t.Errorf("Mapped payload misaligned by %d bytes", stats.Alignment)
+	}
+	if stats.AllocationStrategy != AllocationFileMapping {
+		t.Errorf("Expected file mapping strategy, got %s", stats.AllocationStrategy)
+	}
+
+	for i := 0; i < 1000; i++ {
+		m.AddString(fmt.Sprintf("mapped_%d", i))
+	}
+	if err := m.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := m.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed on second Close, got %v", err)
+	}
+
+	// The file is a regular serialized filter
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded CacheOptimizedBloomFilter
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Mapped file is not a valid serialized filter: %v", err)
+	}
+
+	// Reopen ignores sizing options and reads the header
+	reopened, err := OpenMapped(path, MappedOptions{})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	assertSameFilter(t, &decoded, reopened.CacheOptimizedBloomFilter)
+	for i := 0; i < 1000; i++ {
+		if !reopened.ContainsString(fmt.Sprintf("mapped_%d", i)) {
+			t.Fatalf("Reopened filter lost element %d", i)
+		}
+	}
+}
+
+// TestMappedFilterReadOnly tests that read-only mappings never modify the file
+func TestMappedFilterReadOnly(t *testing.T) {
+	skipWithoutMapping(t)
+	path := filepath.Join(t.TempDir(), "filter.bloom")
+
+	m, err := OpenMapped(path, MappedOptions{ExpectedElements: 1000, FalsePositiveRate: 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddString("persisted")
+	m.Close()
+	before, _ := os.ReadFile(path)
+
+	ro, err := OpenMapped(path, MappedOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Read-only open failed: %v", err)
+	}
+	if !ro.ContainsString("persisted") {
+		t.Error("Read-only filter lost an element")
+	}
+	ro.AddString("private")
+	if !ro.ContainsString("private") {
+		t.Error("Private write not visible in-process")
+	}
+	if err := ro.Sync(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from Sync, got %v", err)
+	}
+	ro.Close()
+
+	after, _ := os.ReadFile(path)
+	if string(before) != string(after) {
+		t.Error("Read-only mapping modified the file")
+	}
+}
+
+// TestMappedFilterInvalidFiles tests rejection of missing sizing and corrupt files
+func TestMappedFilterInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := OpenMapped(filepath.Join(dir, "new.bloom"), MappedOptions{}); err == nil {
+		t.Error("Expected error creating a filter without sizing")
+	}
+	if _, err := OpenMapped(filepath.Join(dir, "missing.bloom"), MappedOptions{ReadOnly: true}); err == nil {
+		t.Error("Expected error opening a missing file read-only")
+	}
+
+	garbage := filepath.Join(dir, "garbage.bloom")
+	os.WriteFile(garbage, []byte("definitely not a bloom filter, but long enough for a header........"), 0o644)
+	if _, err := OpenMapped(garbage, MappedOptions{}); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat, got %v", err)
+	}
+
+	truncated := filepath.Join(dir, "truncated.bloom")
+	data, _ := NewCacheOptimizedBloomFilter(1000, 0.01).MarshalBinary()
+	os.WriteFile(truncated, data[:len(data)-CacheLineSize], 0o644)
+	if _, err := OpenMapped(truncated, MappedOptions{}); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat for truncated file, got %v", err)
+	}
+}
+
+// TestMappedFilterRejectsDecoding tests that decoders cannot move a mapped
+// filter off its file
+func TestMappedFilterRejectsDecoding(t *testing.T) {
+	skipWithoutMapping(t)
+	path := filepath.Join(t.TempDir(), "filter.bloom")
+	opts := MappedOptions{ExpectedElements: 1000, FalsePositiveRate: 0.01}
+
+	m, err := OpenMapped(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewCacheOptimizedBloomFilter(1000, 0.01)
+	other.AddString("other")
+	binaryData, _ := other.MarshalBinary()
+	textData, _ := other.MarshalText()
+	jsonData, _ := json.Marshal(other)
+	gobData, _ := other.GobEncode()
+
+	for name, decode := range map[string]func() error{
+		"UnmarshalBinary": func() error { return m.UnmarshalBinary(binaryData) },
+		"ReadFrom":        func() error { _, err := m.ReadFrom(bytes.NewReader(binaryData)); return err },
+		"UnmarshalText":   func() error { return m.UnmarshalText(textData) },
+		"UnmarshalJSON":   func() error { return json.Unmarshal(jsonData, m) },
+		"GobDecode":       func() error { return m.GobDecode(gobData) },
+		"Scan":            func() error { return m.Scan(binaryData) },
+	} {
+		if err := decode(); !errors.Is(err, ErrReplaceMapped) {
+			t.Errorf("%s: expected ErrReplaceMapped, got %v", name, err)
+		}
+	}
+	if m.ContainsString("other") {
+		t.Error("A rejected decode changed the filter")
+	}
+
+	// The filter still writes through to its file
+	m.AddString("after_decode")
+	if err := m.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	reopened, err := OpenMapped(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if !reopened.ContainsString("after_decode") {
+		t.Error("Add after a rejected decode did not reach the file")
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !(linux || darwin || freebsd)
+
+package bloomfilter
+
+import "os"
+
+// mapFile is unsupported on this platform
+func mapFile(f *os.File, size int, readOnly bool) ([]byte, error) {
+	return nil, ErrMappingUnsupported
+}
+
+// syncMapping is unsupported on this platform
+func syncMapping(mapped []byte) error {
+	return ErrMappingUnsupported
+}
+
+// unmapCacheLines is never reached on this platform since nothing is mapped
+func unmapCacheLines(mapped []byte) {}
+
+// lockFile is unsupported on this platform
+func lockFile(f *os.File) error {
+	return ErrMappingUnsupported
+}
+
+// unlockFile is unsupported on this platform
+func unlockFile(f *os.File) error {
+	return ErrMappingUnsupported
+}
@@ -0,0 +1,45 @@
+//go:build linux || darwin || freebsd
+
+package bloomfilter
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mapFile maps the first size bytes of f. Writable mappings are shared with
+// the file; read-only files get a private copy-on-write mapping so that
+// in-process writes never fault and never reach the file.
+func mapFile(f *os.File, size int, readOnly bool) ([]byte, error) {
+	flags := syscall.MAP_SHARED
+	if readOnly {
+		flags = syscall.MAP_PRIVATE
+	}
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, flags)
+}
+
+// syncMapping flushes a shared file mapping to storage
+func syncMapping(mapped []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC,
+		uintptr(unsafe.Pointer(&mapped[0])), uintptr(len(mapped)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// unmapCacheLines releases a mapping created by mapCacheLines or mapFile
+func unmapCacheLines(mapped []byte) {
+	_ = syscall.Munmap(mapped)
+}
+
+// lockFile takes an exclusive advisory lock on f, blocking until it is granted
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,84 @@
+package bloomfilter
+
+// Option configures optional behaviour of NewCacheOptimizedBloomFilter
+type Option func(*filterOptions)
+
+// filterOptions collects the settings applied by Option values
+type filterOptions struct {
+	// Back large filters with anonymous memory advised for transparent huge pages
+	hugePages bool
+	// Back large filters with explicitly reserved huge pages (MAP_HUGETLB)
+	hugeTLB bool
+	// Lock the cache line storage into RAM
+	lockMemory bool
+	// Track modified cache lines for delta export
+	dirtyTracking bool
+	// Round the cache line count up to a power of two
+	powerOfTwo bool
+	// Seed for filters that make random choices; random when not set
+	seed   uint64
+	seeded bool
+}
+
+// WithHugePages backs filters of at least one huge page (2 MiB) with an
+// anonymous mmap region advised with MADV_HUGEPAGE. It is Linux only; other
+// platforms and failed mappings fall back to the Go heap.
+func WithHugePages() Option {
+	return func(o *filterOptions) {
+		o.hugePages = true
+	}
+}
+
+// WithHugeTLB backs filters of at least one huge page (2 MiB) with huge pages
+// from the kernel's reserved pool (MAP_HUGETLB). When the pool is empty or
+// unavailable it falls back to transparent huge pages, then to the heap.
+func WithHugeTLB() Option {
+	return func(o *filterOptions) {
+		o.hugeTLB = true
+	}
+}
+
+// WithLockedMemory allocates the cache lines from an anonymous mapping and
+// mlocks it so it is never swapped out. If locking is not permitted (see
+// RLIMIT_MEMLOCK) the mapping is kept unlocked; CacheStats.MemoryLocked
+// reports the outcome.
+func WithLockedMemory() Option {
+	return func(o *filterOptions) {
+		o.lockMemory = true
+	}
+}
+
+// WithDirtyTracking records which cache lines are modified so that
+// ExportDelta can replicate just the changes. See EnableDirtyTracking.
+func WithDirtyTracking() Option {
+	return func(o *filterOptions) {
+		o.dirtyTracking = true
+	}
+}
+
+// WithPowerOfTwoSize rounds the cache line count up to a power of two, which
+// lowers the false positive rate slightly and lets the filter be folded to a
+// smaller size with Fold or unioned with filters of other power-of-two sizes.
+func WithPowerOfTwoSize() Option {
+	return func(o *filterOptions) {
+		o.powerOfTwo = true
+	}
+}
+
+// WithSeed seeds the random choices of filters that make them, such as the
+// cells a StableBloomFilter decrements, so that runs are reproducible
+func WithSeed(seed uint64) Option {
+	return func(o *filterOptions) {
+		o.seed = seed
+		o.seeded = true
+	}
+}
+
+// applyOptions builds the effective settings from a list of options
+func applyOptions(opts []Option) filterOptions {
+	var o filterOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,644 @@
+package bloomfilter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"unsafe"
+)
+
+// Quotient filter slot layout: three metadata bits below the remainder
+const (
+	// The slot's index is the quotient of at least one stored fingerprint
+	qfOccupied = 1 << 0
+	// The remainder belongs to the same run as the one before it
+	qfContinuation = 1 << 1
+	// The remainder is not in its canonical slot
+	qfShifted = 1 << 2
+
+	qfMetadataBits = 3
+	qfMetadataMask = qfOccupied | qfContinuation | qfShifted
+)
+
+// Quotient filter limits
+const (
+	// Load above which Add doubles the table; clusters grow quickly past it
+	quotientMaxLoad = 0.75
+	// Largest supported table, 2^48 slots
+	quotientMaxBits = 48
+	// Widest remainder that fits a 64-bit slot with its metadata
+	quotientMaxRemainderBits = 64 - qfMetadataBits
+	// Bytes of quotient filter parameters at the start of the payload
+	quotientPayloadHeaderSize = 16
+	// Readers accept tables of up to 2^quotientReadFreeBits slots whatever
+	// they hold; larger ones need one fingerprint per 2^quotientReadSparsity
+	// slots, so a short header cannot demand a huge allocation
+	quotientReadFreeBits = 20
+	quotientReadSparsity = 10
+)
+
+// QuotientFilter is a quotient filter (Bender et al., 2012) storing a
+// fingerprint of each element: the high bits select one of 2^q slots and the
+// remaining r bits are kept in it, with three metadata bits locating
+// remainders displaced by collisions. Because the fingerprints themselves are
+// stored, the filter supports Delete, can enumerate its contents, and can be
+// resized or merged with another filter without the original keys.
+//
+// Elements added twice are stored twice, so each Delete removes one copy.
+// Add doubles the table once it is three quarters full, trading a remainder
+// bit for a quotient bit; the fingerprint width, and so the false positive
+// rate at a given element count, is unchanged.
+type QuotientFilter struct {
+	quotientBits  uint8
+	remainderBits uint8
+	slotBits      uint8
+	slotCount     uint64
+	count         uint64
+
+	// Slots packed slotBits apart
+	words []uint64
+}
+
+// NewQuotientFilter creates a quotient filter for expectedElements with
+// roughly the given false positive rate
+func NewQuotientFilter(expectedElements uint64, falsePositiveRate float64) *QuotientFilter {
+	quotientBits := uint8(6)
+	for quotientBits < quotientMaxBits && float64(expectedElements) > quotientMaxLoad*float64(uint64(1)<<quotientBits) {
+		quotientBits++
+	}
+
+	// A lookup matches a stored fingerprint with probability load * 2^-r
+	remainderBits := uint8(1)
+	if falsePositiveRate > 0 && falsePositiveRate < 1 {
+		remainderBits = uint8(max(1, math.Ceil(-math.Log2(falsePositiveRate))))
+	}
+	remainderBits = min(remainderBits, quotientMaxRemainderBits, 64-quotientBits)
+
+	return newQuotientFilter(quotientBits, remainderBits)
+}
+
+// newQuotientFilter creates an empty filter with 2^quotientBits slots
+func newQuotientFilter(quotientBits, remainderBits uint8) *QuotientFilter {
+	slotBits := remainderBits + qfMetadataBits
+	slotCount := uint64(1) << quotientBits
+	return &QuotientFilter{
+		quotientBits:  quotientBits,
+		remainderBits: remainderBits,
+		slotBits:      slotBits,
+		slotCount:     slotCount,
+		words:         make([]uint64, (slotCount*uint64(slotBits)+63)/64),
+	}
+}
+
+// fingerprintBits returns the width of the stored fingerprints
+func (qf *QuotientFilter) fingerprintBits() uint8 {
+	return qf.quotientBits + qf.remainderBits
+}
+
+// fingerprint derives the stored fingerprint of data
+func (qf *QuotientFilter) fingerprint(data []byte) uint64 {
+	return hashOptimized1(data) >> (64 - qf.fingerprintBits())
+}
+
+// split divides a fingerprint into its quotient and remainder
+func (qf *QuotientFilter) split(fp uint64) (quotient, remainder uint64) {
+	return fp >> qf.remainderBits, fp & (1<<qf.remainderBits - 1)
+}
+
+// slot reads the packed slot at index i
+func (qf *QuotientFilter) slot(i uint64) uint64 {
+	bit := i * uint64(qf.slotBits)
+	word, offset := bit/64, bit%64
+	v := qf.words[word] >> offset
+	if offset+uint64(qf.slotBits) > 64 {
+		v |= qf.words[word+1] << (64 - offset)
+	}
+	return v & (1<<qf.slotBits - 1)
+}
+
+// setSlot writes the packed slot at index i
+func (qf *QuotientFilter) setSlot(i, v uint64) {
+	bit := i * uint64(qf.slotBits)
+	word, offset := bit/64, bit%64
+	mask := uint64(1)<<qf.slotBits - 1
+	qf.words[word] = qf.words[word]&^(mask<<offset) | v<<offset
+	if offset+uint64(qf.slotBits) > 64 {
+		spill := 64 - offset
+		qf.words[word+1] = qf.words[word+1]&^(mask>>spill) | v>>spill
+	}
+}
+
+// next and prev step through the slots circularly
+func (qf *QuotientFilter) next(i uint64) uint64 { return (i + 1) & (qf.slotCount - 1) }
+func (qf *QuotientFilter) prev(i uint64) uint64 { return (i - 1) & (qf.slotCount - 1) }
+
+// findRunStart returns the slot where the run of remainders for quotient
+// begins, or would begin. It walks back to the start of the cluster and then
+// forward, pairing each occupied quotient with the run it owns.
+func (qf *QuotientFilter) findRunStart(quotient uint64) uint64 {
+	b := quotient
+	for qf.slot(b)&qfShifted != 0 {
+		b = qf.prev(b)
+	}
+
+	s := b
+	for b != quotient {
+		for {
+			s = qf.next(s)
+			if qf.slot(s)&qfContinuation == 0 {
+				break
+			}
+		}
+		for {
+			b = qf.next(b)
+			if qf.slot(b)&qfOccupied != 0 {
+				break
+			}
+		}
+	}
+	return s
+}
+
+// insert stores a fingerprint, keeping each run sorted. The table must have
+// a free slot.
+func (qf *QuotientFilter) insert(quotient, remainder uint64) {
+	canonical := qf.slot(quotient)
+	if canonical&qfMetadataMask == 0 {
+		qf.setSlot(quotient, remainder<<qfMetadataBits|qfOccupied)
+		qf.count++
+		return
+	}
+
+	wasOccupied := canonical&qfOccupied != 0
+	qf.setSlot(quotient, canonical|qfOccupied)
+	start := qf.findRunStart(quotient)
+
+	s := start
+	entry := remainder << qfMetadataBits
+	if wasOccupied {
+		for qf.slot(s)>>qfMetadataBits < remainder {
+			s = qf.next(s)
+			if qf.slot(s)&qfContinuation == 0 {
+				break
+			}
+		}
+		if s == start {
+			// The old run head moves up and continues the run
+			qf.setSlot(start, qf.slot(start)|qfContinuation)
+		} else {
+			entry |= qfContinuation
+		}
+	}
+	if s != quotient {
+		entry |= qfShifted
+	}
+
+	// Shift the rest of the cluster up by one slot; occupied bits stay put
+	for {
+		displaced := qf.slot(s)
+		qf.setSlot(s, entry|displaced&qfOccupied)
+		if displaced&qfMetadataMask == 0 {
+			break
+		}
+		entry = displaced&^qfOccupied | qfShifted
+		s = qf.next(s)
+	}
+	qf.count++
+}
+
+// build fills an empty filter from fingerprints in ascending order, laying
+// the slots out in a single pass. Only runs that would wrap past the last
+// slot go through insert.
+func (qf *QuotientFilter) build(fingerprints []uint64) {
+	var pos, previous uint64
+	for i, fp := range fingerprints {
+		if pos >= qf.slotCount {
+			for _, rest := range fingerprints[i:] {
+				qf.insert(qf.split(rest))
+			}
+			return
+		}
+
+		quotient, remainder := qf.split(fp)
+		s := max(pos, quotient)
+		entry := remainder << qfMetadataBits
+		if i > 0 && quotient == previous {
+			entry |= qfContinuation
+		}
+		if s != quotient {
+			entry |= qfShifted
+		}
+		qf.setSlot(s, entry|qf.slot(s)&qfOccupied)
+		qf.setSlot(quotient, qf.slot(quotient)|qfOccupied)
+
+		qf.count++
+		pos, previous = s+1, quotient
+	}
+}
+
+// walk calls fn with the fingerprint in each slot from start, which must not
+// hold a shifted remainder, for limit slots or, if cluster is set, until the
+// cluster ends. It returns the slot it stopped at.
+func (qf *QuotientFilter) walk(start, limit uint64, cluster bool, fn func(fp uint64)) uint64 {
+	// Occupied quotients whose runs have not started yet, in order
+	var pending []uint64
+	var quotient uint64
+
+	s := start
+	for i := uint64(0); i < limit; i++ {
+		v := qf.slot(s)
+		if cluster && i > 0 && v&qfShifted == 0 {
+			break
+		}
+		if v&qfOccupied != 0 {
+			pending = append(pending, s)
+		}
+		if v&qfMetadataMask != 0 {
+			if v&qfContinuation == 0 {
+				quotient, pending = pending[0], pending[1:]
+			}
+			fn(quotient<<qf.remainderBits | v>>qfMetadataBits)
+		}
+		s = qf.next(s)
+	}
+	return s
+}
+
+// Fingerprints returns the stored fingerprints in ascending order, one per
+// added copy. Each is the top QuotientBits+RemainderBits bits of the
+// element's hash.
+func (qf *QuotientFilter) Fingerprints() []uint64 {
+	if qf.count == 0 {
+		return nil
+	}
+
+	// Start at a slot no cluster runs across
+	start := uint64(0)
+	for qf.slot(start)&qfShifted != 0 {
+		start++
+	}
+	fingerprints := make([]uint64, 0, qf.count)
+	qf.walk(start, qf.slotCount, false, func(fp uint64) {
+		fingerprints = append(fingerprints, fp)
+	})
+
+	// Quotients below start were visited last; rotate them to the front
+	for i := 1; i < len(fingerprints); i++ {
+		if fingerprints[i] < fingerprints[i-1] {
+			return append(fingerprints[i:], fingerprints[:i]...)
+		}
+	}
+	return fingerprints
+}
+
+// Add adds an element. It returns false only when the table is full and has
+// no remainder bit left to trade for a larger one.
+func (qf *QuotientFilter) Add(data []byte) bool {
+	if float64(qf.count+1) > quotientMaxLoad*float64(qf.slotCount) {
+		if err := qf.Resize(); err != nil && qf.count == qf.slotCount {
+			return false
+		}
+	}
+	qf.insert(qf.split(qf.fingerprint(data)))
+	return true
+}
+
+// Contains checks membership
+func (qf *QuotientFilter) Contains(data []byte) bool {
+	quotient, remainder := qf.split(qf.fingerprint(data))
+	if qf.slot(quotient)&qfOccupied == 0 {
+		return false
+	}
+
+	s := qf.findRunStart(quotient)
+	for {
+		stored := qf.slot(s) >> qfMetadataBits
+		if stored == remainder {
+			return true
+		}
+		if stored > remainder {
+			return false
+		}
+		s = qf.next(s)
+		if qf.slot(s)&qfContinuation == 0 {
+			return false
+		}
+	}
+}
+
+// Delete removes one copy of an element, reporting whether it was found.
+// Only delete elements that were added: removing a false positive deletes
+// another element's fingerprint.
+func (qf *QuotientFilter) Delete(data []byte) bool {
+	return qf.deleteFingerprint(qf.fingerprint(data))
+}
+
+// deleteFingerprint removes one copy of a stored fingerprint
+func (qf *QuotientFilter) deleteFingerprint(target uint64) bool {
+	quotient, _ := qf.split(target)
+	if qf.slot(quotient)&qfOccupied == 0 {
+		return false
+	}
+
+	// Take the whole cluster out and put back all but one copy of target
+	start := quotient
+	for qf.slot(start)&qfShifted != 0 {
+		start = qf.prev(start)
+	}
+	var kept []uint64
+	found := false
+	end := qf.walk(start, qf.slotCount, true, func(fp uint64) {
+		if fp == target && !found {
+			found = true
+			return
+		}
+		kept = append(kept, fp)
+	})
+	if !found {
+		return false
+	}
+
+	for s := start; ; s = qf.next(s) {
+		qf.setSlot(s, 0)
+		if qf.next(s) == end {
+			break
+		}
+	}
+	qf.count -= uint64(len(kept)) + 1
+	for _, fp := range kept {
+		qf.insert(qf.split(fp))
+	}
+	return true
+}
+
+// AddString adds a string element
+func (qf *QuotientFilter) AddString(s string) bool {
+	return qf.Add(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// ContainsString checks if a string element exists
+func (qf *QuotientFilter) ContainsString(s string) bool {
+	return qf.Contains(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// DeleteString removes a string element
+func (qf *QuotientFilter) DeleteString(s string) bool {
+	return qf.Delete(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// Resize doubles the number of slots, moving one bit of every fingerprint
+// from the remainder to the quotient. It fails once the remainder is a
+// single bit.
+func (qf *QuotientFilter) Resize() error {
+	if qf.remainderBits <= 1 || qf.quotientBits >= quotientMaxBits {
+		return fmt.Errorf("quotient filter cannot grow past %d slots", qf.slotCount)
+	}
+	resized := newQuotientFilter(qf.quotientBits+1, qf.remainderBits-1)
+	resized.build(qf.Fingerprints())
+	*qf = *resized
+	return nil
+}
+
+// Merge adds every fingerprint of other in time linear in the two filters'
+// sizes, growing the table as needed. If the fingerprint widths differ, the
+// wider ones are truncated, so the result has the accuracy of the coarser
+// filter.
+func (qf *QuotientFilter) Merge(other *QuotientFilter) error {
+	width := min(qf.fingerprintBits(), other.fingerprintBits())
+	a, b := qf.Fingerprints(), other.Fingerprints()
+	for i := range a {
+		a[i] >>= qf.fingerprintBits() - width
+	}
+	for i := range b {
+		b[i] >>= other.fingerprintBits() - width
+	}
+
+	merged := make([]uint64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] <= b[j] {
+			merged = append(merged, a[i])
+			i++
+		} else {
+			merged = append(merged, b[j])
+			j++
+		}
+	}
+	merged = append(merged, a[i:]...)
+	merged = append(merged, b[j:]...)
+
+	quotientBits := max(qf.quotientBits, other.quotientBits)
+	for float64(len(merged)) > quotientMaxLoad*float64(uint64(1)<<quotientBits) {
+		quotientBits++
+	}
+	if quotientBits >= width || quotientBits > quotientMaxBits {
+		return fmt.Errorf("merged quotient filter needs %d quotient bits of %d-bit fingerprints", quotientBits, width)
+	}
+
+	result := newQuotientFilter(quotientBits, width-quotientBits)
+	result.build(merged)
+	*qf = *result
+	return nil
+}
+
+// Count returns the number of elements stored
+func (qf *QuotientFilter) Count() uint64 {
+	return qf.count
+}
+
+// Capacity returns the number of slots in the table
+func (qf *QuotientFilter) Capacity() uint64 {
+	return qf.slotCount
+}
+
+// LoadFactor returns the fraction of slots in use
+func (qf *QuotientFilter) LoadFactor() float64 {
+	return float64(qf.count) / float64(qf.slotCount)
+}
+
+// QuotientBits returns the number of fingerprint bits that select a slot
+func (qf *QuotientFilter) QuotientBits() int {
+	return int(qf.quotientBits)
+}
+
+// RemainderBits returns the number of fingerprint bits stored in a slot
+func (qf *QuotientFilter) RemainderBits() int {
+	return int(qf.remainderBits)
+}
+
+// EstimatedFPP estimates the false positive probability: the chance that one
+// of the stored fingerprints matches
+func (qf *QuotientFilter) EstimatedFPP() float64 {
+	return -math.Expm1(-float64(qf.count) / math.Exp2(float64(qf.fingerprintBits())))
+}
+
+// MemoryUsage returns the bytes allocated for the table
+func (qf *QuotientFilter) MemoryUsage() uint64 {
+	return uint64(len(qf.words)) * 8
+}
+
+// Clear removes every element
+func (qf *QuotientFilter) Clear() {
+	clear(qf.words)
+	qf.count = 0
+}
+
+/*
+Quotient filter payload
+
+	offset  size  field
+	0       1     quotient bits
+	1       1     remainder bits
+	2       1     Rice parameter
+	3       5     reserved, zero
+	8       8     fingerprint count
+
+followed by the fingerprints in ascending order as Golomb-Rice coded
+differences from the previous one (the first from zero), in the bit format
+described in compress.go. Readers rebuild the table from the fingerprints,
+allocating it only once they have all been decoded, and reject tables of more
+than 2^20 slots that are under 1/1024 full.
+*/
+
+// WriteTo writes the filter in the package's binary format, implementing
+// io.WriterTo
+func (qf *QuotientFilter) WriteTo(w io.Writer) (int64, error) {
+	fingerprints := qf.Fingerprints()
+	maxFingerprint := uint64(math.MaxUint64) >> (64 - qf.fingerprintBits())
+	k := riceParameter(maxFingerprint, uint64(len(fingerprints)))
+
+	var codeBits, previous uint64
+	for _, fp := range fingerprints {
+		codeBits += (fp-previous)>>k + 1 + uint64(k)
+		previous = fp
+	}
+
+	var buf [headerSize + quotientPayloadHeaderSize]byte
+	h := filterHeader{
+		version:     formatVersion,
+		kind:        filterKindQuotient,
+		payloadSize: quotientPayloadHeaderSize + (codeBits+7)/8,
+	}
+	h.encode(buf[:headerSize])
+	p := buf[headerSize:]
+	p[0] = qf.quotientBits
+	p[1] = qf.remainderBits
+	p[2] = k
+	binary.LittleEndian.PutUint64(p[8:], uint64(len(fingerprints)))
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriterSize(cw, payloadChunkSize)
+	bw.Write(buf[:])
+	rw := riceWriter{w: bw}
+	previous = 0
+	for _, fp := range fingerprints {
+		rw.writeRice(fp-previous, uint(k))
+		previous = fp
+	}
+	rw.flush()
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// MarshalBinary encodes the filter in the package's binary format
+func (qf *QuotientFilter) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := qf.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadFrom replaces the filter with one read from r, implementing
+// io.ReaderFrom. It leaves any following data unread.
+func (qf *QuotientFilter) ReadFrom(r io.Reader) (int64, error) {
+	var buf [headerSize + quotientPayloadHeaderSize]byte
+	n, err := io.ReadFull(r, buf[:])
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+
+	h, err := parseHeader(buf[:headerSize])
+	if err != nil {
+		return total, err
+	}
+	if h.kind != filterKindQuotient || h.flags != 0 {
+		return total, fmt.Errorf("%w: not a quotient filter", ErrInvalidFormat)
+	}
+
+	p := buf[headerSize:]
+	quotientBits, remainderBits, k := p[0], p[1], p[2]
+	count := binary.LittleEndian.Uint64(p[8:])
+	if quotientBits > quotientMaxBits || remainderBits == 0 || remainderBits > quotientMaxRemainderBits ||
+		quotientBits+remainderBits > 64 || k > 63 || count > uint64(1)<<quotientBits ||
+		h.payloadSize < quotientPayloadHeaderSize {
+		return total, fmt.Errorf("%w: inconsistent quotient filter parameters", ErrInvalidFormat)
+	}
+	if quotientBits > quotientReadFreeBits && count < uint64(1)<<(quotientBits-quotientReadSparsity) {
+		return total, fmt.Errorf("%w: %d fingerprints in a table of 2^%d slots", ErrInvalidFormat, count, quotientBits)
+	}
+	// Every code takes at least k+1 bits
+	codeSize := h.payloadSize - quotientPayloadHeaderSize
+	if count > codeSize*8/(uint64(k)+1) {
+		return total, fmt.Errorf("%w: payload too short for %d fingerprints", ErrInvalidFormat, count)
+	}
+	sized, err := checkPayloadSource(r, codeSize)
+	if err != nil {
+		return total, err
+	}
+
+	// The fingerprints are decoded before the table is sized from the header
+	fingerprintBits := quotientBits + remainderBits
+	maxFingerprint := uint64(math.MaxUint64) >> (64 - fingerprintBits)
+	fingerprints := make([]uint64, 0, initialCapacity(count, 8, sized))
+
+	lr := &io.LimitedReader{R: r, N: int64(codeSize)}
+	rr := riceReader{r: bufio.NewReaderSize(lr, payloadChunkSize)}
+	var previous uint64
+	for i := uint64(0); i < count; i++ {
+		q := rr.readUnary(maxFingerprint >> k)
+		delta := q<<k | rr.readBits(uint(k))
+		if rr.err != nil {
+			break
+		}
+		if delta > maxFingerprint-previous {
+			rr.err = fmt.Errorf("%w: fingerprint out of range", ErrInvalidFormat)
+			break
+		}
+		previous += delta
+		fingerprints = append(fingerprints, previous)
+	}
+	read := int64(codeSize) - lr.N - int64(rr.r.Buffered())
+	total += read
+	if rr.err != nil {
+		return total, rr.err
+	}
+	if rr.acc&(1<<rr.n-1) != 0 {
+		return total, fmt.Errorf("%w: nonzero padding", ErrInvalidFormat)
+	}
+	if read != int64(codeSize) {
+		return total, fmt.Errorf("%w: %d unused payload bytes", ErrInvalidFormat, int64(codeSize)-read)
+	}
+
+	filter := newQuotientFilter(quotientBits, remainderBits)
+	filter.build(fingerprints)
+	*qf = *filter
+	return total, nil
+}
+
+// UnmarshalBinary replaces the filter with data produced by MarshalBinary
+func (qf *QuotientFilter) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := qf.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidFormat, r.Len())
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// TestQuotientFilterBasic tests add, contains and delete
+func TestQuotientFilterBasic(t *testing.T) {
+	qf := NewQuotientFilter(10000, 0.001)
+
+	for i := 0; i < 10000; i++ {
+		if !qf.AddString(fmt.Sprintf("quotient_%d", i)) {
+			t.Fatalf("Add failed at element %d", i)
+		}
+	}
+	if qf.Count() != 10000 {
+		t.Errorf("Expected count 10000, got %d", qf.Count())
+	}
+	for i := 0; i < 10000; i++ {
+		if !qf.ContainsString(fmt.Sprintf("quotient_%d", i)) {
+			t.Fatalf("False negative for element %d", i)
+		}
+	}
+
+	for i := 0; i < 5000; i++ {
+		if !qf.DeleteString(fmt.Sprintf("quotient_%d", i)) {
+			t.Fatalf("Delete failed for element %d", i)
+		}
+	}
+	if qf.Count() != 5000 {
+		t.Errorf("Expected count 5000 after deletes, got %d", qf.Count())
+	}
+	for i := 5000; i < 10000; i++ {
+		if !qf.ContainsString(fmt.Sprintf("quotient_%d", i)) {
+			t.Fatalf("Element %d lost after deleting others", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < 100000; i++ {
+		if qf.ContainsString(fmt.Sprintf("absent_%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 100000; rate > 0.002 {
+		t.Errorf("False positive rate %.5f exceeds 0.002", rate)
+	}
+}
+
+// TestQuotientFilterDuplicates tests that each Delete removes one copy
+func TestQuotientFilterDuplicates(t *testing.T) {
+	qf := NewQuotientFilter(100, 0.01)
+	qf.AddString("twice")
+	qf.AddString("twice")
+
+	if !qf.DeleteString("twice") || !qf.ContainsString("twice") {
+		t.Error("Expected one copy to remain after the first delete")
+	}
+	if !qf.DeleteString("twice") || qf.ContainsString("twice") {
+		t.Error("Expected no copies after the second delete")
+	}
+	if qf.DeleteString("twice") {
+		t.Error("Delete of a missing element succeeded")
+	}
+}
+
+// TestQuotientFilterAgainstReference checks the slot layout against a sorted
+// list of fingerprints through random inserts and deletes on a small, nearly
+// full table whose clusters wrap around its end
+func TestQuotientFilterAgainstReference(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	qf := newQuotientFilter(6, 5)
+	var reference []uint64
+
+	for step := 0; step < 20000; step++ {
+		if len(reference) < int(qf.slotCount) && (len(reference) == 0 || rng.Intn(3) != 0) {
+			// Bias quotients towards the end of the table to force wrapping
+			fp := uint64(rng.Intn(1 << 11))
+			if rng.Intn(2) == 0 {
+				fp |= 0x3C << 5
+			}
+			qf.insert(qf.split(fp))
+			i, _ := slices.BinarySearch(reference, fp)
+			reference = slices.Insert(reference, i, fp)
+		} else {
+			i := rng.Intn(len(reference))
+			fp := reference[i]
+			if !qf.deleteFingerprint(fp) {
+				t.Fatalf("Step %d: fingerprint %#x not found for delete", step, fp)
+			}
+			reference = slices.Delete(reference, i, i+1)
+		}
+
+		if got := qf.Fingerprints(); !slices.Equal(got, reference) {
+			t.Fatalf("Step %d: fingerprints %v, expected %v", step, got, reference)
+		}
+		if qf.Count() != uint64(len(reference)) {
+			t.Fatalf("Step %d: count %d, expected %d", step, qf.Count(), len(reference))
+		}
+	}
+}
+
+// TestQuotientFilterResize tests that doubling keeps every element
+func TestQuotientFilterResize(t *testing.T) {
+	qf := NewQuotientFilter(100, 0.001)
+	initialSlots := qf.Capacity()
+	width := qf.QuotientBits() + qf.RemainderBits()
+
+	for i := 0; i < 1000; i++ {
+		qf.AddString(fmt.Sprintf("grow_%d", i))
+	}
+	if qf.Capacity() <= initialSlots {
+		t.Fatalf("Expected the table to grow past %d slots", initialSlots)
+	}
+	if qf.QuotientBits()+qf.RemainderBits() != width {
+		t.Errorf("Fingerprint width changed from %d to %d", width, qf.QuotientBits()+qf.RemainderBits())
+	}
+	if qf.LoadFactor() > quotientMaxLoad {
+		t.Errorf("Load factor %.3f above the growth threshold", qf.LoadFactor())
+	}
+	for i := 0; i < 1000; i++ {
+		if !qf.ContainsString(fmt.Sprintf("grow_%d", i)) {
+			t.Fatalf("False negative after resize for element %d", i)
+		}
+	}
+
+	small := newQuotientFilter(4, 1)
+	if err := small.Resize(); err == nil {
+		t.Error("Expected error resizing a filter with one remainder bit")
+	}
+}
+
+// TestQuotientFilterMerge tests merging filters, including different widths
+func TestQuotientFilterMerge(t *testing.T) {
+	a := NewQuotientFilter(1000, 0.001)
+	b := NewQuotientFilter(5000, 0.01)
+	for i := 0; i < 1000; i++ {
+		a.AddString(fmt.Sprintf("a_%d", i))
+	}
+	for i := 0; i < 5000; i++ {
+		b.AddString(fmt.Sprintf("b_%d", i))
+	}
+	narrow := min(a.QuotientBits()+a.RemainderBits(), b.QuotientBits()+b.RemainderBits())
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count() != 6000 {
+		t.Errorf("Expected count 6000, got %d", a.Count())
+	}
+	if a.QuotientBits()+a.RemainderBits() != narrow {
+		t.Errorf("Expected %d-bit fingerprints after merge, got %d", narrow, a.QuotientBits()+a.RemainderBits())
+	}
+	for i := 0; i < 1000; i++ {
+		if !a.ContainsString(fmt.Sprintf("a_%d", i)) {
+			t.Fatalf("Lost a_%d in merge", i)
+		}
+	}
+	for i := 0; i < 5000; i++ {
+		if !a.ContainsString(fmt.Sprintf("b_%d", i)) {
+			t.Fatalf("Lost b_%d in merge", i)
+		}
+	}
+	if !slices.IsSorted(a.Fingerprints()) {
+		t.Error("Merged fingerprints are not sorted")
+	}
+}
+
+// TestQuotientFilterSerialization tests the binary format round trip
+func TestQuotientFilterSerialization(t *testing.T) {
+	qf := NewQuotientFilter(5000, 0.001)
+	for i := 0; i < 5000; i++ {
+		qf.AddString(fmt.Sprintf("serialize_%d", i))
+	}
+	qf.AddString("serialize_0")
+
+	data, err := qf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded QuotientFilter
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(decoded.Fingerprints(), qf.Fingerprints()) {
+		t.Error("Fingerprints differ after round trip")
+	}
+	if decoded.Capacity() != qf.Capacity() || decoded.RemainderBits() != qf.RemainderBits() {
+		t.Error("Table parameters differ after round trip")
+	}
+	// Rice coding keeps the payload well under the slot array
+	if uint64(len(data)) >= qf.MemoryUsage() {
+		t.Errorf("Serialized size %d not smaller than the %d-byte table", len(data), qf.MemoryUsage())
+	}
+
+	var empty QuotientFilter
+	if err := empty.UnmarshalBinary(marshalQuotient(t, NewQuotientFilter(10, 0.01))); err != nil || empty.Count() != 0 {
+		t.Errorf("Empty filter round trip failed: %v", err)
+	}
+
+	var bf CacheOptimizedBloomFilter
+	if err := bf.UnmarshalBinary(data); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat loading a quotient filter as a bloom filter, got %v", err)
+	}
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("Expected error for truncated data")
+	}
+
+	var buf bytes.Buffer
+	qf.WriteTo(&buf)
+	buf.WriteString("tail")
+	if _, err := decoded.ReadFrom(&buf); err != nil || buf.String() != "tail" {
+		t.Errorf("ReadFrom did not stop at the end of the filter: %v", err)
+	}
+
+	// Headers claiming far more than follows fail before allocating
+	corrupt := func(quotientBits uint8, count, payloadSize uint64) []byte {
+		header := slices.Clone(data[:headerSize+quotientPayloadHeaderSize])
+		binary.LittleEndian.PutUint64(header[40:], payloadSize)
+		header[headerSize] = quotientBits
+		binary.LittleEndian.PutUint64(header[headerSize+8:], count)
+		return header
+	}
+	oversized := corrupt(quotientMaxBits, 1<<40, quotientPayloadHeaderSize+1<<44)
+	for _, r := range []io.Reader{bytes.NewReader(oversized), opaqueReader{bytes.NewReader(oversized)}} {
+		if _, err := decoded.ReadFrom(r); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected io.ErrUnexpectedEOF for an oversized header, got %v", err)
+		}
+	}
+	if err := decoded.UnmarshalBinary(corrupt(quotientMaxBits, 0, quotientPayloadHeaderSize)); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("Expected ErrInvalidFormat for an empty 2^48-slot table, got %v", err)
+	}
+	if !slices.Equal(decoded.Fingerprints(), qf.Fingerprints()) {
+		t.Error("Failed reads modified the filter")
+	}
+}
+
+// marshalQuotient encodes a quotient filter or fails the test
+func marshalQuotient(t *testing.T, qf *QuotientFilter) []byte {
+	t.Helper()
+	data, err := qf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,276 @@
+package bloomfilter
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// Upper bound on the cache lines fetched by one coalesced read (16 KiB)
+const maxCoalescedLines = 256
+
+// ReaderAtStats counts the I/O performed by a ReaderAtFilter
+type ReaderAtStats struct {
+	Reads       uint64 // ReadAt calls for cache lines
+	BytesRead   uint64 // payload bytes fetched
+	CacheHits   uint64 // cache lines served from the LRU cache
+	CacheMisses uint64 // cache lines that had to be read
+}
+
+// ReaderAtFilter queries a filter serialized in the package's binary format
+// behind an io.ReaderAt (slow storage, object stores, large files) without
+// loading it. Only the header is read up front; each query fetches just the
+// 64-byte cache lines it needs, optionally through a bounded LRU cache.
+// It is safe for concurrent use if the underlying ReaderAt is.
+//
+// Filters stored at an offset inside a larger file can be opened through
+// io.NewSectionReader.
+type ReaderAtFilter struct {
+	r              io.ReaderAt
+	bitCount       uint64
+	hashCount      uint32
+	cacheLineCount uint64
+
+	mu    sync.Mutex
+	cache *lineCache // nil when caching is disabled
+	stats ReaderAtStats
+}
+
+// NewReaderAtFilter reads and validates the header from r and returns a
+// filter that fetches cache lines on demand. cacheLines bounds the LRU
+// cache; zero disables caching.
+func NewReaderAtFilter(r io.ReaderAt, cacheLines int) (*ReaderAtFilter, error) {
+	var headerBuf [headerSize]byte
+	if err := readFullAt(r, headerBuf[:], 0); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+	}
+	h, err := decodeHeader(headerBuf[:])
+	if err != nil {
+		return nil, err
+	}
+	if h.compressed() {
+		return nil, ErrCompressed
+	}
+
+	// Probe the last payload byte so truncated files fail here, not on a query
+	var last [1]byte
+	if err := readFullAt(r, last[:], int64(headerSize+h.payloadSize-1)); err != nil {
+		return nil, fmt.Errorf("%w: payload truncated: %v", ErrInvalidFormat, err)
+	}
+
+	f := &ReaderAtFilter{
+		r:              r,
+		bitCount:       h.bitCount,
+		hashCount:      h.hashCount,
+		cacheLineCount: h.cacheLineCount,
+	}
+	if cacheLines > 0 {
+		f.cache = newLineCache(cacheLines)
+	}
+	return f, nil
+}
+
+// Contains checks membership, reading only the cache lines it needs and
+// stopping at the first line that rules the element out
+func (f *ReaderAtFilter) Contains(data []byte) (bool, error) {
+	h1, h2 := hashOptimized1(data), hashOptimized2(data)
+
+	var line CacheLine
+	loaded := f.cacheLineCount // no line loaded yet
+	for i := uint32(0); i < f.hashCount; i++ {
+		bitPos := reduceRange(h1+uint64(i)*h2, f.bitCount)
+		cacheLineIdx := bitPos / BitsPerCacheLine
+		if cacheLineIdx != loaded {
+			var err error
+			if line, err = f.cacheLine(cacheLineIdx); err != nil {
+				return false, err
+			}
+			loaded = cacheLineIdx
+		}
+		if line.words[(bitPos%BitsPerCacheLine)/64]&(1<<(bitPos%64)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ContainsString checks if a string element exists
+func (f *ReaderAtFilter) ContainsString(s string) (bool, error) {
+	return f.Contains([]byte(s))
+}
+
+// ContainsBatch checks multiple elements. The cache lines needed by the whole
+// batch are collected first, and runs of adjacent lines are fetched with a
+// single ReadAt each.
+func (f *ReaderAtFilter) ContainsBatch(items [][]byte) ([]bool, error) {
+	pairs := make([][2]uint64, len(items))
+	var needed []uint64
+	for i, item := range items {
+		h1, h2 := hashOptimized1(item), hashOptimized2(item)
+		pairs[i] = [2]uint64{h1, h2}
+		for j := uint32(0); j < f.hashCount; j++ {
+			needed = append(needed, reduceRange(h1+uint64(j)*h2, f.bitCount)/BitsPerCacheLine)
+		}
+	}
+	slices.Sort(needed)
+	needed = slices.Compact(needed)
+
+	lines := make(map[uint64]CacheLine, len(needed))
+	missing := needed[:0]
+	f.mu.Lock()
+	for _, idx := range needed {
+		if line, ok := f.cachedLine(idx); ok {
+			lines[idx] = line
+		} else {
+			missing = append(missing, idx)
+		}
+	}
+	f.mu.Unlock()
+
+	for start := 0; start < len(missing); {
+		end := start + 1
+		for end < len(missing) && end-start < maxCoalescedLines && missing[end] == missing[end-1]+1 {
+			end++
+		}
+		run, err := f.readCacheLines(missing[start], end-start)
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range run {
+			lines[missing[start]+uint64(i)] = line
+		}
+		start = end
+	}
+
+	results := make([]bool, len(items))
+	for i, pair := range pairs {
+		results[i] = true
+		for j := uint32(0); j < f.hashCount; j++ {
+			bitPos := reduceRange(pair[0]+uint64(j)*pair[1], f.bitCount)
+			line := lines[bitPos/BitsPerCacheLine]
+			if line.words[(bitPos%BitsPerCacheLine)/64]&(1<<(bitPos%64)) == 0 {
+				results[i] = false
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+// Stats returns the I/O counters accumulated so far
+func (f *ReaderAtFilter) Stats() ReaderAtStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats
+}
+
+// cacheLine returns one cache line from the LRU cache or the reader
+func (f *ReaderAtFilter) cacheLine(idx uint64) (CacheLine, error) {
+	f.mu.Lock()
+	line, ok := f.cachedLine(idx)
+	f.mu.Unlock()
+	if ok {
+		return line, nil
+	}
+
+	run, err := f.readCacheLines(idx, 1)
+	if err != nil {
+		return CacheLine{}, err
+	}
+	return run[0], nil
+}
+
+// cachedLine looks a line up in the LRU cache and records the hit or miss; f.mu must be held
+func (f *ReaderAtFilter) cachedLine(idx uint64) (CacheLine, bool) {
+	if f.cache != nil {
+		if line, ok := f.cache.get(idx); ok {
+			f.stats.CacheHits++
+			return line, true
+		}
+	}
+	f.stats.CacheMisses++
+	return CacheLine{}, false
+}
+
+// readCacheLines fetches count consecutive cache lines with one ReadAt and caches them
+func (f *ReaderAtFilter) readCacheLines(first uint64, count int) ([]CacheLine, error) {
+	buf := make([]byte, count*CacheLineSize)
+	if err := readFullAt(f.r, buf, int64(headerSize+first*CacheLineSize)); err != nil {
+		return nil, err
+	}
+	lines := make([]CacheLine, count)
+	decodeCacheLines(lines, buf)
+
+	f.mu.Lock()
+	f.stats.Reads++
+	f.stats.BytesRead += uint64(len(buf))
+	if f.cache != nil {
+		for i, line := range lines {
+			f.cache.put(first+uint64(i), line)
+		}
+	}
+	f.mu.Unlock()
+
+	return lines, nil
+}
+
+// readFullAt reads exactly len(buf) bytes at off, accepting io.EOF on a full read
+func readFullAt(r io.ReaderAt, buf []byte, off int64) error {
+	n, err := r.ReadAt(buf, off)
+	if n == len(buf) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// lineCache is a bounded LRU cache of cache lines keyed by index
+type lineCache struct {
+	capacity int
+	entries  map[uint64]*list.Element
+	order    *list.List // front is most recently used
+}
+
+// cachedLineEntry is the value stored in lineCache.order
+type cachedLineEntry struct {
+	idx  uint64
+	line CacheLine
+}
+
+// newLineCache creates an LRU cache holding at most capacity lines
+func newLineCache(capacity int) *lineCache {
+	return &lineCache{
+		capacity: capacity,
+		entries:  make(map[uint64]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+// get returns a cached line and marks it most recently used
+func (c *lineCache) get(idx uint64) (CacheLine, bool) {
+	elem, ok := c.entries[idx]
+	if !ok {
+		return CacheLine{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cachedLineEntry).line, true
+}
+
+// put stores a line, evicting the least recently used one when full
+func (c *lineCache) put(idx uint64, line CacheLine) {
+	if elem, ok := c.entries[idx]; ok {
+		elem.Value.(*cachedLineEntry).line = line
+		c.order.MoveToFront(elem)
+		return
+	}
+	if c.order.Len() >= c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedLineEntry).idx)
+	}
+	c.entries[idx] = c.order.PushFront(&cachedLineEntry{idx: idx, line: line})
+}
@@ -0,0 +1,148 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+// countingReaderAt counts ReadAt calls made against a byte slice
+type countingReaderAt struct {
+	r     *bytes.Reader
+	reads atomic.Int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads.Add(1)
+	return c.r.ReadAt(p, off)
+}
+
+// newReaderAtFixture builds a populated filter and a counting reader over its encoding
+func newReaderAtFixture(t *testing.T) (*CacheOptimizedBloomFilter, *countingReaderAt) {
+	t.Helper()
+	bf := NewCacheOptimizedBloomFilter(10000, 0.01)
+	for i := 0; i < 5000; i++ {
+		bf.AddString(fmt.Sprintf("readerat_%d", i))
+	}
+	data, _ := bf.MarshalBinary()
+	return bf, &countingReaderAt{r: bytes.NewReader(data)}
+}
+
+// TestReaderAtFilterContains tests on-demand lookups against the in-memory filter
+func TestReaderAtFilterContains(t *testing.T) {
+	bf, reader := newReaderAtFixture(t)
+
+	f, err := NewReaderAtFilter(reader, 0)
+	if err != nil {
+		t.Fatalf("NewReaderAtFilter failed: %v", err)
+	}
+	if reads := reader.reads.Load(); reads != 2 {
+		t.Errorf("Expected only header and probe reads at open, got %d", reads)
+	}
+
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("readerat_%d", i)
+		got, err := f.ContainsString(key)
+		if err != nil {
+			t.Fatalf("Contains failed: %v", err)
+		}
+		if got != bf.ContainsString(key) {
+			t.Fatalf("Contains mismatch for %s", key)
+		}
+	}
+
+	stats := f.Stats()
+	if stats.BytesRead != stats.Reads*CacheLineSize {
+		t.Errorf("Single lookups should read one cache line per ReadAt, got %+v", stats)
+	}
+	if stats.CacheHits != 0 {
+		t.Errorf("Expected no cache hits with caching disabled, got %d", stats.CacheHits)
+	}
+}
+
+// TestReaderAtFilterCache tests the bounded LRU cache
+func TestReaderAtFilterCache(t *testing.T) {
+	_, reader := newReaderAtFixture(t)
+
+	f, err := NewReaderAtFilter(reader, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.ContainsString("readerat_1")
+	reads := reader.reads.Load()
+	f.ContainsString("readerat_1")
+	if reader.reads.Load() != reads {
+		t.Error("Repeated lookup should be served from the cache")
+	}
+	if f.Stats().CacheHits == 0 {
+		t.Error("Expected cache hits to be recorded")
+	}
+
+	for i := 0; i < 1000; i++ {
+		f.ContainsString(fmt.Sprintf("readerat_%d", i))
+	}
+	if n := f.cache.order.Len(); n > 16 || len(f.cache.entries) != n {
+		t.Errorf("Cache exceeded its bound: %d lines, %d entries", n, len(f.cache.entries))
+	}
+}
+
+// TestReaderAtFilterBatch tests that batch lookups coalesce adjacent reads
+func TestReaderAtFilterBatch(t *testing.T) {
+	bf, reader := newReaderAtFixture(t)
+
+	f, err := NewReaderAtFilter(reader, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := make([][]byte, 2000)
+	for i := range items {
+		items[i] = []byte(fmt.Sprintf("readerat_%d", i*3))
+	}
+
+	results, err := f.ContainsBatch(items)
+	if err != nil {
+		t.Fatalf("ContainsBatch failed: %v", err)
+	}
+	for i, item := range items {
+		if results[i] != bf.Contains(item) {
+			t.Fatalf("ContainsBatch mismatch for %s", item)
+		}
+	}
+
+	// A batch this large touches nearly every line, so runs must be merged
+	stats := f.Stats()
+	linesRead := stats.BytesRead / CacheLineSize
+	if stats.Reads*4 > linesRead {
+		t.Errorf("Expected coalesced reads, got %d reads for %d lines", stats.Reads, linesRead)
+	}
+	if linesRead > bf.cacheLineCount {
+		t.Errorf("Read %d lines from a %d line filter", linesRead, bf.cacheLineCount)
+	}
+	t.Logf("Batch of %d keys: %d reads, %d lines", len(items), stats.Reads, linesRead)
+}
+
+// TestReaderAtFilterInvalid tests header validation and truncated payloads
+func TestReaderAtFilterInvalid(t *testing.T) {
+	data, _ := NewCacheOptimizedBloomFilter(1000, 0.01).MarshalBinary()
+
+	for name, buf := range map[string][]byte{
+		"Empty":     nil,
+		"Truncated": data[:len(data)-1],
+	} {
+		if _, err := NewReaderAtFilter(bytes.NewReader(buf), 0); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("%s: expected ErrInvalidFormat, got %v", name, err)
+		}
+	}
+
+	// Filters embedded in a larger file open through a section reader
+	embedded := append(append([]byte("prefix"), data...), "suffix"...)
+	section := io.NewSectionReader(bytes.NewReader(embedded), 6, int64(len(data)))
+	if _, err := NewReaderAtFilter(section, 0); err != nil {
+		t.Errorf("Opening an embedded filter failed: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"runtime"
+	"slices"
+	"unsafe"
+)
+
+// Ribbon construction parameters
+const (
+	// Coefficient bits per equation; one row of the band is a uint64
+	ribbonWidth = 64
+	// Extra slots per key are ribbonOverheadPerLog2 * log2(keys), at least
+	// ribbonMinOverhead, growing by ribbonOverheadStep after each failed seed
+	ribbonMinOverhead     = 0.04
+	ribbonOverheadPerLog2 = 0.0065
+	ribbonOverheadStep    = 0.01
+	// Seeds tried before Build gives up
+	ribbonMaxAttempts = 100
+	// Salt deriving an equation's coefficients from its hash
+	ribbonCoefficientSalt = 0x5851F42D4C957F2D
+)
+
+// RibbonFilter is an immutable standard Ribbon filter (Dillinger and Walzer,
+// 2021) with 64-bit ribbons. Each key maps to a linear equation over GF(2):
+// 64 random coefficients starting at a random slot, and an r-bit fingerprint
+// the XOR of the selected slots must equal. Building solves the banded system;
+// lookups evaluate one equation. The false positive rate is 2^-r; the space
+// overhead over r bits per key grows slowly with the key count, about 10% at
+// 100,000 keys and 15% at ten million.
+//
+// The solution is stored interleaved: each block of 64 slots is r words, word
+// j holding bit j of every slot in the block. An equation spans at most two
+// neighbouring blocks, so Contains reads 2r consecutive words of the 64-byte
+// aligned storage, whole cache lines when r is a multiple of 8.
+type RibbonFilter struct {
+	seed            uint64
+	keyCount        uint64
+	slotCount       uint64 // multiple of 64
+	fingerprintBits uint8
+
+	// Interleaved solution with one trailing block of padding
+	words  []uint64
+	memory *cacheLineMemory
+}
+
+// BuildRibbonFilter builds a filter holding keys with fingerprints of
+// fingerprintBits (1 to 32). Duplicate keys are allowed. Options control the
+// allocation as for NewCacheOptimizedBloomFilter.
+func BuildRibbonFilter(keys [][]byte, fingerprintBits int, opts ...Option) (*RibbonFilter, error) {
+	if fingerprintBits < 1 || fingerprintBits > 32 {
+		return nil, fmt.Errorf("fingerprint size must be between 1 and 32 bits, got %d", fingerprintBits)
+	}
+
+	keyHashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		keyHashes[i] = hashOptimized1(key)
+	}
+	// Equal keys give identical equations, which would waste a row
+	slices.Sort(keyHashes)
+	keyHashes = slices.Compact(keyHashes)
+
+	n := uint64(len(keyHashes))
+	// Standard ribbons need overhead growing with log(n)/w to stay solvable
+	overhead := max(ribbonMinOverhead, ribbonOverheadPerLog2*math.Log2(float64(n+1)))
+	f := &RibbonFilter{
+		keyCount:        n,
+		fingerprintBits: uint8(fingerprintBits),
+	}
+
+	var coefficients []uint64
+	var results []uint32
+	rng := uint64(0x2545F4914F6CDD1D)
+	for attempt := 0; ; attempt++ {
+		if attempt == ribbonMaxAttempts {
+			return nil, fmt.Errorf("%w: no solvable ribbon after %d seeds", ErrBuildFailed, ribbonMaxAttempts)
+		}
+		slots := uint64(math.Ceil(float64(n)*(1+overhead))) + ribbonWidth
+		f.slotCount = (slots + ribbonWidth - 1) / ribbonWidth * ribbonWidth
+		f.seed = splitMix64(&rng)
+
+		coefficients = slices.Grow(coefficients[:0], int(f.slotCount))[:f.slotCount]
+		results = slices.Grow(results[:0], int(f.slotCount))[:f.slotCount]
+		clear(coefficients)
+		clear(results)
+		if f.band(keyHashes, coefficients, results) {
+			break
+		}
+		// A failure suggests the band is too tight; give the next seed more room
+		overhead += ribbonOverheadStep
+	}
+
+	r := uint64(fingerprintBits)
+	wordCount := (f.slotCount/ribbonWidth + 1) * r
+	f.memory = allocateCacheLines((wordCount+WordsPerCacheLine-1)/WordsPerCacheLine, applyOptions(opts))
+	f.words = unsafe.Slice(&f.memory.lines[0].words[0], wordCount)
+	f.solve(coefficients, results)
+	return f, nil
+}
+
+// equation derives the start slot, coefficients and fingerprint of a key.
+// The lowest coefficient is always set, so every equation has a pivot.
+func (f *RibbonFilter) equation(keyHash uint64) (start, coefficients uint64, fingerprint uint32) {
+	hash := fuseMix(keyHash, f.seed)
+	start, _ = bits.Mul64(hash, f.slotCount-ribbonWidth+1)
+	coefficients = fuseMix(hash, ribbonCoefficientSalt) | 1
+	fingerprint = uint32(hash) & (1<<f.fingerprintBits - 1)
+	return start, coefficients, fingerprint
+}
+
+// band adds each key's equation to the banded system by Gaussian
+// elimination on the fly, reporting false if one turns out inconsistent
+func (f *RibbonFilter) band(keyHashes, coefficients []uint64, results []uint32) bool {
+	for _, keyHash := range keyHashes {
+		i, c, result := f.equation(keyHash)
+		for {
+			if coefficients[i] == 0 {
+				coefficients[i] = c
+				results[i] = result
+				break
+			}
+			// Eliminate the pivot and move to the next set coefficient
+			c ^= coefficients[i]
+			result ^= results[i]
+			if c == 0 {
+				if result != 0 {
+					return false
+				}
+				break
+			}
+			shift := uint64(bits.TrailingZeros64(c))
+			i += shift
+			c >>= shift
+		}
+	}
+	return true
+}
+
+// solve back-substitutes the banded system from the last row up, writing the
+// solution into the interleaved blocks. Rows without a pivot are free and
+// left zero.
+func (f *RibbonFilter) solve(coefficients []uint64, results []uint32) {
+	r := int(f.fingerprintBits)
+	// Bit k of state[j] is bit j of slot i+k
+	state := make([]uint64, r)
+	for i := int(f.slotCount) - 1; i >= 0; i-- {
+		block := f.words[i/ribbonWidth*r:]
+		c, result := coefficients[i], results[i]
+		for j := 0; j < r; j++ {
+			s := state[j] << 1
+			bit := uint64(result>>j&1) ^ uint64(bits.OnesCount64(s&c)&1)
+			state[j] = s | bit
+			block[j] |= bit << (i % ribbonWidth)
+		}
+	}
+}
+
+// Contains checks membership. Keys used to build the filter are always
+// found; other keys are found with probability 2^-fingerprintBits.
+func (f *RibbonFilter) Contains(data []byte) bool {
+	start, c, fingerprint := f.equation(hashOptimized1(data))
+	r := uint64(f.fingerprintBits)
+	offset := start % ribbonWidth
+	words := f.words[start/ribbonWidth*r:][:2*r]
+
+	var result uint32
+	for j := uint64(0); j < r; j++ {
+		window := words[j]>>offset | words[r+j]<<(ribbonWidth-offset)
+		result |= uint32(bits.OnesCount64(window&c)&1) << j
+	}
+	runtime.KeepAlive(f)
+	return result == fingerprint
+}
+
+// ContainsString checks if a string element exists
+func (f *RibbonFilter) ContainsString(s string) bool {
+	return f.Contains(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// Len returns the number of distinct keys the filter was built from
+func (f *RibbonFilter) Len() uint64 {
+	return f.keyCount
+}
+
+// FingerprintBits returns the fingerprint size
+func (f *RibbonFilter) FingerprintBits() int {
+	return int(f.fingerprintBits)
+}
+
+// BitsPerKey returns the solution size divided by the number of keys,
+// excluding the padding block
+func (f *RibbonFilter) BitsPerKey() float64 {
+	if f.keyCount == 0 {
+		return 0
+	}
+	return float64(f.slotCount*uint64(f.fingerprintBits)) / float64(f.keyCount)
+}
+
+// EstimatedFPP returns the expected false positive probability
+func (f *RibbonFilter) EstimatedFPP() float64 {
+	return math.Exp2(-float64(f.fingerprintBits))
+}
+
+// MemoryUsage returns the bytes allocated for the solution
+func (f *RibbonFilter) MemoryUsage() uint64 {
+	return f.memory.size
+}
@@ -0,0 +1,81 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"testing"
+	"unsafe"
+)
+
+// TestRibbonFilter tests membership, false positive rate and size across fingerprint widths
+func TestRibbonFilter(t *testing.T) {
+	for _, width := range []int{1, 7, 8, 16, 32} {
+		for _, count := range []int{0, 1, 2, 10, 1000, 100000} {
+			t.Run(fmt.Sprintf("Bits%d/Keys%d", width, count), func(t *testing.T) {
+				keys := fuseTestKeys("ribbon", count)
+				f, err := BuildRibbonFilter(keys, width)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f.Len() != uint64(count) || f.FingerprintBits() != width {
+					t.Errorf("Len %d, FingerprintBits %d", f.Len(), f.FingerprintBits())
+				}
+				for _, key := range keys {
+					if !f.Contains(key) {
+						t.Fatalf("False negative for %q", key)
+					}
+				}
+
+				if count < 1000 {
+					return
+				}
+				const trials = 200000
+				falsePositives := 0
+				for i := 0; i < trials; i++ {
+					if f.ContainsString(fmt.Sprintf("absent_%d", i)) {
+						falsePositives++
+					}
+				}
+				if rate := float64(falsePositives) / trials; rate > 1.2*f.EstimatedFPP()+0.0005 {
+					t.Errorf("False positive rate %.5f, expected about %.5f", rate, f.EstimatedFPP())
+				}
+				if count == 100000 && f.BitsPerKey() > float64(width)*1.15 {
+					t.Errorf("%.2f bits per key for %d-bit fingerprints", f.BitsPerKey(), width)
+				}
+			})
+		}
+	}
+}
+
+// TestRibbonFilterDuplicates tests that repeated keys do not break construction
+func TestRibbonFilterDuplicates(t *testing.T) {
+	keys := append(fuseTestKeys("dup", 500), fuseTestKeys("dup", 500)...)
+	f, err := BuildRibbonFilter(keys, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Len() != 500 {
+		t.Errorf("Expected 500 distinct keys, got %d", f.Len())
+	}
+	for _, key := range keys {
+		if !f.Contains(key) {
+			t.Fatalf("False negative for %q", key)
+		}
+	}
+}
+
+// TestRibbonFilterAlignment tests that the solution starts on a cache line
+func TestRibbonFilterAlignment(t *testing.T) {
+	f, _ := BuildRibbonFilter(fuseTestKeys("align", 1000), 8)
+	if addr := uintptr(unsafe.Pointer(&f.words[0])); addr%CacheLineSize != 0 {
+		t.Errorf("Solution at %#x is not cache line aligned", addr)
+	}
+}
+
+// TestRibbonFilterInvalidWidth tests fingerprint width validation
+func TestRibbonFilterInvalidWidth(t *testing.T) {
+	for _, width := range []int{0, 33} {
+		if _, err := BuildRibbonFilter(fuseTestKeys("w", 10), width); err == nil {
+			t.Errorf("Expected error for %d-bit fingerprints", width)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"unsafe"
+)
+
+// RotatingOptions configures a RotatingBloomFilter
+type RotatingOptions struct {
+	// Sizing of each generation
+	ExpectedElements uint64
+	// False positive rate of Contains across all generations
+	FalsePositiveRate float64
+
+	// Generations is the number of filters kept, at least 2
+	Generations int
+
+	// RotateInterval starts a new generation after this much time; zero
+	// disables time-based rotation
+	RotateInterval time.Duration
+	// RotateAfter starts a new generation once the newest has this many
+	// elements; zero disables count-based rotation
+	RotateAfter uint64
+
+	// Now is the clock used for time-based rotation; time.Now when nil
+	Now func() time.Time
+}
+
+// RotatingBloomFilter remembers elements for a sliding window. It keeps a
+// ring of CacheOptimizedBloomFilter generations: Add inserts into the newest,
+// Contains checks every live generation with a single hash computation, and
+// rotating clears the oldest generation with the SIMD Clear and makes it the
+// newest.
+//
+// With time-based rotation every RotateInterval and N generations, an element
+// is remembered for between N-1 and N intervals; for "seen in the last W"
+// choose RotateInterval = W/(N-1). Rotation happens lazily in Add, and
+// Contains ignores generations that have expired since, so no timer is
+// needed. Like CacheOptimizedBloomFilter it is not safe for concurrent use.
+type RotatingBloomFilter struct {
+	generations []*CacheOptimizedBloomFilter
+	// Index of the newest generation
+	current int
+	// Elements added to the newest generation
+	currentCount uint64
+
+	interval     time.Duration
+	rotateAfter  uint64
+	now          func() time.Time
+	lastRotation time.Time
+}
+
+// NewRotatingBloomFilter creates a rotating filter. Options control the
+// allocation of each generation as for NewCacheOptimizedBloomFilter.
+func NewRotatingBloomFilter(opts RotatingOptions, filterOpts ...Option) (*RotatingBloomFilter, error) {
+	if opts.Generations < 2 {
+		return nil, fmt.Errorf("rotating filter needs at least 2 generations, got %d", opts.Generations)
+	}
+	if opts.FalsePositiveRate <= 0 || opts.FalsePositiveRate >= 1 {
+		return nil, fmt.Errorf("false positive rate must be between 0 and 1, got %g", opts.FalsePositiveRate)
+	}
+	if opts.RotateInterval < 0 {
+		return nil, fmt.Errorf("rotation interval must not be negative, got %v", opts.RotateInterval)
+	}
+
+	// A lookup is a false positive if any generation reports one
+	perGeneration := -math.Expm1(math.Log1p(-opts.FalsePositiveRate) / float64(opts.Generations))
+
+	rf := &RotatingBloomFilter{
+		generations: make([]*CacheOptimizedBloomFilter, opts.Generations),
+		interval:    opts.RotateInterval,
+		rotateAfter: opts.RotateAfter,
+		now:         opts.Now,
+	}
+	if rf.now == nil {
+		rf.now = time.Now
+	}
+	for i := range rf.generations {
+		rf.generations[i] = NewCacheOptimizedBloomFilter(opts.ExpectedElements, perGeneration, filterOpts...)
+	}
+	rf.lastRotation = rf.now()
+	return rf, nil
+}
+
+// dueRotations returns how many intervals have passed since the last rotation
+func (rf *RotatingBloomFilter) dueRotations(now time.Time) int {
+	if rf.interval == 0 {
+		return 0
+	}
+	elapsed := now.Sub(rf.lastRotation)
+	if elapsed < rf.interval {
+		return 0
+	}
+	return int(min(int64(elapsed/rf.interval), int64(len(rf.generations))))
+}
+
+// Rotate clears the oldest generation and makes it the newest
+func (rf *RotatingBloomFilter) Rotate() {
+	rf.current = (rf.current + 1) % len(rf.generations)
+	rf.generations[rf.current].Clear()
+	rf.currentCount = 0
+}
+
+// advance applies the rotations that are due before an insertion
+func (rf *RotatingBloomFilter) advance() {
+	if rf.interval > 0 {
+		now := rf.now()
+		for due := rf.dueRotations(now); due > 0; due-- {
+			rf.Rotate()
+		}
+		// Keep rotations on the interval grid; after a long idle period restart it
+		if elapsed := now.Sub(rf.lastRotation); elapsed >= rf.interval*time.Duration(len(rf.generations)) {
+			rf.lastRotation = now
+		} else {
+			rf.lastRotation = rf.lastRotation.Add(elapsed / rf.interval * rf.interval)
+		}
+	}
+	if rf.rotateAfter > 0 && rf.currentCount >= rf.rotateAfter {
+		rf.Rotate()
+	}
+}
+
+// Add inserts an element into the newest generation, rotating first if due
+func (rf *RotatingBloomFilter) Add(data []byte) {
+	rf.advance()
+	rf.generations[rf.current].addHashPair(hashOptimized1(data), hashOptimized2(data))
+	rf.currentCount++
+}
+
+// Contains reports whether an element was added within the window
+func (rf *RotatingBloomFilter) Contains(data []byte) bool {
+	h1, h2 := hashOptimized1(data), hashOptimized2(data)
+
+	// Generations that a pending rotation would clear are already expired
+	live := len(rf.generations) - rf.dueRotations(rf.now())
+	for i := 0; i < live; i++ {
+		generation := rf.generations[(rf.current-i+len(rf.generations))%len(rf.generations)]
+		if generation.containsHashPair(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddString adds a string element
+func (rf *RotatingBloomFilter) AddString(s string) {
+	rf.Add(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// ContainsString checks if a string element was added within the window
+func (rf *RotatingBloomFilter) ContainsString(s string) bool {
+	return rf.Contains(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// Generations returns the number of generations kept
+func (rf *RotatingBloomFilter) Generations() int {
+	return len(rf.generations)
+}
+
+// CurrentCount returns the number of elements added to the newest generation
+func (rf *RotatingBloomFilter) CurrentCount() uint64 {
+	return rf.currentCount
+}
+
+// EstimatedFPP estimates the false positive probability of Contains from the
+// load of every generation
+func (rf *RotatingBloomFilter) EstimatedFPP() float64 {
+	miss := 1.0
+	for _, generation := range rf.generations {
+		miss *= 1 - generation.EstimatedFPP()
+	}
+	return 1 - miss
+}
+
+// MemoryUsage returns the bytes allocated for all generations
+func (rf *RotatingBloomFilter) MemoryUsage() uint64 {
+	var total uint64
+	for _, generation := range rf.generations {
+		total += generation.memory.size
+	}
+	return total
+}
+
+// Clear empties every generation and restarts the rotation clock
+func (rf *RotatingBloomFilter) Clear() {
+	for _, generation := range rf.generations {
+		generation.Clear()
+	}
+	rf.currentCount = 0
+	rf.lastRotation = rf.now()
+}
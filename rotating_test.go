@@ -0,0 +1,103 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for rotation tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+// TestRotatingBloomFilterTime tests the sliding window with time-based rotation
+func TestRotatingBloomFilterTime(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	rf, err := NewRotatingBloomFilter(RotatingOptions{
+		ExpectedElements:  10000,
+		FalsePositiveRate: 0.01,
+		Generations:       3,
+		RotateInterval:    5 * time.Minute,
+		Now:               clock.now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rf.AddString("early")
+	clock.t = clock.t.Add(6 * time.Minute)
+	rf.AddString("later")
+	if !rf.ContainsString("early") || !rf.ContainsString("later") {
+		t.Fatal("Elements lost within the window")
+	}
+
+	// Two more intervals expire the first generation, even without an Add
+	clock.t = clock.t.Add(10 * time.Minute)
+	if rf.ContainsString("early") {
+		t.Error("Element still present after its generation expired")
+	}
+	if !rf.ContainsString("later") {
+		t.Error("Element expired too early")
+	}
+
+	// After a long idle period everything is gone
+	clock.t = clock.t.Add(time.Hour)
+	if rf.ContainsString("later") {
+		t.Error("Element present after the whole window passed")
+	}
+	rf.AddString("fresh")
+	if !rf.ContainsString("fresh") || rf.ContainsString("later") {
+		t.Error("Unexpected contents after rotating through every generation")
+	}
+}
+
+// TestRotatingBloomFilterCount tests rotation by insertion count
+func TestRotatingBloomFilterCount(t *testing.T) {
+	rf, err := NewRotatingBloomFilter(RotatingOptions{
+		ExpectedElements:  1000,
+		FalsePositiveRate: 0.01,
+		Generations:       4,
+		RotateAfter:       1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5000; i++ {
+		rf.AddString(fmt.Sprintf("count_%d", i))
+	}
+	if rf.CurrentCount() != 1000 {
+		t.Errorf("Expected 1000 elements in the newest generation, got %d", rf.CurrentCount())
+	}
+	// The last four generations hold elements 1000..4999
+	for i := 1000; i < 5000; i++ {
+		if !rf.ContainsString(fmt.Sprintf("count_%d", i)) {
+			t.Fatalf("False negative for live element %d", i)
+		}
+	}
+	present := 0
+	for i := 0; i < 1000; i++ {
+		if rf.ContainsString(fmt.Sprintf("count_%d", i)) {
+			present++
+		}
+	}
+	if present > 50 {
+		t.Errorf("%d of 1000 expired elements still present", present)
+	}
+	if fpp := rf.EstimatedFPP(); fpp > 0.02 {
+		t.Errorf("Estimated false positive rate %.4f above target", fpp)
+	}
+}
+
+// TestRotatingBloomFilterOptions tests option validation
+func TestRotatingBloomFilterOptions(t *testing.T) {
+	if _, err := NewRotatingBloomFilter(RotatingOptions{ExpectedElements: 10, FalsePositiveRate: 0.01, Generations: 1}); err == nil {
+		t.Error("Expected error for a single generation")
+	}
+	if _, err := NewRotatingBloomFilter(RotatingOptions{ExpectedElements: 10, FalsePositiveRate: 1, Generations: 2}); err == nil {
+		t.Error("Expected error for a false positive rate of 1")
+	}
+}
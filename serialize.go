@@ -0,0 +1,450 @@
+package bloomfilter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"unsafe"
+)
+
+/*
+Binary format
+
+Every serialized filter starts with a fixed 64-byte little-endian header so the
+cache line payload that follows it stays 64-byte aligned when the data is
+memory mapped:
+
+	offset  size  field
+	0       4     magic "BLMF"
+	4       2     format version
+	6       2     flags
+	8       4     hash count
+	12      4     header size (64)
+	16      8     bit count
+	24      8     cache line count
+	32      8     generation (shared-memory filters, 0 otherwise)
+	40      8     payload size in bytes
+	48      8     set bit count (compressed payloads, 0 otherwise)
+	56      1     Rice parameter (compressed payloads, 0 otherwise)
+	57      1     filter kind (0 for bloom filters)
+	58      6     reserved, zero
+
+The payload is the cache line array, each uint64 word stored little-endian.
+With the compressed flag set it is instead the Golomb-Rice coded gaps between
+set bits (see compress.go).
+
+Other filter kinds share the magic, version, header size and payload size
+fields, leave the bloom filter fields zero and describe themselves in their
+payload.
+*/
+
+const (
+	// Size of the serialized header; keeps the payload cache line aligned
+	headerSize = CacheLineSize
+	// Current binary format version
+	formatVersion = 1
+)
+
+// Header flags
+const (
+	// The payload holds Golomb-Rice coded set-bit gaps
+	flagCompressed = 1 << 0
+
+	knownFlags = flagCompressed
+)
+
+// Filter kinds stored in the header
+const (
+	filterKindBloom      = 0
+	filterKindBinaryFuse = 1
+	filterKindQuotient   = 2
+	filterKindCascade    = 3
+	filterKindIBLT       = 4
+)
+
+// Magic bytes identifying a serialized filter
+var formatMagic = [4]byte{'B', 'L', 'M', 'F'}
+
+var (
+	// ErrInvalidFormat is returned when serialized data is not a valid filter
+	ErrInvalidFormat = errors.New("invalid serialized bloom filter")
+	// ErrUnsupportedVersion is returned for data written by a newer format version
+	ErrUnsupportedVersion = errors.New("unsupported bloom filter format version")
+	// ErrCompressed is returned when a compressed filter is opened by something
+	// that needs the raw cache line payload, such as OpenMapped or NewFilterView
+	ErrCompressed = errors.New("bloom filter is compressed; decode it with ReadFrom or UnmarshalBinary")
+)
+
+// filterHeader is the decoded form of the fixed-size header
+type filterHeader struct {
+	version        uint16
+	flags          uint16
+	hashCount      uint32
+	bitCount       uint64
+	cacheLineCount uint64
+	generation     uint64
+	payloadSize    uint64
+	setBits        uint64
+	riceParam      uint8
+	kind           uint8
+}
+
+// Offset of the generation counter within the header
+const headerGenerationOffset = 32
+
+// encode writes the header into buf, which must hold at least headerSize bytes
+func (h *filterHeader) encode(buf []byte) {
+	copy(buf[0:4], formatMagic[:])
+	binary.LittleEndian.PutUint16(buf[4:], h.version)
+	binary.LittleEndian.PutUint16(buf[6:], h.flags)
+	binary.LittleEndian.PutUint32(buf[8:], h.hashCount)
+	binary.LittleEndian.PutUint32(buf[12:], headerSize)
+	binary.LittleEndian.PutUint64(buf[16:], h.bitCount)
+	binary.LittleEndian.PutUint64(buf[24:], h.cacheLineCount)
+	binary.LittleEndian.PutUint64(buf[headerGenerationOffset:], h.generation)
+	binary.LittleEndian.PutUint64(buf[40:], h.payloadSize)
+	binary.LittleEndian.PutUint64(buf[48:], h.setBits)
+	buf[56] = h.riceParam
+	buf[57] = h.kind
+	clear(buf[58:headerSize])
+}
+
+// compressed reports whether the payload is Golomb-Rice coded
+func (h *filterHeader) compressed() bool {
+	return h.flags&flagCompressed != 0
+}
+
+// decodeHeader parses and validates a bloom filter header
+func decodeHeader(buf []byte) (filterHeader, error) {
+	h, err := parseHeader(buf)
+	if err != nil {
+		return filterHeader{}, err
+	}
+	if h.kind != filterKindBloom {
+		return filterHeader{}, fmt.Errorf("%w: filter kind %d is not a bloom filter", ErrInvalidFormat, h.kind)
+	}
+
+	if h.hashCount == 0 || h.cacheLineCount == 0 || h.bitCount != h.cacheLineCount*BitsPerCacheLine {
+		return filterHeader{}, fmt.Errorf("%w: inconsistent parameters", ErrInvalidFormat)
+	}
+	if h.hashCount > maxHashCount {
+		return filterHeader{}, fmt.Errorf("%w: %d hash functions", ErrInvalidFormat, h.hashCount)
+	}
+	if h.cacheLineCount > maxCacheLines {
+		return filterHeader{}, fmt.Errorf("%w: filter too large", ErrInvalidFormat)
+	}
+	if h.compressed() {
+		// A compressed payload is only written when smaller than the raw one,
+		// and every code takes at least riceParam+1 bits
+		if h.setBits > h.bitCount || h.riceParam >= 64 || h.payloadSize > h.cacheLineCount*CacheLineSize ||
+			h.setBits > h.payloadSize*8/(uint64(h.riceParam)+1) {
+			return filterHeader{}, fmt.Errorf("%w: inconsistent compression parameters", ErrInvalidFormat)
+		}
+	} else if h.payloadSize != h.cacheLineCount*CacheLineSize {
+		return filterHeader{}, fmt.Errorf("%w: payload size %d", ErrInvalidFormat, h.payloadSize)
+	}
+
+	return h, nil
+}
+
+// parseHeader parses a header and validates the fields shared by every filter kind
+func parseHeader(buf []byte) (filterHeader, error) {
+	if len(buf) < headerSize {
+		return filterHeader{}, fmt.Errorf("%w: header truncated", ErrInvalidFormat)
+	}
+	if [4]byte(buf[0:4]) != formatMagic {
+		return filterHeader{}, fmt.Errorf("%w: bad magic", ErrInvalidFormat)
+	}
+
+	h := filterHeader{
+		version:        binary.LittleEndian.Uint16(buf[4:]),
+		flags:          binary.LittleEndian.Uint16(buf[6:]),
+		hashCount:      binary.LittleEndian.Uint32(buf[8:]),
+		bitCount:       binary.LittleEndian.Uint64(buf[16:]),
+		cacheLineCount: binary.LittleEndian.Uint64(buf[24:]),
+		generation:     binary.LittleEndian.Uint64(buf[headerGenerationOffset:]),
+		payloadSize:    binary.LittleEndian.Uint64(buf[40:]),
+		setBits:        binary.LittleEndian.Uint64(buf[48:]),
+		riceParam:      buf[56],
+		kind:           buf[57],
+	}
+
+	if h.version > formatVersion {
+		return filterHeader{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.version)
+	}
+	if size := binary.LittleEndian.Uint32(buf[12:]); size != headerSize {
+		return filterHeader{}, fmt.Errorf("%w: header size %d", ErrInvalidFormat, size)
+	}
+	if h.flags&^knownFlags != 0 {
+		return filterHeader{}, fmt.Errorf("%w: unknown flags %#x", ErrUnsupportedVersion, h.flags)
+	}
+
+	return h, nil
+}
+
+// header returns the serialized header describing this filter
+func (bf *CacheOptimizedBloomFilter) header() filterHeader {
+	return filterHeader{
+		version:        formatVersion,
+		hashCount:      bf.hashCount,
+		bitCount:       bf.bitCount,
+		cacheLineCount: bf.cacheLineCount,
+		payloadSize:    bf.cacheLineCount * CacheLineSize,
+	}
+}
+
+// hostLittleEndian reports whether cache line words are already in payload
+// byte order in memory, so they can be written and mapped without conversion
+var hostLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// cacheLineBytes returns the raw bytes of a cache line array without copying
+func cacheLineBytes(lines []CacheLine) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&lines[0])), len(lines)*CacheLineSize)
+}
+
+// MarshalBinary encodes the filter in the package's binary format
+func (bf *CacheOptimizedBloomFilter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, headerSize+bf.cacheLineCount*CacheLineSize)
+	h := bf.header()
+	h.encode(buf)
+	encodeCacheLines(buf[headerSize:], bf.cacheLines)
+	runtime.KeepAlive(bf)
+	return buf, nil
+}
+
+// UnmarshalBinary replaces the filter's contents with data produced by
+// MarshalBinary or MarshalCompressed
+func (bf *CacheOptimizedBloomFilter) UnmarshalBinary(data []byte) error {
+	h, err := decodeHeader(data)
+	if err != nil {
+		return err
+	}
+	if h.compressed() {
+		return bf.unmarshalCompressed(h, data[headerSize:])
+	}
+	if uint64(len(data)-headerSize) != h.payloadSize {
+		return fmt.Errorf("%w: payload is %d bytes, expected %d", ErrInvalidFormat, len(data)-headerSize, h.payloadSize)
+	}
+
+	filter := newFilterWithMemory(h.cacheLineCount, h.hashCount, allocateCacheLines(h.cacheLineCount, filterOptions{}))
+	decodeCacheLines(filter.cacheLines, data[headerSize:])
+	*bf = *filter
+	return nil
+}
+
+// Chunk size used when converting payload words on big-endian hosts
+const payloadChunkSize = 64 << 10
+
+// Limits on what a header may ask to be allocated
+const (
+	// Largest payload whose storage is allocated before it is read; larger
+	// ones must first be shown to exist in the source
+	directReadLimit = 1 << 20
+	// Largest filter the Go runtime can allocate
+	maxCacheLines = min(math.MaxInt, 1<<48) / CacheLineSize
+)
+
+// checkPayloadSource fails with io.ErrUnexpectedEOF when r is known to hold
+// fewer than size bytes, since a header alone is not trusted. It reports
+// whether the length of r was known; when it was not, callers allocate as
+// the payload arrives.
+func checkPayloadSource(r io.Reader, size uint64) (sized bool, err error) {
+	if size <= directReadLimit {
+		return true, nil
+	}
+	remaining, ok := sourceRemaining(r)
+	if !ok {
+		return false, nil
+	}
+	if remaining < 0 || uint64(remaining) < size {
+		return true, io.ErrUnexpectedEOF
+	}
+	return true, nil
+}
+
+// initialCapacity returns how many of count items of itemSize bytes to
+// allocate before any arrive: all of them from a sized source, otherwise
+// at most directReadLimit bytes' worth
+func initialCapacity(count, itemSize uint64, sized bool) uint64 {
+	if sized {
+		return count
+	}
+	return min(count, max(directReadLimit/itemSize, 1))
+}
+
+// readGrowing reads size bytes of r into storage obtained from alloc, which
+// returns a buffer of at least n bytes. From a sized source storage is
+// allocated once; otherwise it starts at directReadLimit and doubles as data
+// arrives, each buffer copied into the next, so a truncated payload never
+// costs much more than the bytes it delivered. The buffer of the last call,
+// made with n == size, holds the payload.
+func readGrowing(r io.Reader, size uint64, sized bool, alloc func(n uint64) []byte) (int64, error) {
+	n := size
+	if !sized {
+		n = min(size, directReadLimit)
+	}
+
+	var total int64
+	var prev []byte
+	for {
+		buf := alloc(n)[:n]
+		copy(buf, prev)
+		read, err := io.ReadFull(r, buf[len(prev):])
+		total += int64(read)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return total, err
+		}
+		if n == size {
+			return total, nil
+		}
+		prev, n = buf, min(2*n, size)
+	}
+}
+
+// sourceRemaining returns the number of bytes left in r when it can be known
+// without reading: in-memory readers, regular files and limited readers over
+// either
+func sourceRemaining(r io.Reader) (int64, bool) {
+	switch s := r.(type) {
+	case interface{ Len() int }:
+		return int64(s.Len()), true
+	case *io.LimitedReader:
+		if n, ok := sourceRemaining(s.R); ok {
+			return min(n, s.N), true
+		}
+	case *os.File:
+		info, err := s.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 0, false
+		}
+		offset, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		return info.Size() - offset, true
+	}
+	return 0, false
+}
+
+// WriteTo writes the filter in the package's binary format, implementing io.WriterTo
+func (bf *CacheOptimizedBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	var headerBuf [headerSize]byte
+	h := bf.header()
+	h.encode(headerBuf[:])
+
+	n, err := w.Write(headerBuf[:])
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+
+	if hostLittleEndian {
+		n, err = w.Write(cacheLineBytes(bf.cacheLines))
+		runtime.KeepAlive(bf)
+		return total + int64(n), err
+	}
+
+	chunk := make([]byte, payloadChunkSize)
+	linesPerChunk := payloadChunkSize / CacheLineSize
+	for start := 0; start < len(bf.cacheLines); start += linesPerChunk {
+		lines := bf.cacheLines[start:min(start+linesPerChunk, len(bf.cacheLines))]
+		encodeCacheLines(chunk, lines)
+		n, err = w.Write(chunk[:len(lines)*CacheLineSize])
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// ReadFrom replaces the filter's contents with one filter read from r in the
+// package's binary format, implementing io.ReaderFrom. Compressed filters are
+// decoded as they are read. It reads exactly the serialized filter and leaves
+// any following data unread. A header is not trusted for allocation: a
+// source known to be short fails with io.ErrUnexpectedEOF up front, and from
+// other sources storage grows only as the payload arrives. Note that a short
+// but complete compressed payload can legitimately describe a large, mostly
+// empty filter.
+func (bf *CacheOptimizedBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	var headerBuf [headerSize]byte
+	n, err := io.ReadFull(r, headerBuf[:])
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+
+	h, err := decodeHeader(headerBuf[:])
+	if err != nil {
+		return total, err
+	}
+	n64, err := bf.readPayloadFrom(h, r)
+	return total + n64, err
+}
+
+// readPayloadFrom replaces the filter with the payload following header h
+func (bf *CacheOptimizedBloomFilter) readPayloadFrom(h filterHeader, r io.Reader) (int64, error) {
+	if h.compressed() {
+		return bf.readCompressedFrom(h, r)
+	}
+	sized, err := checkPayloadSource(r, h.payloadSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var memory *cacheLineMemory
+	total, err := readGrowing(r, h.payloadSize, sized, func(n uint64) []byte {
+		memory = allocateCacheLines(n/CacheLineSize, filterOptions{})
+		return cacheLineBytes(memory.lines)
+	})
+	if err != nil {
+		return total, err
+	}
+
+	filter := newFilterWithMemory(h.cacheLineCount, h.hashCount, memory)
+	if !hostLittleEndian {
+		// The words were read in payload byte order; convert them in place
+		decodeCacheLines(filter.cacheLines, cacheLineBytes(filter.cacheLines))
+	}
+	*bf = *filter
+	return total, nil
+}
+
+// encodeCacheLines writes cache line words little-endian into dst
+func encodeCacheLines(dst []byte, lines []CacheLine) {
+	if hostLittleEndian {
+		copy(dst, cacheLineBytes(lines))
+		return
+	}
+	for i := range lines {
+		for j, word := range lines[i].words {
+			binary.LittleEndian.PutUint64(dst[i*CacheLineSize+j*8:], word)
+		}
+	}
+}
+
+// decodeCacheLines reads little-endian cache line words from src
+func decodeCacheLines(lines []CacheLine, src []byte) {
+	if hostLittleEndian {
+		copy(cacheLineBytes(lines), src)
+		return
+	}
+	for i := range lines {
+		for j := range lines[i].words {
+			lines[i].words[j] = binary.LittleEndian.Uint64(src[i*CacheLineSize+j*8:])
+		}
+	}
+}
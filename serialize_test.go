@@ -0,0 +1,202 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBinaryRoundTrip tests MarshalBinary/UnmarshalBinary and WriteTo/ReadFrom
+func TestBinaryRoundTrip(t *testing.T) {
+	bf := NewCacheOptimizedBloomFilter(5000, 0.01)
+	for i := 0; i < 1000; i++ {
+		bf.AddString(fmt.Sprintf("serialize_%d", i))
+	}
+
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if uint64(len(data)) != headerSize+bf.cacheLineCount*CacheLineSize {
+		t.Errorf("Unexpected serialized size %d", len(data))
+	}
+
+	var decoded CacheOptimizedBloomFilter
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	assertSameFilter(t, bf, &decoded)
+
+	var buf bytes.Buffer
+	n, err := bf.WriteTo(&buf)
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("WriteTo wrote %d bytes (err=%v), expected %d", n, err, len(data))
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("WriteTo and MarshalBinary produced different output")
+	}
+
+	// Trailing data must be left unread
+	buf.WriteString("trailer")
+	var read CacheOptimizedBloomFilter
+	if n, err := read.ReadFrom(&buf); err != nil || n != int64(len(data)) {
+		t.Fatalf("ReadFrom read %d bytes (err=%v), expected %d", n, err, len(data))
+	}
+	assertSameFilter(t, bf, &read)
+	if rest, _ := io.ReadAll(&buf); string(rest) != "trailer" {
+		t.Errorf("ReadFrom consumed trailing data, %q left", rest)
+	}
+
+	// The decoded filter must be fully usable
+	read.AddString("after_decode")
+	if !read.ContainsString("after_decode") {
+		t.Error("Decoded filter lost a new element")
+	}
+}
+
+// TestBinaryInvalidInput tests that malformed data is rejected
+func TestBinaryInvalidInput(t *testing.T) {
+	bf := NewCacheOptimizedBloomFilter(100, 0.01)
+	data, _ := bf.MarshalBinary()
+
+	corrupt := func(mutate func([]byte) []byte) []byte {
+		return mutate(append([]byte{}, data...))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"Empty", nil, ErrInvalidFormat},
+		{"ShortHeader", data[:10], ErrInvalidFormat},
+		{"BadMagic", corrupt(func(b []byte) []byte { b[0] = 'X'; return b }), ErrInvalidFormat},
+		{"FutureVersion", corrupt(func(b []byte) []byte { b[4] = 99; return b }), ErrUnsupportedVersion},
+		{"ZeroHashCount", corrupt(func(b []byte) []byte { clear(b[8:12]); return b }), ErrInvalidFormat},
+		{"TooManyHashes", corrupt(func(b []byte) []byte { b[8] = maxHashCount + 1; return b }), ErrInvalidFormat},
+		{"BadBitCount", corrupt(func(b []byte) []byte { b[16]++; return b }), ErrInvalidFormat},
+		{"TruncatedPayload", data[:len(data)-1], ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var decoded CacheOptimizedBloomFilter
+			if err := decoded.UnmarshalBinary(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("UnmarshalBinary: expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	var decoded CacheOptimizedBloomFilter
+	if _, err := decoded.ReadFrom(bytes.NewReader(data[:len(data)-1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadFrom on truncated payload: expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+// opaqueReader hides the length of the reader it wraps
+type opaqueReader struct {
+	r io.Reader
+}
+
+func (o opaqueReader) Read(p []byte) (int, error) { return o.r.Read(p) }
+
+// TestReadFromOversizedHeader tests that a header claiming far more payload
+// than the source holds fails without allocating storage for it
+func TestReadFromOversizedHeader(t *testing.T) {
+	const lines = min(1<<40, maxCacheLines)
+	raw := make([]byte, headerSize)
+	(&filterHeader{
+		version:        formatVersion,
+		hashCount:      7,
+		bitCount:       lines * BitsPerCacheLine,
+		cacheLineCount: lines,
+		payloadSize:    lines * CacheLineSize,
+	}).encode(raw)
+	compressed := make([]byte, headerSize)
+	(&filterHeader{
+		version:        formatVersion,
+		flags:          flagCompressed,
+		hashCount:      7,
+		bitCount:       lines * BitsPerCacheLine,
+		cacheLineCount: lines,
+		payloadSize:    lines * CacheLineSize / 2,
+		setBits:        1 << 20,
+		riceParam:      20,
+	}).encode(compressed)
+
+	sources := map[string]func([]byte) io.Reader{
+		"Sized":   func(b []byte) io.Reader { return bytes.NewReader(b) },
+		"Unsized": func(b []byte) io.Reader { return opaqueReader{bytes.NewReader(append(b, make([]byte, 100)...))} },
+	}
+	for name, source := range sources {
+		for kind, header := range map[string][]byte{"Raw": raw, "Compressed": compressed} {
+			var bf CacheOptimizedBloomFilter
+			if _, err := bf.ReadFrom(source(header)); err != io.ErrUnexpectedEOF {
+				t.Errorf("%s %s: expected io.ErrUnexpectedEOF, got %v", name, kind, err)
+			}
+			var af AdaptiveBloomFilter
+			if _, err := af.ReadFrom(source(header)); err != io.ErrUnexpectedEOF {
+				t.Errorf("%s %s adaptive: expected io.ErrUnexpectedEOF, got %v", name, kind, err)
+			}
+		}
+	}
+}
+
+// TestReadFromLargeUnsized tests reading a filter larger than the direct
+// read limit from a source of unknown length, and from a file
+func TestReadFromLargeUnsized(t *testing.T) {
+	bf := NewCacheOptimizedBloomFilter(3000000, 0.01)
+	for i := 0; i < 1000; i++ {
+		bf.AddString(fmt.Sprintf("large_%d", i))
+	}
+	data, _ := bf.MarshalBinary()
+	if len(data) <= 2*directReadLimit {
+		t.Fatalf("Filter of %d bytes does not need several growth steps", len(data))
+	}
+
+	var decoded CacheOptimizedBloomFilter
+	if n, err := decoded.ReadFrom(opaqueReader{bytes.NewReader(data)}); err != nil || n != int64(len(data)) {
+		t.Fatalf("ReadFrom read %d bytes (err=%v), expected %d", n, err, len(data))
+	}
+	assertSameFilter(t, bf, &decoded)
+
+	// Truncation within a later growth step is still reported
+	truncated := data[:len(data)-1000]
+	if n, err := decoded.ReadFrom(opaqueReader{bytes.NewReader(truncated)}); err != io.ErrUnexpectedEOF || n != int64(len(truncated)) {
+		t.Errorf("Expected io.ErrUnexpectedEOF after %d bytes, got %v after %d", len(truncated), err, n)
+	}
+	assertSameFilter(t, bf, &decoded)
+
+	path := filepath.Join(t.TempDir(), "large.bloom")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var fromFile CacheOptimizedBloomFilter
+	if _, err := fromFile.ReadFrom(file); err != nil {
+		t.Fatal(err)
+	}
+	assertSameFilter(t, bf, &fromFile)
+}
+
+// assertSameFilter fails the test if two filters differ in parameters or bits
+func assertSameFilter(t *testing.T, want, got *CacheOptimizedBloomFilter) {
+	t.Helper()
+	if want.bitCount != got.bitCount || want.hashCount != got.hashCount || want.cacheLineCount != got.cacheLineCount {
+		t.Fatalf("Parameters differ: bits %d/%d, hashes %d/%d, lines %d/%d",
+			want.bitCount, got.bitCount, want.hashCount, got.hashCount, want.cacheLineCount, got.cacheLineCount)
+	}
+	for i := range want.cacheLines {
+		if want.cacheLines[i] != got.cacheLines[i] {
+			t.Fatalf("Cache line %d differs", i)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package bloomfilter
+
+import (
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// SharedOptions configures OpenShared and OpenSharedFile
+type SharedOptions struct {
+	// Sizing used by the process that creates the filter; ignored when
+	// attaching to an existing one, whose parameters come from the header
+	ExpectedElements  uint64
+	FalsePositiveRate float64
+}
+
+// SharedBloomFilter is a filter mapped into several processes at once.
+//
+// It uses the same file layout as OpenMapped, but every bit is set with an
+// atomic OR and read with an atomic load, so any number of processes and
+// goroutines may Add and Contains concurrently. The header's generation
+// counter is bumped around every Clear: it is odd while a Clear is in
+// progress, and readers can compare Generation before and after a series of
+// queries to detect that the filter was reset underneath them.
+type SharedBloomFilter struct {
+	mapped     *MappedBloomFilter
+	lines      []CacheLine
+	bitCount   uint64
+	hashCount  uint32
+	generation *uint64 // generation counter inside the mapped header
+	// Serializes Clear between goroutines, which share one file lock
+	clearMu sync.Mutex
+
+	// Identity of the file at open time, used to detect replacement
+	path string
+	info os.FileInfo
+}
+
+// SharedMemoryPath returns the conventional location for a shared filter:
+// /dev/shm on Linux, so the filter lives in memory, and the temporary
+// directory elsewhere
+func SharedMemoryPath(name string) string {
+	if runtime.GOOS == "linux" {
+		return filepath.Join("/dev/shm", name)
+	}
+	return filepath.Join(os.TempDir(), name)
+}
+
+// OpenShared creates or attaches to the shared filter at path. Creation and
+// header validation happen under an exclusive file lock, so processes
+// racing to open the same path all see one fully initialized filter.
+func OpenShared(path string, opts SharedOptions) (*SharedBloomFilter, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := OpenSharedFile(file, opts)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.path = path
+	return s, nil
+}
+
+// OpenSharedFile attaches to a shared filter through an already open,
+// read-write file, such as a memfd_create descriptor inherited through
+// exec.Cmd.ExtraFiles. The filter takes ownership of the file.
+func OpenSharedFile(file *os.File, opts SharedOptions) (*SharedBloomFilter, error) {
+	if !hostLittleEndian {
+		return nil, ErrMappingUnsupported
+	}
+
+	if err := lockFile(file); err != nil {
+		return nil, err
+	}
+	mapped, err := mapFilterFile(file, MappedOptions{
+		ExpectedElements:  opts.ExpectedElements,
+		FalsePositiveRate: opts.FalsePositiveRate,
+	})
+	unlockErr := unlockFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if unlockErr != nil {
+		mapped.Close()
+		return nil, unlockErr
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		mapped.Close()
+		return nil, err
+	}
+
+	return &SharedBloomFilter{
+		mapped:     mapped,
+		lines:      mapped.cacheLines,
+		bitCount:   mapped.bitCount,
+		hashCount:  mapped.hashCount,
+		generation: (*uint64)(unsafe.Pointer(&mapped.data[headerGenerationOffset])),
+		info:       info,
+	}, nil
+}
+
+// Add atomically adds an element
+func (s *SharedBloomFilter) Add(data []byte) {
+	s.addHash(hashOptimized1(data), hashOptimized2(data))
+}
+
+// Contains checks membership using atomic loads
+func (s *SharedBloomFilter) Contains(data []byte) bool {
+	return s.containsHash(hashOptimized1(data), hashOptimized2(data))
+}
+
+// AddString atomically adds a string element
+func (s *SharedBloomFilter) AddString(str string) {
+	s.Add(unsafe.Slice(unsafe.StringData(str), len(str)))
+}
+
+// ContainsString checks if a string element exists
+func (s *SharedBloomFilter) ContainsString(str string) bool {
+	return s.Contains(unsafe.Slice(unsafe.StringData(str), len(str)))
+}
+
+// AddUint64 atomically adds a uint64 element
+func (s *SharedBloomFilter) AddUint64(n uint64) {
+	s.Add((*[8]byte)(unsafe.Pointer(&n))[:])
+}
+
+// ContainsUint64 checks if a uint64 element exists
+func (s *SharedBloomFilter) ContainsUint64(n uint64) bool {
+	return s.Contains((*[8]byte)(unsafe.Pointer(&n))[:])
+}
+
+// addHash sets the bits for a hash pair with atomic OR
+func (s *SharedBloomFilter) addHash(h1, h2 uint64) {
+	for i := uint32(0); i < s.hashCount; i++ {
+		bitPos := reduceRange(h1+uint64(i)*h2, s.bitCount)
+		word := &s.lines[bitPos/BitsPerCacheLine].words[(bitPos%BitsPerCacheLine)/64]
+		atomic.OrUint64(word, 1<<(bitPos%64))
+	}
+}
+
+// containsHash tests the bits for a hash pair with atomic loads
+func (s *SharedBloomFilter) containsHash(h1, h2 uint64) bool {
+	for i := uint32(0); i < s.hashCount; i++ {
+		bitPos := reduceRange(h1+uint64(i)*h2, s.bitCount)
+		word := &s.lines[bitPos/BitsPerCacheLine].words[(bitPos%BitsPerCacheLine)/64]
+		if atomic.LoadUint64(word)&(1<<(bitPos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Clear resets the filter for every attached process. The generation is
+// odd while the words are being zeroed and even again once Clear is done.
+// Clears are serialized under the file lock, so concurrent ones from several
+// processes run one after the other; if a process died while clearing, the
+// next Clear finishes its work and leaves the generation even.
+func (s *SharedBloomFilter) Clear() error {
+	s.clearMu.Lock()
+	defer s.clearMu.Unlock()
+	if err := lockFile(s.mapped.file); err != nil {
+		return err
+	}
+
+	// An odd generation here was left by a Clear that never finished
+	if atomic.LoadUint64(s.generation)%2 == 0 {
+		atomic.AddUint64(s.generation, 1)
+	}
+	for i := range s.lines {
+		for j := range s.lines[i].words {
+			atomic.StoreUint64(&s.lines[i].words[j], 0)
+		}
+	}
+	atomic.AddUint64(s.generation, 1)
+	return unlockFile(s.mapped.file)
+}
+
+// Generation returns the header's generation counter. It changes on every
+// Clear by any process; an odd value means a Clear is in progress.
+func (s *SharedBloomFilter) Generation() uint64 {
+	return atomic.LoadUint64(s.generation)
+}
+
+// Stale reports whether the path this filter was opened from now refers to
+// a different file, meaning another process removed and reinitialized it.
+// Filters opened with OpenSharedFile are never stale.
+func (s *SharedBloomFilter) Stale() bool {
+	if s.path == "" {
+		return false
+	}
+	info, err := os.Stat(s.path)
+	return err != nil || !os.SameFile(info, s.info)
+}
+
+// PopCount counts set bits using atomic loads
+func (s *SharedBloomFilter) PopCount() uint64 {
+	var count uint64
+	for i := range s.lines {
+		for j := range s.lines[i].words {
+			count += uint64(bits.OnesCount64(atomic.LoadUint64(&s.lines[i].words[j])))
+		}
+	}
+	return count
+}
+
+// EstimatedFPP calculates the estimated false positive probability
+func (s *SharedBloomFilter) EstimatedFPP() float64 {
+	ratio := float64(s.PopCount()) / float64(s.bitCount)
+	return math.Pow(ratio, float64(s.hashCount))
+}
+
+// Sync flushes the mapping to the backing file
+func (s *SharedBloomFilter) Sync() error {
+	return s.mapped.Sync()
+}
+
+// Close detaches this process from the filter. The backing file is left in
+// place for other processes; remove it once every process is done.
+func (s *SharedBloomFilter) Close() error {
+	s.lines = nil
+	s.generation = nil
+	return s.mapped.Close()
+}
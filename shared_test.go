@@ -0,0 +1,234 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Environment used to run TestSharedHelperProcess as a child worker
+const (
+	sharedHelperPathEnv   = "BLOOMFILTER_SHARED_HELPER_PATH"
+	sharedHelperWorkerEnv = "BLOOMFILTER_SHARED_HELPER_WORKER"
+)
+
+// Elements added by each worker process or goroutine
+const sharedElementsPerWorker = 2000
+
+// TestSharedHelperProcess is not a real test; it is the body of the worker
+// processes started by TestSharedFilterMultiProcess
+func TestSharedHelperProcess(t *testing.T) {
+	path := os.Getenv(sharedHelperPathEnv)
+	if path == "" {
+		t.Skip("helper process only")
+	}
+	worker, _ := strconv.Atoi(os.Getenv(sharedHelperWorkerEnv))
+
+	s, err := OpenShared(path, SharedOptions{ExpectedElements: 20000, FalsePositiveRate: 0.01})
+	if err != nil {
+		t.Fatalf("worker %d: OpenShared failed: %v", worker, err)
+	}
+	defer s.Close()
+
+	for i := 0; i < sharedElementsPerWorker; i++ {
+		s.AddString(fmt.Sprintf("worker_%d_%d", worker, i))
+	}
+}
+
+// TestSharedFilterMultiProcess tests concurrent adds from several processes
+func TestSharedFilterMultiProcess(t *testing.T) {
+	skipWithoutMapping(t)
+	path := filepath.Join(t.TempDir(), "shared.bloom")
+
+	const workers = 4
+	cmds := make([]*exec.Cmd, workers)
+	for w := range cmds {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSharedHelperProcess$")
+		cmd.Env = append(os.Environ(), sharedHelperPathEnv+"="+path, sharedHelperWorkerEnv+"="+strconv.Itoa(w))
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("Starting worker %d failed: %v", w, err)
+		}
+		cmds[w] = cmd
+	}
+	for w, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Fatalf("Worker %d failed: %v", w, err)
+		}
+	}
+
+	s, err := OpenShared(path, SharedOptions{})
+	if err != nil {
+		t.Fatalf("OpenShared failed: %v", err)
+	}
+	defer s.Close()
+
+	for w := 0; w < workers; w++ {
+		for i := 0; i < sharedElementsPerWorker; i++ {
+			if !s.ContainsString(fmt.Sprintf("worker_%d_%d", w, i)) {
+				t.Fatalf("Element %d of worker %d missing", i, w)
+			}
+		}
+	}
+}
+
+// TestSharedFilterConcurrentGoroutines tests atomic updates within one process
+// through two independent mappings of the same file
+func TestSharedFilterConcurrentGoroutines(t *testing.T) {
+	skipWithoutMapping(t)
+	path := filepath.Join(t.TempDir(), "shared.bloom")
+	opts := SharedOptions{ExpectedElements: 20000, FalsePositiveRate: 0.01}
+
+	a, err := OpenShared(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := OpenShared(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			target := a
+			if w%2 == 1 {
+				target = b
+			}
+			for i := 0; i < sharedElementsPerWorker; i++ {
+				target.AddUint64(uint64(w*sharedElementsPerWorker + i))
+				target.ContainsUint64(uint64(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for n := 0; n < 4*sharedElementsPerWorker; n++ {
+		if !a.ContainsUint64(uint64(n)) || !b.ContainsUint64(uint64(n)) {
+			t.Fatalf("Element %d missing from a mapping", n)
+		}
+	}
+	if a.PopCount() != b.PopCount() {
+		t.Error("Mappings of the same file disagree on PopCount")
+	}
+}
+
+// TestSharedFilterGeneration tests that Clear and reinitialization are visible to other attachments
+func TestSharedFilterGeneration(t *testing.T) {
+	skipWithoutMapping(t)
+	path := filepath.Join(t.TempDir(), "shared.bloom")
+	opts := SharedOptions{ExpectedElements: 1000, FalsePositiveRate: 0.01}
+
+	writer, err := OpenShared(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	reader, err := OpenShared(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	writer.AddString("before_clear")
+	generation := reader.Generation()
+	if !reader.ContainsString("before_clear") {
+		t.Fatal("Reader does not see writer's element")
+	}
+
+	if err := writer.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if got := reader.Generation(); got != generation+2 {
+		t.Errorf("Expected generation %d after Clear, got %d", generation+2, got)
+	}
+	if reader.ContainsString("before_clear") || reader.PopCount() != 0 {
+		t.Error("Reader still sees data after Clear")
+	}
+
+	if reader.Stale() {
+		t.Error("Filter reported stale before the file was replaced")
+	}
+	os.Remove(path)
+	replacement, err := OpenShared(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replacement.Close()
+	if !reader.Stale() {
+		t.Error("Filter not reported stale after the file was replaced")
+	}
+
+	// The shared file is an ordinary serialized filter
+	replacement.AddString("serialized")
+	replacement.Sync()
+	data, _ := os.ReadFile(path)
+	var decoded CacheOptimizedBloomFilter
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Shared file is not a valid serialized filter: %v", err)
+	}
+	if !decoded.ContainsString("serialized") {
+		t.Error("Decoded shared file lost an element")
+	}
+}
+
+// TestSharedFilterConcurrentClear tests that Clears from several handles and
+// goroutines are serialized and always leave the generation even
+func TestSharedFilterConcurrentClear(t *testing.T) {
+	skipWithoutMapping(t)
+	path := filepath.Join(t.TempDir(), "clear.bloom")
+	opts := SharedOptions{ExpectedElements: 100000, FalsePositiveRate: 0.01}
+
+	handles := make([]*SharedBloomFilter, 2)
+	for i := range handles {
+		s, err := OpenShared(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		handles[i] = s
+	}
+	start := handles[0].Generation()
+
+	const clearsPerGoroutine = 20
+	var wg sync.WaitGroup
+	for _, s := range handles {
+		for g := 0; g < 2; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < clearsPerGoroutine; i++ {
+					s.AddString(fmt.Sprintf("clear_%d", i))
+					if err := s.Clear(); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if got, want := handles[1].Generation(), start+2*4*clearsPerGoroutine; got != want {
+		t.Errorf("Expected generation %d after every Clear, got %d", want, got)
+	}
+
+	// A Clear interrupted by a dying process leaves the generation odd; the
+	// next one completes it
+	atomic.StoreUint64(handles[0].generation, start+1)
+	handles[0].AddString("interrupted")
+	if err := handles[1].Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if got := handles[0].Generation(); got != start+2 || handles[0].ContainsString("interrupted") {
+		t.Errorf("Expected a completed Clear at generation %d, got %d", start+2, got)
+	}
+}
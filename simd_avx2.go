@@ -25,3 +25,13 @@ func (a *AVX2Operations) VectorClear(data unsafe.Pointer, length int) {
 	// TODO: Implement true AVX2 vector clear - using fallback for now
 	(&FallbackOperations{}).VectorClear(data, length)
 }
+
+func (a *AVX2Operations) Prefetch(addr unsafe.Pointer) {
+	// Issues PREFETCHT0 where the assembly is available
+	prefetchCacheLine(addr)
+}
+
+func (a *AVX2Operations) MatchUint16(data unsafe.Pointer, value uint16) uint32 {
+	// TODO: Implement true AVX2 lane compare - using fallback for now
+	return (&FallbackOperations{}).MatchUint16(data, value)
+}
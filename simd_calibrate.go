@@ -0,0 +1,216 @@
+package bloomfilter
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// CalibrateEnvVar enables calibration at package init when set to a non-empty value
+const CalibrateEnvVar = "BLOOMFILTER_CALIBRATE"
+
+// Calibration workload sizes in bytes, chosen to cover L1-, L2- and DRAM-resident filters
+var calibrationSizes = []int{4 << 10, 64 << 10, 1 << 20}
+
+// Odd lengths used only for correctness checks, so tail handling is verified too
+var calibrationCheckSizes = []int{CacheLineSize*7 + 37, 13}
+
+const (
+	calibrationRounds = 3 // timed passes per backend; the fastest pass is kept
+	calibrationSeed   = 0x5eed
+)
+
+// BackendTiming records how a single SIMD backend performed during calibration
+type BackendTiming struct {
+	Name     string
+	Duration time.Duration // best time for one pass over all calibration sizes
+	Correct  bool          // results matched FallbackOperations bit-for-bit
+}
+
+// CalibrationResult describes the backend chosen by Calibrate
+type CalibrationResult struct {
+	Backend string
+	Timings []BackendTiming
+}
+
+// simdBackend pairs a SIMD implementation with a stable name
+type simdBackend struct {
+	name string
+	ops  SIMDOperations
+}
+
+// calibrated holds the backend selected by the last Calibrate call, if any
+var calibrated atomic.Pointer[simdBackend]
+
+// lastCalibration holds the full result of the last Calibrate call, if any
+var lastCalibration atomic.Pointer[CalibrationResult]
+
+// availableSIMDBackends returns the implementations usable on this CPU in priority order
+func availableSIMDBackends() []simdBackend {
+	var backends []simdBackend
+	if hasAVX512 {
+		backends = append(backends, simdBackend{"avx512", &AVX512Operations{}})
+	}
+	if hasAVX2 {
+		backends = append(backends, simdBackend{"avx2", &AVX2Operations{}})
+	}
+	if hasNEON {
+		backends = append(backends, simdBackend{"neon", &NEONOperations{}})
+	}
+	return append(backends, simdBackend{"fallback", &FallbackOperations{}})
+}
+
+// simdBackendName returns the name of a SIMD implementation
+func simdBackendName(ops SIMDOperations) string {
+	switch ops.(type) {
+	case *AVX512Operations:
+		return "avx512"
+	case *AVX2Operations:
+		return "avx2"
+	case *NEONOperations:
+		return "neon"
+	case *FallbackOperations:
+		return "fallback"
+	}
+	return "unknown"
+}
+
+// calibrateOnInit reports whether calibration was requested through the environment
+func calibrateOnInit() bool {
+	return os.Getenv(CalibrateEnvVar) != ""
+}
+
+// Calibrate benchmarks every available SIMD backend, verifies each against
+// FallbackOperations and makes the fastest correct one the default for
+// filters created afterwards. Existing filters keep their backend.
+func Calibrate() CalibrationResult {
+	backends := availableSIMDBackends()
+	reference := &FallbackOperations{}
+
+	result := CalibrationResult{Timings: make([]BackendTiming, 0, len(backends))}
+	best := -1
+	for i, backend := range backends {
+		timing := BackendTiming{Name: backend.name}
+		timing.Correct = verifySIMDBackend(backend.ops, reference)
+		if timing.Correct {
+			timing.Duration = benchmarkSIMDBackend(backend.ops)
+			if best < 0 || timing.Duration < result.Timings[best].Duration {
+				best = i
+			}
+		}
+		result.Timings = append(result.Timings, timing)
+	}
+
+	// The fallback is its own reference, so at least one backend is always correct
+	selected := backends[best]
+	result.Backend = selected.name
+
+	calibrated.Store(&selected)
+	lastCalibration.Store(&result)
+	return result
+}
+
+// LastCalibration returns the result of the most recent Calibrate call, or nil
+func LastCalibration() *CalibrationResult {
+	return lastCalibration.Load()
+}
+
+// verifySIMDBackend checks every operation against the reference on random data
+func verifySIMDBackend(ops, reference SIMDOperations) bool {
+	rng := rand.New(rand.NewSource(calibrationSeed))
+	sizes := append(append([]int{}, calibrationSizes...), calibrationCheckSizes...)
+
+	for _, size := range sizes {
+		a := randomCalibrationBuffer(rng, size)
+		b := randomCalibrationBuffer(rng, size)
+
+		if ops.PopCount(bytesPointer(a), size) != reference.PopCount(bytesPointer(a), size) {
+			return false
+		}
+
+		got, want := append([]byte{}, a...), append([]byte{}, a...)
+		ops.VectorOr(bytesPointer(got), bytesPointer(b), size)
+		reference.VectorOr(bytesPointer(want), bytesPointer(b), size)
+		if !bytes.Equal(got, want) {
+			return false
+		}
+
+		copy(got, a)
+		copy(want, a)
+		ops.VectorAnd(bytesPointer(got), bytesPointer(b), size)
+		reference.VectorAnd(bytesPointer(want), bytesPointer(b), size)
+		if !bytes.Equal(got, want) {
+			return false
+		}
+
+		ops.VectorClear(bytesPointer(got), size)
+		for _, v := range got {
+			if v != 0 {
+				return false
+			}
+		}
+
+		// Match each line's first and last lanes, and a value it may not hold
+		for offset := 0; offset+CacheLineSize <= size; offset += CacheLineSize {
+			line := (*[CacheLineSize / 2]uint16)(bytesPointer(a[offset:]))
+			for _, value := range []uint16{line[0], line[len(line)-1], 0} {
+				if ops.MatchUint16(unsafe.Pointer(line), value) != reference.MatchUint16(unsafe.Pointer(line), value) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// benchmarkSIMDBackend returns the best time for one pass over all calibration sizes
+func benchmarkSIMDBackend(ops SIMDOperations) time.Duration {
+	rng := rand.New(rand.NewSource(calibrationSeed))
+	dst := make([][]byte, len(calibrationSizes))
+	src := make([][]byte, len(calibrationSizes))
+	for i, size := range calibrationSizes {
+		dst[i] = randomCalibrationBuffer(rng, size)
+		src[i] = randomCalibrationBuffer(rng, size)
+	}
+
+	best := time.Duration(-1)
+	sink := 0
+	for round := 0; round < calibrationRounds; round++ {
+		start := time.Now()
+		for i, size := range calibrationSizes {
+			d, s := bytesPointer(dst[i]), bytesPointer(src[i])
+			sink += ops.PopCount(d, size)
+			ops.VectorOr(d, s, size)
+			ops.VectorAnd(d, s, size)
+			sink += ops.PopCount(d, size)
+			ops.VectorClear(d, size)
+		}
+		if elapsed := time.Since(start); best < 0 || elapsed < best {
+			best = elapsed
+		}
+	}
+	calibrationSink = sink
+
+	return best
+}
+
+// calibrationSink keeps benchmark results observable so the work is not optimized away
+var calibrationSink int
+
+// randomCalibrationBuffer returns a cache line aligned buffer of random bytes
+func randomCalibrationBuffer(rng *rand.Rand, size int) []byte {
+	words := make([]uint64, (size+CacheLineSize+7)/8)
+	offset := int(-uintptr(unsafe.Pointer(&words[0])) & (CacheLineSize - 1))
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), len(words)*8)[offset : offset+size]
+	rng.Read(buf)
+	return buf
+}
+
+// bytesPointer returns the address of the first byte of a non-empty buffer
+func bytesPointer(b []byte) unsafe.Pointer {
+	return unsafe.Pointer(&b[0])
+}
@@ -7,7 +7,7 @@ type FallbackOperations struct{}
 
 func (f *FallbackOperations) PopCount(data unsafe.Pointer, length int) int {
 	// Use optimized scalar popcount
-	ptr := (*[1 << 30]uint64)(data)[:length/8]
+	ptr := unsafe.Slice((*uint64)(data), length/8)
 	count := 0
 	for i := 0; i < len(ptr); i++ {
 		count += popcount64(ptr[i])
@@ -29,8 +29,8 @@ func (f *FallbackOperations) PopCount(data unsafe.Pointer, length int) int {
 
 func (f *FallbackOperations) VectorOr(dst, src unsafe.Pointer, length int) {
 	// Process 8 bytes at a time
-	dstPtr := (*[1 << 30]uint64)(dst)[:length/8]
-	srcPtr := (*[1 << 30]uint64)(src)[:length/8]
+	dstPtr := unsafe.Slice((*uint64)(dst), length/8)
+	srcPtr := unsafe.Slice((*uint64)(src), length/8)
 
 	for i := 0; i < len(dstPtr); i++ {
 		dstPtr[i] |= srcPtr[i]
@@ -49,8 +49,8 @@ func (f *FallbackOperations) VectorOr(dst, src unsafe.Pointer, length int) {
 
 func (f *FallbackOperations) VectorAnd(dst, src unsafe.Pointer, length int) {
 	// Process 8 bytes at a time
-	dstPtr := (*[1 << 30]uint64)(dst)[:length/8]
-	srcPtr := (*[1 << 30]uint64)(src)[:length/8]
+	dstPtr := unsafe.Slice((*uint64)(dst), length/8)
+	srcPtr := unsafe.Slice((*uint64)(src), length/8)
 
 	for i := 0; i < len(dstPtr); i++ {
 		dstPtr[i] &= srcPtr[i]
@@ -69,7 +69,7 @@ func (f *FallbackOperations) VectorAnd(dst, src unsafe.Pointer, length int) {
 
 func (f *FallbackOperations) VectorClear(data unsafe.Pointer, length int) {
 	// Process 8 bytes at a time
-	ptr := (*[1 << 30]uint64)(data)[:length/8]
+	ptr := unsafe.Slice((*uint64)(data), length/8)
 
 	for i := 0; i < len(ptr); i++ {
 		ptr[i] = 0
@@ -85,6 +85,47 @@ func (f *FallbackOperations) VectorClear(data unsafe.Pointer, length int) {
 	}
 }
 
+func (f *FallbackOperations) Prefetch(addr unsafe.Pointer) {
+	// Prefetching is a plain hint, not a vector operation, so scalar code benefits too
+	prefetchCacheLine(addr)
+}
+
+// Masks for working on the four 16-bit lanes of a word at once
+const (
+	laneLow  = 0x0001000100010001
+	laneHigh = 0x8000800080008000
+)
+
+func (f *FallbackOperations) MatchUint16(data unsafe.Pointer, value uint16) uint32 {
+	var mask uint32
+	if !hostLittleEndian {
+		for i, lane := range (*[CacheLineSize / 2]uint16)(data) {
+			if lane == value {
+				mask |= 1 << i
+			}
+		}
+		return mask
+	}
+
+	// Compare four lanes per word; lane i of a word is its bits 16i..16i+15
+	pattern := uint64(value) * laneLow
+	for i, word := range (*[WordsPerCacheLine]uint64)(data) {
+		mask |= zeroLanes16(word^pattern) << (i * 4)
+	}
+	return mask
+}
+
+// zeroLanes16 returns a 4-bit mask of the 16-bit lanes of x that are zero.
+// Adding 0x7FFF to the low 15 bits of a lane carries into its top bit exactly
+// when they are non-zero, without crossing into the next lane; the top bits
+// are then gathered into bits 48..51 by a multiply whose partial products
+// never overlap.
+func zeroLanes16(x uint64) uint32 {
+	nonZero := ((x&^laneHigh + laneLow*0x7FFF) | x) & laneHigh
+	zero := (nonZero ^ laneHigh) >> 15
+	return uint32(zero*(1<<48|1<<33|1<<18|1<<3)>>48) & 0xF
+}
+
 // popcount64 implements efficient popcount for uint64
 func popcount64(x uint64) int {
 	// Use the same algorithm as bits.OnesCount64 but inline for performance
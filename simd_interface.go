@@ -9,17 +9,33 @@ type SIMDOperations interface {
 	VectorOr(dst, src unsafe.Pointer, length int)
 	VectorAnd(dst, src unsafe.Pointer, length int)
 	VectorClear(data unsafe.Pointer, length int)
+	// Prefetch hints that the cache line at addr will be read soon; it never faults
+	Prefetch(addr unsafe.Pointer)
+	// MatchUint16 compares the 32 uint16 lanes of the cache line at data with
+	// value, returning a mask with bit i set when lane i is equal
+	MatchUint16(data unsafe.Pointer, value uint16) uint32
 }
 
 // GetSIMDOperations returns the best available SIMD implementation
 func GetSIMDOperations() SIMDOperations {
+	ops, _ := selectSIMDOperations()
+	return ops
+}
+
+// selectSIMDOperations returns the backend chosen by Calibrate if one has run,
+// otherwise the best implementation by capability detection
+func selectSIMDOperations() (ops SIMDOperations, fromCalibration bool) {
+	if backend := calibrated.Load(); backend != nil {
+		return backend.ops, true
+	}
+
 	// Priority order: AVX512 > AVX2 > NEON > Fallback
 	if hasAVX512 {
-		return &AVX512Operations{}
+		return &AVX512Operations{}, false
 	} else if hasAVX2 {
-		return &AVX2Operations{}
+		return &AVX2Operations{}, false
 	} else if hasNEON {
-		return &NEONOperations{}
+		return &NEONOperations{}, false
 	}
-	return &FallbackOperations{}
+	return &FallbackOperations{}, false
 }
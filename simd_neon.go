@@ -20,3 +20,13 @@ func (n *NEONOperations) VectorAnd(dst, src unsafe.Pointer, length int) {
 func (n *NEONOperations) VectorClear(data unsafe.Pointer, length int) {
 	neonVectorClear(data, length)
 }
+
+func (n *NEONOperations) Prefetch(addr unsafe.Pointer) {
+	// Issues PRFM PLDL1KEEP where the assembly is available
+	prefetchCacheLine(addr)
+}
+
+func (n *NEONOperations) MatchUint16(data unsafe.Pointer, value uint16) uint32 {
+	// TODO: Implement true NEON lane compare - using fallback for now
+	return (&FallbackOperations{}).MatchUint16(data, value)
+}
@@ -0,0 +1,25 @@
+//go:build !arm64 || purego
+
+package bloomfilter
+
+import "unsafe"
+
+// NEON entry points for builds without the ARM64 assembly.
+// NEONOperations is only selected when hasNEON is set, but the type must still
+// compile everywhere, so these route to the optimized scalar implementation.
+
+func neonPopCount(data unsafe.Pointer, length int) int {
+	return (&FallbackOperations{}).PopCount(data, length)
+}
+
+func neonVectorOr(dst, src unsafe.Pointer, length int) {
+	(&FallbackOperations{}).VectorOr(dst, src, length)
+}
+
+func neonVectorAnd(dst, src unsafe.Pointer, length int) {
+	(&FallbackOperations{}).VectorAnd(dst, src, length)
+}
+
+func neonVectorClear(data unsafe.Pointer, length int) {
+	(&FallbackOperations{}).VectorClear(data, length)
+}
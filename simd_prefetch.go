@@ -0,0 +1,11 @@
+//go:build (amd64 || arm64) && !purego
+
+package bloomfilter
+
+import "unsafe"
+
+// Hardware prefetch hint implemented in assembly
+// amd64 issues PREFETCHT0, arm64 issues PRFM PLDL1KEEP
+
+//go:noescape
+func prefetchCacheLine(addr unsafe.Pointer)
@@ -0,0 +1,10 @@
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// prefetchCacheLine hints the CPU to load a cache line into all cache levels
+// func prefetchCacheLine(addr unsafe.Pointer)
+TEXT ·prefetchCacheLine(SB), NOSPLIT, $0-8
+    MOVQ addr+0(FP), AX
+    PREFETCHT0 (AX)
+    RET
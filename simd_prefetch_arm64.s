@@ -0,0 +1,10 @@
+//go:build arm64 && !purego
+
+#include "textflag.h"
+
+// prefetchCacheLine hints the CPU to load a cache line into L1 and keep it there
+// func prefetchCacheLine(addr unsafe.Pointer)
+TEXT ·prefetchCacheLine(SB), NOSPLIT, $0-8
+    MOVD addr+0(FP), R0
+    PRFM (R0), PLDL1KEEP
+    RET
@@ -0,0 +1,8 @@
+//go:build !(amd64 || arm64) || purego
+
+package bloomfilter
+
+import "unsafe"
+
+// prefetchCacheLine is a no-op where no prefetch instruction is available
+func prefetchCacheLine(addr unsafe.Pointer) {}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"runtime"
 	"testing"
+	"unsafe"
 )
 
 // TestSIMDCapabilities tests SIMD capability detection and reporting
@@ -75,6 +76,16 @@ func TestSIMDFunctions(t *testing.T) {
 		t.Errorf("Clear failed: expected 0 bits, got %d", countAfterClear)
 	}
 
+	// Test Prefetch on every available backend, including a line a page away
+	// from the first; every address stays inside the allocation
+	lines := make([]CacheLine, 4096/CacheLineSize+1)
+	for _, backend := range availableSIMDBackends() {
+		for i := range 4 {
+			backend.ops.Prefetch(unsafe.Pointer(&lines[i]))
+		}
+		backend.ops.Prefetch(unsafe.Pointer(&lines[4096/CacheLineSize]))
+	}
+
 	t.Logf("All SIMD functions executed successfully")
 }
 
@@ -107,3 +118,78 @@ func TestCacheStats(t *testing.T) {
 		t.Logf("Note: Memory not perfectly aligned (offset: %d bytes)", stats.Alignment)
 	}
 }
+
+// TestCalibrate tests that calibration picks a correct backend and reports it
+func TestCalibrate(t *testing.T) {
+	defer func() {
+		calibrated.Store(nil)
+		lastCalibration.Store(nil)
+	}()
+
+	result := Calibrate()
+
+	available := availableSIMDBackends()
+	if len(result.Timings) != len(available) {
+		t.Fatalf("Expected %d timings, got %d", len(available), len(result.Timings))
+	}
+
+	found := false
+	for _, timing := range result.Timings {
+		t.Logf("Backend %s: correct=%t duration=%v", timing.Name, timing.Correct, timing.Duration)
+		if timing.Name == "fallback" && !timing.Correct {
+			t.Error("Fallback backend must always verify against itself")
+		}
+		if timing.Name == result.Backend {
+			found = true
+			if !timing.Correct {
+				t.Errorf("Selected backend %s failed verification", timing.Name)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("Selected backend %s is not among the available backends", result.Backend)
+	}
+
+	if last := LastCalibration(); last == nil || last.Backend != result.Backend {
+		t.Error("LastCalibration should return the most recent result")
+	}
+
+	if name := simdBackendName(GetSIMDOperations()); name != result.Backend {
+		t.Errorf("GetSIMDOperations returned %s, expected calibrated %s", name, result.Backend)
+	}
+
+	bf := NewCacheOptimizedBloomFilter(1000, 0.01)
+	stats := bf.GetCacheStats()
+	if !stats.SIMDCalibrated || stats.SIMDBackend != result.Backend {
+		t.Errorf("Expected calibrated backend %s in stats, got %s (calibrated=%t)",
+			result.Backend, stats.SIMDBackend, stats.SIMDCalibrated)
+	}
+
+	bf.AddString("calibrated")
+	if !bf.ContainsString("calibrated") {
+		t.Error("Filter using calibrated backend lost an element")
+	}
+}
+
+// TestMatchUint16 tests lane matching on every available backend
+func TestMatchUint16(t *testing.T) {
+	var line CacheLine
+	lanes := (*[CacheLineSize / 2]uint16)(unsafe.Pointer(&line))
+	for i := range lanes {
+		lanes[i] = uint16(i + 1)
+	}
+	lanes[0], lanes[5], lanes[31] = 0xFFFF, 0xFFFF, 0xFFFF
+	lanes[3], lanes[16] = 0, 0
+	lanes[9] = 0x8000
+
+	for _, backend := range availableSIMDBackends() {
+		for _, tc := range []struct {
+			value uint16
+			mask  uint32
+		}{{0xFFFF, 1<<0 | 1<<5 | 1<<31}, {0, 1<<3 | 1<<16}, {0x8000, 1 << 9}, {2, 1 << 1}, {31, 1 << 30}, {0x1234, 0}} {
+			if got := backend.ops.MatchUint16(unsafe.Pointer(&line), tc.value); got != tc.mask {
+				t.Errorf("%s: MatchUint16(%#x) = %#x, expected %#x", backend.name, tc.value, got, tc.mask)
+			}
+		}
+	}
+}
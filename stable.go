@@ -0,0 +1,224 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"unsafe"
+)
+
+// StableBloomFilter is a stable bloom filter (Deng and Rafiei, 2006) for
+// duplicate detection over unbounded streams. Cells are small saturating
+// counters rather than bits: Add first decrements P cells chosen at random,
+// then sets the element's K cells to the maximum. Old elements fade out as
+// their cells are decremented, so the fraction of zero cells, and with it the
+// false positive rate, converges to a fixed point instead of saturating. The
+// price is false negatives for elements not seen recently.
+//
+// Counters are packed into the same 64-byte aligned cache lines the bloom
+// filter uses, and an element's K cells are placed by double hashing across
+// the whole table as in CacheOptimizedBloomFilter.
+type StableBloomFilter struct {
+	cacheLines   []CacheLine
+	memory       *cacheLineMemory
+	cellCount    uint64
+	counterBits  uint
+	cellsPerLine uint64
+	maxValue     uint64
+	hashCount    uint32
+	// Cells decremented per insertion
+	decrements uint64
+
+	rng uint64
+
+	simdOps SIMDOperations
+}
+
+// NewStableBloomFilter creates a stable bloom filter with at least cells
+// counters of counterBits (1, 2, 4 or 8) bits each, choosing the number of
+// hash functions and decrements so that the false positive rate settles at
+// falsePositiveRate. The random choices are seeded with WithSeed, or randomly.
+func NewStableBloomFilter(cells uint64, counterBits uint, falsePositiveRate float64, opts ...Option) (*StableBloomFilter, error) {
+	if counterBits == 0 || counterBits > 8 || counterBits&(counterBits-1) != 0 {
+		return nil, fmt.Errorf("counter size must be 1, 2, 4 or 8 bits, got %d", counterBits)
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, fmt.Errorf("false positive rate must be between 0 and 1, got %g", falsePositiveRate)
+	}
+
+	cellsPerLine := uint64(BitsPerCacheLine / counterBits)
+	lines := max(1, (cells+cellsPerLine-1)/cellsPerLine)
+	o := applyOptions(opts)
+	memory := allocateCacheLines(lines, o)
+	simdOps, _ := selectSIMDOperations()
+
+	sbf := &StableBloomFilter{
+		cacheLines:   memory.lines,
+		memory:       memory,
+		cellCount:    lines * cellsPerLine,
+		counterBits:  counterBits,
+		cellsPerLine: cellsPerLine,
+		maxValue:     1<<counterBits - 1,
+		rng:          o.seed,
+		simdOps:      simdOps,
+	}
+	if !o.seeded {
+		sbf.rng = rand.Uint64()
+	}
+
+	sbf.hashCount = uint32(math.Ceil(-math.Log2(falsePositiveRate)))
+	sbf.decrements = optimalDecrements(sbf.cellCount, sbf.hashCount, sbf.maxValue, falsePositiveRate)
+	return sbf, nil
+}
+
+// optimalDecrements solves the stable point equation for P: at the stable
+// point a fraction (1 + 1/(P(1/K - 1/m)))^-Max of the cells is zero, and a
+// lookup is a false positive when all K of its cells are nonzero
+func optimalDecrements(cells uint64, hashCount uint32, maxValue uint64, falsePositiveRate float64) uint64 {
+	zeroFraction := 1 - math.Pow(falsePositiveRate, 1/float64(hashCount))
+	perCell := 1/math.Pow(zeroFraction, 1/float64(maxValue)) - 1
+	p := 1 / (perCell * (1/float64(hashCount) - 1/float64(cells)))
+	return uint64(max(1, math.Round(p)))
+}
+
+// hashPair returns the double hashing pair of an element, mixed because
+// reduceRange keeps only the high bits of each probe
+func (sbf *StableBloomFilter) hashPair(data []byte) (uint64, uint64) {
+	return fuseMix(hashOptimized1(data), 0), fuseMix(hashOptimized2(data), 0)
+}
+
+// cellAt returns the cache line and index within it of a cell
+func (sbf *StableBloomFilter) cellAt(pos uint64) (*CacheLine, uint64) {
+	return &sbf.cacheLines[pos/sbf.cellsPerLine], pos % sbf.cellsPerLine
+}
+
+// cell reads counter i of a cache line
+func (sbf *StableBloomFilter) cell(line *CacheLine, i uint64) uint64 {
+	bit := i * uint64(sbf.counterBits)
+	return line.words[bit/64] >> (bit % 64) & sbf.maxValue
+}
+
+// setCell writes counter i of a cache line
+func (sbf *StableBloomFilter) setCell(line *CacheLine, i, v uint64) {
+	bit := i * uint64(sbf.counterBits)
+	shift := bit % 64
+	line.words[bit/64] = line.words[bit/64]&^(sbf.maxValue<<shift) | v<<shift
+}
+
+// decrement lowers P cells by one. They are spread evenly from a random
+// position rather than taken consecutively, so no region of the table is
+// cleared in a clump.
+func (sbf *StableBloomFilter) decrement() {
+	stride := max(1, sbf.cellCount/sbf.decrements)
+	pos := reduceRange(splitMix64(&sbf.rng), sbf.cellCount)
+	for i := uint64(0); i < sbf.decrements; i++ {
+		line, i := sbf.cellAt(pos)
+		if v := sbf.cell(line, i); v > 0 {
+			sbf.setCell(line, i, v-1)
+		}
+		if pos += stride; pos >= sbf.cellCount {
+			pos -= sbf.cellCount
+		}
+	}
+}
+
+// Add records an element
+func (sbf *StableBloomFilter) Add(data []byte) {
+	sbf.decrement()
+	h1, h2 := sbf.hashPair(data)
+	for i := uint32(0); i < sbf.hashCount; i++ {
+		line, j := sbf.cellAt(reduceRange(h1+uint64(i)*h2, sbf.cellCount))
+		sbf.setCell(line, j, sbf.maxValue)
+	}
+}
+
+// Contains reports whether an element was seen recently
+func (sbf *StableBloomFilter) Contains(data []byte) bool {
+	h1, h2 := sbf.hashPair(data)
+	for i := uint32(0); i < sbf.hashCount; i++ {
+		if sbf.cell(sbf.cellAt(reduceRange(h1+uint64(i)*h2, sbf.cellCount))) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestAndAdd reports whether an element was seen recently and then adds it,
+// the usual step of duplicate detection
+func (sbf *StableBloomFilter) TestAndAdd(data []byte) bool {
+	seen := sbf.Contains(data)
+	sbf.Add(data)
+	return seen
+}
+
+// AddString adds a string element
+func (sbf *StableBloomFilter) AddString(s string) {
+	sbf.Add(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// ContainsString checks if a string element was seen recently
+func (sbf *StableBloomFilter) ContainsString(s string) bool {
+	return sbf.Contains(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// TestAndAddString is TestAndAdd for a string element
+func (sbf *StableBloomFilter) TestAndAddString(s string) bool {
+	return sbf.TestAndAdd(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// Cells returns the number of counters
+func (sbf *StableBloomFilter) Cells() uint64 {
+	return sbf.cellCount
+}
+
+// HashCount returns the number of cells set per element (K)
+func (sbf *StableBloomFilter) HashCount() uint32 {
+	return sbf.hashCount
+}
+
+// Decrements returns the number of cells decremented per insertion (P)
+func (sbf *StableBloomFilter) Decrements() uint64 {
+	return sbf.decrements
+}
+
+// StableFPP returns the false positive rate the filter converges to
+func (sbf *StableBloomFilter) StableFPP() float64 {
+	k, m := float64(sbf.hashCount), float64(sbf.cellCount)
+	zeroFraction := math.Pow(1/(1+1/(float64(sbf.decrements)*(1/k-1/m))), float64(sbf.maxValue))
+	return math.Pow(1-zeroFraction, k)
+}
+
+// EstimatedFPP estimates the current false positive rate from the fraction
+// of nonzero cells
+func (sbf *StableBloomFilter) EstimatedFPP() float64 {
+	return math.Pow(sbf.nonzeroFraction(), float64(sbf.hashCount))
+}
+
+// nonzeroFraction counts the cells holding a nonzero counter
+func (sbf *StableBloomFilter) nonzeroFraction() float64 {
+	// Bits of each counter folded into its lowest bit
+	low := ^uint64(0) / sbf.maxValue
+	var nonzero int
+	for i := range sbf.cacheLines {
+		for _, word := range sbf.cacheLines[i].words {
+			for shift := uint(1); shift < sbf.counterBits; shift <<= 1 {
+				word |= word >> shift
+			}
+			nonzero += bits.OnesCount64(word & low)
+		}
+	}
+	return float64(nonzero) / float64(sbf.cellCount)
+}
+
+// MemoryUsage returns the bytes allocated for the counters
+func (sbf *StableBloomFilter) MemoryUsage() uint64 {
+	return sbf.memory.size
+}
+
+// Clear resets every counter to zero
+func (sbf *StableBloomFilter) Clear() {
+	sbf.simdOps.VectorClear(unsafe.Pointer(&sbf.cacheLines[0]), len(sbf.cacheLines)*CacheLineSize)
+	runtime.KeepAlive(sbf)
+}
@@ -0,0 +1,110 @@
+package bloomfilter
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// TestStableBloomFilterParameters tests that P is chosen for the target rate
+func TestStableBloomFilterParameters(t *testing.T) {
+	for _, counterBits := range []uint{1, 2, 4, 8} {
+		for _, target := range []float64{0.1, 0.01, 0.001} {
+			sbf, err := NewStableBloomFilter(1<<16, counterBits, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sbf.Decrements() < 1 || sbf.HashCount() < 1 {
+				t.Fatalf("d=%d: K=%d P=%d", counterBits, sbf.HashCount(), sbf.Decrements())
+			}
+			// Rounding P to a whole cell shifts the rate a little
+			if got := sbf.StableFPP(); math.Abs(got-target)/target > 0.3 {
+				t.Errorf("d=%d target %g: stable rate %g", counterBits, target, got)
+			}
+		}
+	}
+
+	if _, err := NewStableBloomFilter(1000, 3, 0.01); err == nil {
+		t.Error("Expected error for 3-bit counters")
+	}
+	if _, err := NewStableBloomFilter(1000, 2, 0); err == nil {
+		t.Error("Expected error for a zero false positive rate")
+	}
+}
+
+// TestStableBloomFilterConverges streams many more elements than cells and
+// checks the false positive rate settles at the stable point
+func TestStableBloomFilterConverges(t *testing.T) {
+	sbf, _ := NewStableBloomFilter(1<<16, 2, 0.01, WithSeed(7))
+	for i := 0; i < 500000; i++ {
+		sbf.AddString(fmt.Sprintf("event_%d", i))
+	}
+
+	if got, want := sbf.EstimatedFPP(), sbf.StableFPP(); math.Abs(got-want)/want > 0.2 {
+		t.Errorf("Estimated rate %.4f, stable rate %.4f", got, want)
+	}
+	const trials = 100000
+	falsePositives := 0
+	for i := 0; i < trials; i++ {
+		if sbf.ContainsString(fmt.Sprintf("absent_%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / trials; rate > 1.5*sbf.StableFPP() {
+		t.Errorf("False positive rate %.4f, stable rate %.4f", rate, sbf.StableFPP())
+	}
+
+	// The most recent elements are still remembered
+	missed := 0
+	for i := 500000 - 1000; i < 500000; i++ {
+		if !sbf.ContainsString(fmt.Sprintf("event_%d", i)) {
+			missed++
+		}
+	}
+	if missed > 10 {
+		t.Errorf("%d of the last 1000 elements forgotten", missed)
+	}
+}
+
+// TestStableBloomFilterTestAndAdd tests duplicate detection of an immediate repeat
+func TestStableBloomFilterTestAndAdd(t *testing.T) {
+	sbf, _ := NewStableBloomFilter(1<<14, 1, 0.01, WithSeed(1))
+	for i := 0; i < 100000; i++ {
+		key := fmt.Sprintf("dup_%d", i)
+		sbf.TestAndAddString(key)
+		if !sbf.TestAndAddString(key) {
+			t.Fatalf("Immediate repeat of %q not detected", key)
+		}
+	}
+
+	sbf.Clear()
+	if sbf.nonzeroFraction() != 0 || sbf.ContainsString("dup_99999") {
+		t.Error("Clear left counters behind")
+	}
+}
+
+// TestStableBloomFilterSeed tests that seeded filters are reproducible
+func TestStableBloomFilterSeed(t *testing.T) {
+	a, _ := NewStableBloomFilter(1<<12, 4, 0.01, WithSeed(42))
+	b, _ := NewStableBloomFilter(1<<12, 4, 0.01, WithSeed(42))
+	c, _ := NewStableBloomFilter(1<<12, 4, 0.01, WithSeed(43))
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("seed_%d", i)
+		a.AddString(key)
+		b.AddString(key)
+		c.AddString(key)
+	}
+
+	same := true
+	differs := false
+	for i := range a.cacheLines {
+		same = same && a.cacheLines[i] == b.cacheLines[i]
+		differs = differs || a.cacheLines[i] != c.cacheLines[i]
+	}
+	if !same {
+		t.Error("Filters with the same seed diverged")
+	}
+	if !differs {
+		t.Error("Filters with different seeds are identical")
+	}
+}
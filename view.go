@@ -0,0 +1,237 @@
+package bloomfilter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"runtime"
+	"unsafe"
+)
+
+// FilterView is a read-only, zero-copy view of a filter serialized in the
+// package's binary format, for filters embedded in other file formats or
+// network messages. The buffer is never copied, so the view reflects later
+// changes to it and keeps it alive. Unlike CacheOptimizedBloomFilter, a
+// FilterView has no scratch state and is safe for concurrent use.
+type FilterView struct {
+	payload []byte
+	// Word view of payload, set when it is 8-byte aligned on a little-endian host
+	lines          []CacheLine
+	bitCount       uint64
+	hashCount      uint32
+	cacheLineCount uint64
+	simdOps        SIMDOperations
+}
+
+// NewFilterView validates the header at the start of data and returns a view
+// over the payload that follows it. data may continue past the filter;
+// EncodedSize reports how many bytes the filter occupies.
+func NewFilterView(data []byte) (*FilterView, error) {
+	h, err := decodeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.compressed() {
+		return nil, ErrCompressed
+	}
+	if uint64(len(data)-headerSize) < h.payloadSize {
+		return nil, fmt.Errorf("%w: payload is %d bytes, expected %d", ErrInvalidFormat, len(data)-headerSize, h.payloadSize)
+	}
+
+	v := &FilterView{
+		payload:        data[headerSize : headerSize+h.payloadSize],
+		bitCount:       h.bitCount,
+		hashCount:      h.hashCount,
+		cacheLineCount: h.cacheLineCount,
+		simdOps:        GetSIMDOperations(),
+	}
+
+	// Words can only be loaded in place when naturally aligned; anything else
+	// is decoded byte by byte
+	if hostLittleEndian && uintptr(unsafe.Pointer(&v.payload[0]))%8 == 0 {
+		v.lines = unsafe.Slice((*CacheLine)(unsafe.Pointer(&v.payload[0])), h.cacheLineCount)
+	}
+
+	return v, nil
+}
+
+// EncodedSize returns the number of bytes of the buffer the filter occupies
+func (v *FilterView) EncodedSize() int {
+	return headerSize + len(v.payload)
+}
+
+// word returns the 64-bit word with the given index
+func (v *FilterView) word(idx uint64) uint64 {
+	if v.lines != nil {
+		return v.lines[idx/WordsPerCacheLine].words[idx%WordsPerCacheLine]
+	}
+	return binary.LittleEndian.Uint64(v.payload[idx*8:])
+}
+
+// containsHash tests the bits for a double hashing pair
+func (v *FilterView) containsHash(h1, h2 uint64) bool {
+	for i := uint32(0); i < v.hashCount; i++ {
+		bitPos := reduceRange(h1+uint64(i)*h2, v.bitCount)
+		if v.word(bitPos/64)&(1<<(bitPos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains checks membership
+func (v *FilterView) Contains(data []byte) bool {
+	return v.containsHash(hashOptimized1(data), hashOptimized2(data))
+}
+
+// ContainsString checks if a string element exists
+func (v *FilterView) ContainsString(s string) bool {
+	return v.Contains(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// ContainsUint64 checks if a uint64 element exists
+func (v *FilterView) ContainsUint64(n uint64) bool {
+	return v.Contains((*[8]byte)(unsafe.Pointer(&n))[:])
+}
+
+// PopCount counts set bits, using SIMD when the payload is aligned
+func (v *FilterView) PopCount() uint64 {
+	if v.lines != nil {
+		return uint64(v.simdOps.PopCount(unsafe.Pointer(&v.lines[0]), len(v.payload)))
+	}
+
+	var count uint64
+	for i := 0; i < len(v.payload); i += 8 {
+		count += uint64(bits.OnesCount64(binary.LittleEndian.Uint64(v.payload[i:])))
+	}
+	return count
+}
+
+// EstimatedFPP calculates the estimated false positive probability
+func (v *FilterView) EstimatedFPP() float64 {
+	ratio := float64(v.PopCount()) / float64(v.bitCount)
+	return math.Pow(ratio, float64(v.hashCount))
+}
+
+// BitCount returns the number of bits in the filter
+func (v *FilterView) BitCount() uint64 {
+	return v.bitCount
+}
+
+// HashCount returns the number of hash functions
+func (v *FilterView) HashCount() uint32 {
+	return v.hashCount
+}
+
+// EstimateUnion estimates the number of distinct elements in the union of
+// two filters with the same size and hash count, from the bits set in either
+func (v *FilterView) EstimateUnion(other *FilterView) (float64, error) {
+	_, _, union, err := v.overlap(other)
+	if err != nil {
+		return 0, err
+	}
+	return v.estimateElements(union), nil
+}
+
+// EstimateIntersection estimates the number of elements two filters with the
+// same size and hash count have in common, by inclusion-exclusion over the
+// estimated sizes of each set and of their union
+func (v *FilterView) EstimateIntersection(other *FilterView) (float64, error) {
+	a, b, union, err := v.overlap(other)
+	if err != nil {
+		return 0, err
+	}
+	return v.estimateIntersection(a, b, union), nil
+}
+
+// Jaccard estimates the Jaccard similarity |A∩B| / |A∪B| of two filters with
+// the same size and hash count. Two empty filters have similarity 0.
+func (v *FilterView) Jaccard(other *FilterView) (float64, error) {
+	a, b, union, err := v.overlap(other)
+	if err != nil {
+		return 0, err
+	}
+	estimatedUnion := v.estimateElements(union)
+	if estimatedUnion == 0 {
+		return 0, nil
+	}
+	return v.estimateIntersection(a, b, union) / estimatedUnion, nil
+}
+
+// EstimateUnionFilter is EstimateUnion against a CacheOptimizedBloomFilter
+func (v *FilterView) EstimateUnionFilter(bf *CacheOptimizedBloomFilter) (float64, error) {
+	defer runtime.KeepAlive(bf)
+	return v.EstimateUnion(bf.view())
+}
+
+// EstimateIntersectionFilter is EstimateIntersection against a CacheOptimizedBloomFilter
+func (v *FilterView) EstimateIntersectionFilter(bf *CacheOptimizedBloomFilter) (float64, error) {
+	defer runtime.KeepAlive(bf)
+	return v.EstimateIntersection(bf.view())
+}
+
+// JaccardFilter is Jaccard against a CacheOptimizedBloomFilter
+func (v *FilterView) JaccardFilter(bf *CacheOptimizedBloomFilter) (float64, error) {
+	defer runtime.KeepAlive(bf)
+	return v.Jaccard(bf.view())
+}
+
+// view returns a FilterView over the filter's own cache lines. It is only
+// valid while bf is alive and must not outlive the call that created it.
+func (bf *CacheOptimizedBloomFilter) view() *FilterView {
+	v := &FilterView{
+		bitCount:       bf.bitCount,
+		hashCount:      bf.hashCount,
+		cacheLineCount: bf.cacheLineCount,
+		simdOps:        bf.simdOps,
+	}
+	if bf.cacheLineCount > 0 {
+		v.lines = bf.cacheLines
+		v.payload = unsafe.Slice((*byte)(unsafe.Pointer(&bf.cacheLines[0])), bf.cacheLineCount*CacheLineSize)
+	}
+	return v
+}
+
+// overlap counts the bits set in each filter and in either. Word
+// slices are walked directly when both payloads are aligned; otherwise
+// words are decoded byte by byte.
+func (v *FilterView) overlap(other *FilterView) (a, b, union uint64, err error) {
+	if v.bitCount != other.bitCount || v.hashCount != other.hashCount || len(v.payload) != len(other.payload) {
+		return 0, 0, 0, fmt.Errorf("bloom filters must have same size and hash count for similarity estimates")
+	}
+
+	if v.lines != nil && other.lines != nil {
+		for i := range v.lines {
+			for j, x := range v.lines[i].words {
+				y := other.lines[i].words[j]
+				a += uint64(bits.OnesCount64(x))
+				b += uint64(bits.OnesCount64(y))
+				union += uint64(bits.OnesCount64(x | y))
+			}
+		}
+		return a, b, union, nil
+	}
+
+	for idx := uint64(0); idx < uint64(len(v.payload))/8; idx++ {
+		x, y := v.word(idx), other.word(idx)
+		a += uint64(bits.OnesCount64(x))
+		b += uint64(bits.OnesCount64(y))
+		union += uint64(bits.OnesCount64(x | y))
+	}
+	return a, b, union, nil
+}
+
+// estimateElements estimates how many elements set bitsSet bits, inverting
+// the expected fill 1 - (1 - 1/m)^(kn) ≈ 1 - e^(-kn/m). A saturated filter
+// gives +Inf.
+func (v *FilterView) estimateElements(bitsSet uint64) float64 {
+	m := float64(v.bitCount)
+	return -m / float64(v.hashCount) * math.Log1p(-float64(bitsSet)/m)
+}
+
+// estimateIntersection applies inclusion-exclusion to the bit counts of two
+// filters and their union, clamping the noise below zero
+func (v *FilterView) estimateIntersection(a, b, union uint64) float64 {
+	return max(v.estimateElements(a)+v.estimateElements(b)-v.estimateElements(union), 0)
+}
@@ -0,0 +1,156 @@
+package bloomfilter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// TestFilterViewMatchesFilter tests views at every alignment against the source filter
+func TestFilterViewMatchesFilter(t *testing.T) {
+	bf := NewCacheOptimizedBloomFilter(5000, 0.01)
+	for i := 0; i < 2000; i++ {
+		bf.AddString(fmt.Sprintf("view_%d", i))
+	}
+	data, _ := bf.MarshalBinary()
+
+	for offset := 0; offset < 8; offset++ {
+		t.Run(fmt.Sprintf("Offset_%d", offset), func(t *testing.T) {
+			// Embed the filter at an arbitrary offset with trailing data
+			buf := make([]byte, offset+len(data)+5)
+			copy(buf[offset:], data)
+
+			view, err := NewFilterView(buf[offset:])
+			if err != nil {
+				t.Fatalf("NewFilterView failed: %v", err)
+			}
+			if view.EncodedSize() != len(data) {
+				t.Errorf("Expected encoded size %d, got %d", len(data), view.EncodedSize())
+			}
+			if view.PopCount() != bf.PopCount() {
+				t.Errorf("PopCount mismatch: view=%d filter=%d", view.PopCount(), bf.PopCount())
+			}
+			if view.EstimatedFPP() != bf.EstimatedFPP() {
+				t.Errorf("EstimatedFPP mismatch: view=%f filter=%f", view.EstimatedFPP(), bf.EstimatedFPP())
+			}
+			for i := 0; i < 4000; i++ {
+				key := fmt.Sprintf("view_%d", i)
+				if view.ContainsString(key) != bf.ContainsString(key) {
+					t.Fatalf("Contains mismatch for %s", key)
+				}
+			}
+		})
+	}
+}
+
+// TestFilterViewZeroCopy tests that the view reads the caller's buffer in place
+func TestFilterViewZeroCopy(t *testing.T) {
+	bf := NewCacheOptimizedBloomFilter(1000, 0.01)
+	data, _ := bf.MarshalBinary()
+
+	view, err := NewFilterView(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.ContainsUint64(7) {
+		t.Fatal("Empty view reported an element")
+	}
+
+	// Writing the element into a filter and copying only its bits into the
+	// buffer must make the view see it
+	bf.AddUint64(7)
+	encoded, _ := bf.MarshalBinary()
+	copy(data[headerSize:], encoded[headerSize:])
+	if !view.ContainsUint64(7) {
+		t.Error("View did not observe changes to the underlying buffer")
+	}
+}
+
+// TestFilterViewInvalid tests that invalid buffers are rejected
+func TestFilterViewInvalid(t *testing.T) {
+	data, _ := NewCacheOptimizedBloomFilter(1000, 0.01).MarshalBinary()
+
+	for name, buf := range map[string][]byte{
+		"Empty":     nil,
+		"Header":    data[:headerSize],
+		"Truncated": data[:len(data)-1],
+	} {
+		if _, err := NewFilterView(buf); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("%s: expected ErrInvalidFormat, got %v", name, err)
+		}
+	}
+}
+
+// TestFilterViewSimilarity tests union, intersection and Jaccard estimates
+// between views at every pair of alignments and against the source filter
+func TestFilterViewSimilarity(t *testing.T) {
+	a := NewCacheOptimizedBloomFilter(10000, 0.01)
+	b := NewCacheOptimizedBloomFilter(10000, 0.01)
+	// Spread the keys so the estimates measure the estimator rather than
+	// hash collisions between similar keys
+	for i := uint64(0); i < 3000; i++ {
+		a.AddUint64(i * 0x9e3779b97f4a7c15)
+		b.AddUint64((i + 2000) * 0x9e3779b97f4a7c15)
+	}
+	dataA, _ := a.MarshalBinary()
+	dataB, _ := b.MarshalBinary()
+
+	// embed places a filter at an offset so its payload has that alignment
+	embed := func(data []byte, offset int) *FilterView {
+		buf := make([]byte, offset+len(data))
+		copy(buf[offset:], data)
+		view, err := NewFilterView(buf[offset:])
+		if err != nil {
+			t.Fatalf("NewFilterView failed: %v", err)
+		}
+		return view
+	}
+
+	aligned := embed(dataA, 0)
+	union, _ := aligned.EstimateUnion(embed(dataB, 0))
+	intersection, _ := aligned.EstimateIntersection(embed(dataB, 0))
+	jaccard, _ := aligned.Jaccard(embed(dataB, 0))
+	if math.Abs(union-5000) > 250 || math.Abs(intersection-1000) > 250 || math.Abs(jaccard-0.2) > 0.05 {
+		t.Errorf("Expected union 5000, intersection 1000, Jaccard 0.2; got %.0f, %.0f, %.3f", union, intersection, jaccard)
+	}
+
+	for offsetA := 0; offsetA < 8; offsetA++ {
+		for offsetB := 0; offsetB < 8; offsetB++ {
+			t.Run(fmt.Sprintf("Offset_%d_%d", offsetA, offsetB), func(t *testing.T) {
+				viewA, viewB := embed(dataA, offsetA), embed(dataB, offsetB)
+				if got, err := viewA.EstimateUnion(viewB); got != union || err != nil {
+					t.Errorf("EstimateUnion: expected %f, got %f (%v)", union, got, err)
+				}
+				if got, err := viewA.EstimateIntersection(viewB); got != intersection || err != nil {
+					t.Errorf("EstimateIntersection: expected %f, got %f (%v)", intersection, got, err)
+				}
+				if got, err := viewA.Jaccard(viewB); got != jaccard || err != nil {
+					t.Errorf("Jaccard: expected %f, got %f (%v)", jaccard, got, err)
+				}
+			})
+		}
+
+		viewA := embed(dataA, offsetA)
+		if got, err := viewA.EstimateUnionFilter(b); got != union || err != nil {
+			t.Errorf("Offset %d: EstimateUnionFilter: expected %f, got %f (%v)", offsetA, union, got, err)
+		}
+		if got, err := viewA.EstimateIntersectionFilter(b); got != intersection || err != nil {
+			t.Errorf("Offset %d: EstimateIntersectionFilter: expected %f, got %f (%v)", offsetA, intersection, got, err)
+		}
+		if got, err := viewA.JaccardFilter(b); got != jaccard || err != nil {
+			t.Errorf("Offset %d: JaccardFilter: expected %f, got %f (%v)", offsetA, jaccard, got, err)
+		}
+	}
+
+	if got, _ := aligned.Jaccard(aligned); math.Abs(got-1) > 1e-9 {
+		t.Errorf("Expected a filter to be identical to itself, got Jaccard %f", got)
+	}
+	empty := NewCacheOptimizedBloomFilter(10000, 0.01)
+	if got, err := embed(dataA, 0).JaccardFilter(empty); got != 0 || err != nil {
+		t.Errorf("Expected Jaccard 0 against an empty filter, got %f (%v)", got, err)
+	}
+	if _, err := aligned.EstimateUnionFilter(NewCacheOptimizedBloomFilter(1000, 0.01)); err == nil {
+		t.Error("Expected error for filters of different sizes")
+	}
+}